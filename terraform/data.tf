@@ -1,7 +1,9 @@
+data "aws_partition" "current" {}
+
 data "aws_iam_policy" "AmazonSSMReadOnlyAccess" {
-  arn = "arn:aws:iam::aws:policy/AmazonSSMReadOnlyAccess"
+  arn = "arn:${data.aws_partition.current.partition}:iam::aws:policy/AmazonSSMReadOnlyAccess"
 }
 
 data "aws_iam_policy" "KMSReadOnlyAccess" {
-  arn = "arn:aws:iam::aws:policy/service-role/ROSAKMSProviderPolicy"
+  arn = "arn:${data.aws_partition.current.partition}:iam::aws:policy/service-role/ROSAKMSProviderPolicy"
 }
\ No newline at end of file
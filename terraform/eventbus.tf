@@ -10,6 +10,7 @@ resource "aws_cloudwatch_event_bus_policy" "test" {
   policy = templatefile("${path.module}/policy/event_bus_resource_policy.tpl", {
     event_bus_name = var.event_bus_name,
     aws_region = var.aws_region,
+    aws_partition = data.aws_partition.current.partition,
     aws_account = var.aws_account,
     aws_org_id = var.aws_org_id
   })
@@ -23,6 +23,7 @@ resource "aws_iam_role" "event_bus_lambda_rule" {
     event_bus_name = var.event_bus_name,
     rule_name = aws_cloudwatch_event_rule.lambda_rule.name,
     aws_region = var.aws_region,
+    aws_partition = data.aws_partition.current.partition,
     aws_account = var.aws_account,
   })
 }
@@ -33,6 +34,7 @@ resource "aws_iam_policy" "event_bus_rule_policy" {
   policy = templatefile("${path.module}/policy/event_bus_execution_role_policy.tpl", {
     lambda_function_name = var.lambda_invoke_step_function_name,
     aws_region = var.aws_region,
+    aws_partition = data.aws_partition.current.partition,
     aws_account = var.aws_account,
   })
 }
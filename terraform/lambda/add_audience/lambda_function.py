@@ -7,12 +7,15 @@ from botocore.exceptions import ClientError
 logger = logging.getLogger()
 logger.setLevel(logging.INFO)
 
-OIDC_PROVIDER_ARN_TEMPLATE = "arn:aws:iam::{account_id}:oidc-provider/{oidc_url}"
-CROSS_ACCOUNT_ROLE_ARN_TEMPLATE = "arn:aws:iam::{account_id}:role/{cross_account_role_name}"
+OIDC_PROVIDER_ARN_TEMPLATE = "arn:{partition}:iam::{account_id}:oidc-provider/{oidc_url}"
+CROSS_ACCOUNT_ROLE_ARN_TEMPLATE = "arn:{partition}:iam::{account_id}:role/{cross_account_role_name}"
+
+# Cross-account roles and OIDC providers live in the same partition (aws, aws-us-gov, aws-cn) as this function
+PARTITION = boto3.session.Session().get_partition_for_region(os.environ.get("AWS_REGION", "us-east-1"))
 
 def assume_role(account_id, role_name):
     sts_client = boto3.client("sts")
-    role_arn = CROSS_ACCOUNT_ROLE_ARN_TEMPLATE.format(account_id=account_id, cross_account_role_name=role_name)
+    role_arn = CROSS_ACCOUNT_ROLE_ARN_TEMPLATE.format(partition=PARTITION, account_id=account_id, cross_account_role_name=role_name)
     try:
         response = sts_client.assume_role(
             RoleArn=role_arn,
@@ -25,7 +28,7 @@ def assume_role(account_id, role_name):
         raise
 
 def add_audience(iam_client, account_id, audience, oidc_url):
-    oidc_provider_arn = OIDC_PROVIDER_ARN_TEMPLATE.format(account_id=account_id, oidc_url=oidc_url)
+    oidc_provider_arn = OIDC_PROVIDER_ARN_TEMPLATE.format(partition=PARTITION, account_id=account_id, oidc_url=oidc_url)
     try:        
         response = iam_client.add_client_id_to_open_id_connect_provider(
             OpenIDConnectProviderArn=oidc_provider_arn,
@@ -40,11 +43,24 @@ def add_audience(iam_client, account_id, audience, oidc_url):
 def lambda_handler(event, context):
     logger.info(f"Received event: {json.dumps(event)}")
 
+    # A dry run of the create step leaves the member account alone too
+    if event.get("dryRun") or event.get("sfnParam", {}).get("dryRun"):
+        logger.info("Dry run, not changing the member account")
+        return {"status": "planned", "dryRun": True}
+
+    # Roles the create step skipped have no audience. The state machine doesn't
+    # run this step for them either, but a missing status never counts as success.
+    status = event.get("status")
+    if status != "success":
+        logger.info(f"Nothing to do, the create step returned status {status}")
+        return {"status": "skipped", "reason": f"create step returned status {status}"}
+
     sfn_param = event.get("sfnParam", {})
     account_id = sfn_param.get("account")
+    # The Go step returns the full audience: api://<appId> for v1 tokens, the bare appId for v2
     audience = event.get("audience")
-    audience = f"api://{audience}"
-    oidc_url = os.environ.get("OIDC_URL")
+    # The Go step returns the OIDC URL of the Entra tenant that served the role
+    oidc_url = event.get("oidcUrl") or os.environ.get("OIDC_URL")
     role_name = os.environ.get("CROSS_ACCOUNT_ROLE_NAME")
 
     if not account_id or not audience or not oidc_url:
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/approval/src/logging"
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/approval/src/metrics"
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/approval/src/signedlink"
+)
+
+// Decisions accepted from approvers
+const (
+	decisionApprove = "approve"
+	decisionReject  = "reject"
+)
+
+var errInvalidDecision = errors.New("invalid decision")
+
+// apiDecision is a decision made by invoking the function directly, e.g. with
+// aws lambda invoke. Invoking requires lambda:InvokeFunction, so the caller's
+// IAM permissions take the place of a signed link.
+type apiDecision struct {
+	ApprovalID string `json:"approvalId"`
+	Decision   string `json:"decision"`
+	Approver   string `json:"approver"`
+	Reason     string `json:"reason"`
+}
+
+func handleAPI(ctx context.Context, recorder *metrics.Recorder, req apiDecision) (Response, error) {
+	logger := logging.ForInvocation(ctx, baseLogger,
+		slog.String("approvalId", req.ApprovalID),
+		slog.String("approver", req.Approver),
+	)
+
+	// There is no caller identity on a direct invocation, so the approver
+	// names themselves for the audit trail
+	if req.Approver == "" {
+		err := errors.New("approver is required")
+		logger.Error("Error deciding approval", "error", err)
+		return Response{StatusCode: 400, ApprovalID: req.ApprovalID}, err
+	}
+
+	a, err := decide(ctx, logger, recorder, req.ApprovalID, req.Decision, req.Approver, req.Reason)
+	if err != nil {
+		logger.Error("Error deciding approval", "error", err)
+		return Response{StatusCode: statusCodeFor(err), ApprovalID: req.ApprovalID}, err
+	}
+
+	return Response{StatusCode: 200, ApprovalID: a.ID, Status: a.Status}, nil
+}
+
+// decide approves or rejects a pending approval and answers the waiting task
+func decide(ctx context.Context, logger *slog.Logger, recorder *metrics.Recorder, id, decision, decidedBy, reason string) (approval, error) {
+	var status string
+	switch decision {
+	case decisionApprove:
+		status = statusApproved
+	case decisionReject:
+		status = statusRejected
+	default:
+		return approval{}, fmt.Errorf("%w %q, expected approve or reject", errInvalidDecision, decision)
+	}
+
+	a, err := decideApproval(ctx, id, status, decidedBy, reason, time.Now())
+	if err != nil {
+		return approval{}, err
+	}
+	logger = logger.With("account", a.Account, "role", a.RoleName, "executionArn", a.ExecutionArn)
+	recorder.SetDimension("Account", a.Account)
+
+	err = answerTask(ctx, logger, a)
+	if err != nil {
+		return a, err
+	}
+
+	logger.Info("Approval decided", "status", a.Status, "decidedBy", decidedBy, "reason", reason)
+	if a.Status == statusApproved {
+		recorder.Count(metrics.ApprovalsApproved)
+	} else {
+		recorder.Count(metrics.ApprovalsRejected)
+	}
+	return a, nil
+}
+
+// statusCodeFor maps decision errors to HTTP status codes
+func statusCodeFor(err error) int {
+	switch {
+	case errors.Is(err, errInvalidDecision):
+		return 400
+	case errors.Is(err, signedlink.ErrBadSignature):
+		return 403
+	case errors.Is(err, errNotFound):
+		return 404
+	case errors.Is(err, errNotPending):
+		return 409
+	case errors.Is(err, signedlink.ErrExpired), errors.Is(err, errTaskGone):
+		return 410
+	}
+	return 500
+}
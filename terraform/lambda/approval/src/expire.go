@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/approval/src/logging"
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/approval/src/metrics"
+)
+
+// expirySweeper is recorded as the approver of expired approvals
+const expirySweeper = "expiry"
+
+// expireApprovals fails the tasks of approvals that were not decided in time.
+// It runs on a schedule, so an approval expires at most one schedule interval
+// after its deadline. Failures are logged and retried on the next run.
+func expireApprovals(ctx context.Context, recorder *metrics.Recorder) (Response, error) {
+	logger := logging.ForInvocation(ctx, baseLogger)
+
+	now := time.Now()
+	ids, err := overdueApprovals(ctx, now)
+	if err != nil {
+		logger.Error("Error listing overdue approvals", "error", err)
+		return Response{StatusCode: 500}, err
+	}
+
+	expired := 0
+	var errs []error
+	for _, id := range ids {
+		approvalLogger := logger.With("approvalId", id)
+
+		a, err := decideApproval(ctx, id, statusExpired, expirySweeper, "", now)
+		if errors.Is(err, errNotPending) {
+			// Decided since the scan
+			continue
+		}
+		if err != nil {
+			approvalLogger.Error("Error expiring approval", "error", err)
+			errs = append(errs, err)
+			continue
+		}
+		approvalLogger = approvalLogger.With("account", a.Account, "role", a.RoleName, "executionArn", a.ExecutionArn)
+
+		err = answerTask(ctx, approvalLogger, a)
+		if err != nil && !errors.Is(err, errTaskGone) {
+			approvalLogger.Error("Error failing task of expired approval", "error", err)
+			errs = append(errs, err)
+			continue
+		}
+
+		approvalLogger.Info("Approval expired")
+		recorder.Count(metrics.ApprovalsExpired)
+		expired++
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return Response{StatusCode: 500, Expired: expired}, err
+	}
+	return Response{StatusCode: 200, Expired: expired}, nil
+}
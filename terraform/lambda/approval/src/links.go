@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/approval/src/signedlink"
+
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// linkSettings are the Function URL and signing key of approval links. Both are
+// read from SSM on first use and kept for the life of the execution
+// environment.
+type linkSettings struct {
+	baseURL string
+	signer  signedlink.Signer
+}
+
+var (
+	linksMu sync.Mutex
+	links   *linkSettings
+)
+
+// getLinkSettings returns the approval link settings. APPROVAL_URL names the
+// base URL directly, for a custom domain in front of the Function URL;
+// otherwise it is read from the APPROVAL_URL_SSM parameter, which Terraform
+// writes once the Function URL exists. APPROVAL_SIGNING_KEY_SSM names the
+// SecureString parameter holding the signing key.
+func getLinkSettings(ctx context.Context, logger *slog.Logger) (*linkSettings, error) {
+	linksMu.Lock()
+	defer linksMu.Unlock()
+
+	if links != nil {
+		return links, nil
+	}
+
+	baseURL := os.Getenv("APPROVAL_URL")
+	if baseURL == "" {
+		value, err := getSSMParamValue(ctx, logger, os.Getenv("APPROVAL_URL_SSM"))
+		if err != nil {
+			return nil, err
+		}
+		baseURL = value
+	}
+
+	key, err := getSSMParamValue(ctx, logger, os.Getenv("APPROVAL_SIGNING_KEY_SSM"))
+	if err != nil {
+		return nil, err
+	}
+	if len(key) < 32 {
+		return nil, errors.New("approval signing key must be at least 32 characters")
+	}
+
+	links = &linkSettings{baseURL: baseURL, signer: signedlink.Signer{Key: []byte(key)}}
+	return links, nil
+}
+
+// link returns the signed link that makes decision on approval a. The link is
+// valid until the approval expires.
+func (s *linkSettings) link(a approval, decision string) string {
+	return s.baseURL + "?" + s.signer.Query(a.ID, decision, a.ExpiresAt).Encode()
+}
+
+// verify checks the signature and expiry of a link's query parameters and
+// returns the approval ID and decision it carries
+func (s *linkSettings) verify(query url.Values, now time.Time) (string, string, error) {
+	return s.signer.Verify(query, now)
+}
+
+func getSSMParamValue(ctx context.Context, logger *slog.Logger, name string) (string, error) {
+	withDecryption := true
+	resp, err := ssmClient.GetParameter(ctx, &ssm.GetParameterInput{
+		Name:           &name,
+		WithDecryption: &withDecryption,
+	})
+	if err != nil {
+		logger.Error("Error getting parameter", "error", err)
+		return "", err
+	}
+	if resp == nil || resp.Parameter == nil {
+		logger.Error("Parameter not found", "parameter", name)
+		return "", errors.New("parameter not found")
+	}
+	return *resp.Parameter.Value, nil
+}
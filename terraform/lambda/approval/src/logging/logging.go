@@ -0,0 +1,71 @@
+// Package logging sets up structured JSON logging for the Lambda handlers.
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+)
+
+// Redacted replaces the value of a sensitive attribute, header or JSON field
+const Redacted = "[REDACTED]"
+
+// sensitiveKeys are key fragments whose values are never logged, such as
+// passwordCredentials, secretText or the Authorization header
+var sensitiveKeys = []string{"secret", "password", "authorization", "cookie", "credential", "assertion"}
+
+// New returns a JSON logger writing to stdout. Attributes whose key looks like
+// it holds a secret are redacted. LOG_LEVEL selects the minimum level.
+func New() *slog.Logger {
+	level := slog.LevelInfo
+	if err := level.UnmarshalText([]byte(os.Getenv("LOG_LEVEL"))); err != nil {
+		level = slog.LevelInfo
+	}
+
+	return slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redact,
+	}))
+}
+
+// ForInvocation returns a logger carrying the Lambda request ID and the given
+// correlation attributes. Empty string attributes are left out.
+func ForInvocation(ctx context.Context, logger *slog.Logger, attrs ...slog.Attr) *slog.Logger {
+	args := []any{}
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		args = append(args, slog.String("requestId", lc.AwsRequestID))
+	}
+	for _, attr := range attrs {
+		if attr.Value.Kind() == slog.KindString && attr.Value.String() == "" {
+			continue
+		}
+		args = append(args, attr)
+	}
+	return logger.With(args...)
+}
+
+func redact(groups []string, a slog.Attr) slog.Attr {
+	if Sensitive(a.Key) {
+		return slog.String(a.Key, Redacted)
+	}
+	return a
+}
+
+// Sensitive reports whether the value of a log attribute, HTTP header or JSON
+// field with this name must not be logged.
+func Sensitive(name string) bool {
+	name = strings.ToLower(name)
+	// accessToken, taskToken and the like, but not tokenVersion
+	if strings.HasSuffix(name, "token") {
+		return true
+	}
+	for _, sensitive := range sensitiveKeys {
+		if strings.Contains(name, sensitive) {
+			return true
+		}
+	}
+	return false
+}
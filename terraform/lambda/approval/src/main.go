@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/approval/src/logging"
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/approval/src/metrics"
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/approval/src/tracing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/sfn"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/aws/aws-sdk-go-v2/otelaws"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// DefaultTimeout is how long an approval stays open unless APPROVAL_TIMEOUT says otherwise
+const DefaultTimeout = 24 * time.Hour
+
+// Response structure. Function URL invocations only use StatusCode, Headers
+// and Body, which Lambda turns into the HTTP response.
+type Response struct {
+	StatusCode int               `json:"statusCode"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Body       string            `json:"body,omitempty"`
+	ApprovalID string            `json:"approvalId,omitempty"`
+	Status     string            `json:"status,omitempty"`
+	Expired    int               `json:"expired,omitempty"`
+}
+
+// invocation holds the fields that tell the kinds of event apart: a task
+// token from Step Functions, a decision made through the API, a Function URL
+// request or the scheduled expiry sweep.
+type invocation struct {
+	TaskToken      string          `json:"taskToken"`
+	ApprovalID     string          `json:"approvalId"`
+	Decision       string          `json:"decision"`
+	RequestContext json.RawMessage `json:"requestContext"`
+	DetailType     string          `json:"detail-type"`
+}
+
+var (
+	dynamoClient   *dynamodb.Client
+	sfnClient      *sfn.Client
+	snsClient      *sns.Client
+	ssmClient      *ssm.Client
+	baseLogger     *slog.Logger
+	tracerProvider *sdktrace.TracerProvider
+
+	table    string
+	topicArn string
+	timeout  time.Duration
+	accounts []string
+)
+
+func init() {
+	baseLogger = logging.New()
+
+	cfg, err := config.LoadDefaultConfig(context.TODO())
+	if err != nil {
+		baseLogger.Error("unable to load SDK config", "error", err)
+		os.Exit(1)
+	}
+
+	tracerProvider, err = tracing.Init(context.TODO(), "approval")
+	if err != nil {
+		baseLogger.Error("unable to set up tracing", "error", err)
+		os.Exit(1)
+	}
+	if tracerProvider != nil {
+		// Spans for DynamoDB, SNS, SSM and Step Functions calls
+		otelaws.AppendMiddlewares(&cfg.APIOptions)
+	}
+
+	table = os.Getenv("APPROVAL_TABLE")
+	topicArn = os.Getenv("APPROVAL_TOPIC_ARN")
+	if table == "" || topicArn == "" {
+		baseLogger.Error("APPROVAL_TABLE and APPROVAL_TOPIC_ARN must be set")
+		os.Exit(1)
+	}
+
+	timeout = DefaultTimeout
+	if value := os.Getenv("APPROVAL_TIMEOUT"); value != "" {
+		timeout, err = time.ParseDuration(value)
+		if err != nil || timeout <= 0 {
+			baseLogger.Error("invalid APPROVAL_TIMEOUT", "value", value, "error", err)
+			os.Exit(1)
+		}
+	}
+
+	for _, account := range strings.Split(os.Getenv("APPROVAL_ACCOUNTS"), ",") {
+		if account = strings.TrimSpace(account); account != "" {
+			accounts = append(accounts, account)
+		}
+	}
+
+	dynamoClient = dynamodb.NewFromConfig(cfg)
+	sfnClient = sfn.NewFromConfig(cfg)
+	snsClient = sns.NewFromConfig(cfg)
+	ssmClient = ssm.NewFromConfig(cfg)
+}
+
+func handleRequest(ctx context.Context, event json.RawMessage) (Response, error) {
+
+	recorder := metrics.New("Approval")
+	defer flushMetrics(ctx, recorder)
+
+	var inv invocation
+	err := json.Unmarshal(event, &inv)
+	if err != nil {
+		logging.ForInvocation(ctx, baseLogger).Error("Error unmarshalling event", "error", err)
+		return Response{StatusCode: 400}, err
+	}
+
+	switch {
+	case inv.TaskToken != "":
+		var req approvalRequest
+		if err := json.Unmarshal(event, &req); err != nil {
+			logging.ForInvocation(ctx, baseLogger).Error("Error unmarshalling approval request", "error", err)
+			return Response{StatusCode: 400}, err
+		}
+		return requestApproval(ctx, recorder, req)
+
+	case len(inv.RequestContext) > 0:
+		var req events.LambdaFunctionURLRequest
+		if err := json.Unmarshal(event, &req); err != nil {
+			logging.ForInvocation(ctx, baseLogger).Error("Error unmarshalling Function URL request", "error", err)
+			return htmlResponse(400, "Bad request", "The request could not be read."), nil
+		}
+		return handleURL(ctx, recorder, req), nil
+
+	case inv.ApprovalID != "":
+		var req apiDecision
+		if err := json.Unmarshal(event, &req); err != nil {
+			logging.ForInvocation(ctx, baseLogger).Error("Error unmarshalling decision", "error", err)
+			return Response{StatusCode: 400}, err
+		}
+		return handleAPI(ctx, recorder, req)
+
+	case inv.DetailType == "Scheduled Event":
+		return expireApprovals(ctx, recorder)
+	}
+
+	err = errors.New("unrecognised event")
+	logging.ForInvocation(ctx, baseLogger).Error("Error handling event", "error", err)
+	return Response{StatusCode: 400}, err
+}
+
+// flushMetrics writes the invocation's metrics. A failure only loses metrics, so
+// it is logged rather than failing the invocation.
+func flushMetrics(ctx context.Context, recorder *metrics.Recorder) {
+	if err := recorder.Flush(); err != nil {
+		logging.ForInvocation(ctx, baseLogger).Warn("Error writing metrics", "error", err)
+	}
+}
+
+// requiresApproval reports whether roles in account wait for a decision. With
+// no APPROVAL_ACCOUNTS set, every role the state machine sends here does.
+func requiresApproval(account string) bool {
+	if len(accounts) == 0 {
+		return true
+	}
+	for _, candidate := range accounts {
+		if candidate == account {
+			return true
+		}
+	}
+	return false
+}
+
+func main() {
+	lambda.Start(tracing.Wrap("Approval", tracerProvider, handleRequest))
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+)
+
+// maxSubject is the longest subject SNS accepts
+const maxSubject = 100
+
+// notifyApprovers publishes the approval request to APPROVAL_TOPIC_ARN with a
+// signed link for each decision and the equivalent API call
+func notifyApprovers(ctx context.Context, logger *slog.Logger, a approval) error {
+	links, err := getLinkSettings(ctx, logger)
+	if err != nil {
+		return err
+	}
+
+	creator := a.Creator
+	if creator == "" {
+		creator = "unknown"
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "An Entra ID application is waiting for approval.\n\n")
+	fmt.Fprintf(&msg, "Role:       %s\n", a.RoleName)
+	if a.RoleArn != "" {
+		fmt.Fprintf(&msg, "Role ARN:   %s\n", a.RoleArn)
+	}
+	fmt.Fprintf(&msg, "Account:    %s\n", a.Account)
+	fmt.Fprintf(&msg, "Created by: %s\n", creator)
+	if a.ExecutionArn != "" {
+		fmt.Fprintf(&msg, "Execution:  %s\n", a.ExecutionArn)
+	}
+	fmt.Fprintf(&msg, "Expires:    %s\n\n", a.ExpiresAt.UTC().Format(time.RFC3339))
+	fmt.Fprintf(&msg, "Approve: %s\n\n", links.link(a, decisionApprove))
+	fmt.Fprintf(&msg, "Reject: %s\n\n", links.link(a, decisionReject))
+	fmt.Fprintf(&msg, "Or decide with the AWS CLI:\n\n")
+	fmt.Fprintf(&msg, "aws lambda invoke --function-name %s --cli-binary-format raw-in-base64-out \\\n", os.Getenv("AWS_LAMBDA_FUNCTION_NAME"))
+	fmt.Fprintf(&msg, "  --payload '{\"approvalId\":\"%s\",\"decision\":\"approve\",\"approver\":\"<your name>\",\"reason\":\"<reason>\"}' response.json\n", a.ID)
+
+	subject := fmt.Sprintf("Approval requested: %s in %s", a.RoleName, a.Account)
+	if len(subject) > maxSubject {
+		subject = subject[:maxSubject]
+	}
+
+	_, err = snsClient.Publish(ctx, &sns.PublishInput{
+		TopicArn: aws.String(topicArn),
+		Subject:  aws.String(subject),
+		Message:  aws.String(msg.String()),
+	})
+	return err
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/approval/src/logging"
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/approval/src/metrics"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sfn"
+	"github.com/aws/aws-sdk-go-v2/service/sfn/types"
+)
+
+// Errors the approval task fails with, for Catch clauses in the state machine
+const (
+	errorRejected = "ApprovalRejected"
+	errorExpired  = "ApprovalExpired"
+)
+
+// errTaskGone means the execution stopped waiting for the task token, because
+// it timed out, was stopped or already received a result
+var errTaskGone = errors.New("execution is no longer waiting for this approval")
+
+// approvalRequest is the payload of the .waitForTaskToken task: the task token,
+// the execution and the workflow input
+type approvalRequest struct {
+	TaskToken    string `json:"taskToken"`
+	ExecutionArn string `json:"executionArn"`
+	Input        struct {
+		Account  string `json:"account"`
+		RoleName string `json:"roleName"`
+		RoleArn  string `json:"roleArn"`
+		Creator  string `json:"creator"`
+		EventID  string `json:"eventID"`
+	} `json:"input"`
+}
+
+// taskOutput is the task's result when the role is approved or needs no approval
+type taskOutput struct {
+	Status     string `json:"status"`
+	ApprovalID string `json:"approvalId,omitempty"`
+	DecidedBy  string `json:"decidedBy,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+}
+
+// requestApproval stores the task token and asks the approvers for a decision.
+// The execution waits until the token is answered by a decision or the expiry
+// sweep.
+func requestApproval(ctx context.Context, recorder *metrics.Recorder, req approvalRequest) (Response, error) {
+	logger := logging.ForInvocation(ctx, baseLogger,
+		slog.String("executionArn", req.ExecutionArn),
+		slog.String("eventId", req.Input.EventID),
+		slog.String("account", req.Input.Account),
+		slog.String("role", req.Input.RoleName),
+	)
+	recorder.SetDimension("Account", req.Input.Account)
+
+	if !requiresApproval(req.Input.Account) {
+		output, _ := json.Marshal(taskOutput{Status: "notRequired"})
+		_, err := sfnClient.SendTaskSuccess(ctx, &sfn.SendTaskSuccessInput{
+			TaskToken: aws.String(req.TaskToken),
+			Output:    aws.String(string(output)),
+		})
+		if err != nil {
+			logger.Error("Error sending task success", "error", err)
+			return Response{StatusCode: 500}, err
+		}
+		logger.Info("Account does not require approval")
+		return Response{StatusCode: 200, Status: "notRequired"}, nil
+	}
+
+	id, err := newApprovalID()
+	if err != nil {
+		logger.Error("Error generating approval ID", "error", err)
+		return Response{StatusCode: 500}, err
+	}
+	logger = logger.With("approvalId", id)
+
+	now := time.Now()
+	a := approval{
+		ID:           id,
+		TaskToken:    req.TaskToken,
+		Account:      req.Input.Account,
+		RoleName:     req.Input.RoleName,
+		RoleArn:      req.Input.RoleArn,
+		Creator:      req.Input.Creator,
+		ExecutionArn: req.ExecutionArn,
+		EventID:      req.Input.EventID,
+		Status:       statusPending,
+		RequestedAt:  now,
+		ExpiresAt:    now.Add(timeout),
+	}
+
+	err = putApproval(ctx, a)
+	if err != nil {
+		logger.Error("Error storing approval", "error", err)
+		return Response{StatusCode: 500}, err
+	}
+
+	err = notifyApprovers(ctx, logger, a)
+	if err != nil {
+		logger.Error("Error sending approval request", "error", err)
+		return Response{StatusCode: 500}, err
+	}
+
+	logger.Info("Approval requested", "creator", a.Creator, "expiresAt", a.ExpiresAt.UTC().Format(time.RFC3339))
+	recorder.Count(metrics.ApprovalsRequested)
+
+	return Response{StatusCode: 202, ApprovalID: id, Status: statusPending}, nil
+}
+
+// answerTask sends a decided approval's result to Step Functions. When that
+// fails for any reason but the execution having moved on, the approval is
+// reopened so the decision can be made again.
+func answerTask(ctx context.Context, logger *slog.Logger, a approval) error {
+	err := sendTaskResult(ctx, a)
+	if isTaskGone(err) {
+		logger.Warn("Execution is no longer waiting for the approval", "error", err)
+		return errTaskGone
+	}
+	if err != nil {
+		if reopenErr := reopenApproval(ctx, a.ID, a.Status); reopenErr != nil {
+			logger.Error("Error reopening approval", "error", reopenErr)
+		}
+		return err
+	}
+	return nil
+}
+
+func sendTaskResult(ctx context.Context, a approval) error {
+	if a.Status == statusApproved {
+		output, err := json.Marshal(taskOutput{Status: a.Status, ApprovalID: a.ID, DecidedBy: a.DecidedBy, Reason: a.Reason})
+		if err != nil {
+			return err
+		}
+		_, err = sfnClient.SendTaskSuccess(ctx, &sfn.SendTaskSuccessInput{
+			TaskToken: aws.String(a.TaskToken),
+			Output:    aws.String(string(output)),
+		})
+		return err
+	}
+
+	code, cause := errorRejected, "rejected by "+a.DecidedBy
+	if a.Status == statusExpired {
+		code, cause = errorExpired, "no decision before "+a.ExpiresAt.UTC().Format(time.RFC3339)
+	}
+	if a.Reason != "" {
+		cause += ": " + a.Reason
+	}
+	_, err := sfnClient.SendTaskFailure(ctx, &sfn.SendTaskFailureInput{
+		TaskToken: aws.String(a.TaskToken),
+		Error:     aws.String(code),
+		Cause:     aws.String(cause),
+	})
+	return err
+}
+
+func isTaskGone(err error) bool {
+	var timedOut *types.TaskTimedOut
+	var notExist *types.TaskDoesNotExist
+	var invalid *types.InvalidToken
+	return errors.As(err, &timedOut) || errors.As(err, &notExist) || errors.As(err, &invalid)
+}
@@ -0,0 +1,68 @@
+// Package signedlink signs and verifies the approve and reject links sent to
+// approvers. A link carries the approval ID, the decision and an expiry,
+// signed with HMAC-SHA256 so none of them can be changed.
+package signedlink
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrBadSignature = errors.New("approval link signature is invalid")
+	ErrExpired      = errors.New("approval link has expired")
+)
+
+// Signer signs links with a secret key
+type Signer struct {
+	Key []byte
+}
+
+// Query returns the signed query parameters of the link that makes decision
+// on the approval with the given ID. The link is valid until expires.
+func (s Signer) Query(id, decision string, expires time.Time) url.Values {
+	unix := expires.Unix()
+	return url.Values{
+		"id":        {id},
+		"decision":  {decision},
+		"expires":   {strconv.FormatInt(unix, 10)},
+		"signature": {s.sign(id, decision, unix)},
+	}
+}
+
+// Verify checks the signature and expiry of a link's query parameters and
+// returns the approval ID and decision it carries. An expired link still
+// returns them along with ErrExpired.
+func (s Signer) Verify(query url.Values, now time.Time) (string, string, error) {
+	id, decision := query.Get("id"), query.Get("decision")
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil || id == "" || decision == "" {
+		return "", "", ErrBadSignature
+	}
+
+	signature, err := hex.DecodeString(query.Get("signature"))
+	if err != nil {
+		return "", "", ErrBadSignature
+	}
+	expected, _ := hex.DecodeString(s.sign(id, decision, expires))
+	if !hmac.Equal(signature, expected) {
+		return "", "", ErrBadSignature
+	}
+
+	if now.Unix() >= expires {
+		return id, decision, ErrExpired
+	}
+	return id, decision, nil
+}
+
+// sign is the HMAC-SHA256 of the approval ID, decision and expiry
+func (s Signer) sign(id, decision string, expires int64) string {
+	mac := hmac.New(sha256.New, s.Key)
+	mac.Write([]byte(id + "\n" + decision + "\n" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
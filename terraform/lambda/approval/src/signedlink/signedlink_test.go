@@ -0,0 +1,89 @@
+package signedlink
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testSigner = Signer{Key: []byte("0123456789abcdef0123456789abcdef")}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	expires := now.Add(time.Hour)
+
+	tests := []struct {
+		name   string
+		modify func(q url.Values)
+		now    time.Time
+		want   error
+	}{
+		{name: "valid", now: now},
+		{name: "valid until the last second", now: expires.Add(-time.Second)},
+		{name: "expired", now: expires, want: ErrExpired},
+		{name: "long expired", now: now.Add(48 * time.Hour), want: ErrExpired},
+		{name: "decision changed", now: now, want: ErrBadSignature,
+			modify: func(q url.Values) { q.Set("decision", "reject") }},
+		{name: "id changed", now: now, want: ErrBadSignature,
+			modify: func(q url.Values) { q.Set("id", "approval-2") }},
+		{name: "expiry extended", now: expires.Add(time.Hour), want: ErrBadSignature,
+			modify: func(q url.Values) { q.Set("expires", "1700086400") }},
+		{name: "signature changed", now: now, want: ErrBadSignature,
+			modify: func(q url.Values) { q.Set("signature", strings.Repeat("0", len(q.Get("signature")))) }},
+		{name: "signature truncated", now: now, want: ErrBadSignature,
+			modify: func(q url.Values) { q.Set("signature", q.Get("signature")[:32]) }},
+		{name: "signature not hex", now: now, want: ErrBadSignature,
+			modify: func(q url.Values) { q.Set("signature", "not-hex") }},
+		{name: "signature missing", now: now, want: ErrBadSignature,
+			modify: func(q url.Values) { q.Del("signature") }},
+		{name: "expiry not a number", now: now, want: ErrBadSignature,
+			modify: func(q url.Values) { q.Set("expires", "tomorrow") }},
+		{name: "id missing", now: now, want: ErrBadSignature,
+			modify: func(q url.Values) { q.Del("id") }},
+		{name: "decision missing", now: now, want: ErrBadSignature,
+			modify: func(q url.Values) { q.Del("decision") }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := testSigner.Query("approval-1", "approve", expires)
+			if tt.modify != nil {
+				tt.modify(q)
+			}
+
+			id, decision, err := testSigner.Verify(q, tt.now)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Verify() error = %v, want %v", err, tt.want)
+			}
+			if tt.want != ErrBadSignature && (id != "approval-1" || decision != "approve") {
+				t.Fatalf("Verify() = %q, %q, want approval-1, approve", id, decision)
+			}
+		})
+	}
+}
+
+func TestVerifyOtherKey(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	q := testSigner.Query("approval-1", "approve", now.Add(time.Hour))
+
+	other := Signer{Key: []byte("fedcba9876543210fedcba9876543210")}
+	if _, _, err := other.Verify(q, now); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("Verify() with another key error = %v, want %v", err, ErrBadSignature)
+	}
+}
+
+func TestQueryRoundTrip(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	encoded := testSigner.Query("id with spaces&=", "reject", now.Add(time.Minute)).Encode()
+
+	q, err := url.ParseQuery(encoded)
+	if err != nil {
+		t.Fatalf("ParseQuery() error = %v", err)
+	}
+	id, decision, err := testSigner.Verify(q, now)
+	if err != nil || id != "id with spaces&=" || decision != "reject" {
+		t.Fatalf("Verify() = %q, %q, %v", id, decision, err)
+	}
+}
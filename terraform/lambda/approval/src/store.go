@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Approval statuses
+const (
+	statusPending  = "pending"
+	statusApproved = "approved"
+	statusRejected = "rejected"
+	statusExpired  = "expired"
+)
+
+// recordRetention is how long decided approvals are kept for audit before the
+// table's TTL removes them
+const recordRetention = 30 * 24 * time.Hour
+
+var (
+	errNotFound   = errors.New("approval not found")
+	errNotPending = errors.New("approval already decided or expired")
+)
+
+// approval is an approval request as stored in the APPROVAL_TABLE table, keyed
+// by approvalId. The task token is only ever read back to answer Step Functions.
+type approval struct {
+	ID           string
+	TaskToken    string
+	Account      string
+	RoleName     string
+	RoleArn      string
+	Creator      string
+	ExecutionArn string
+	EventID      string
+	Status       string
+	RequestedAt  time.Time
+	ExpiresAt    time.Time
+	DecidedBy    string
+	Reason       string
+}
+
+func newApprovalID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// putApproval stores a new pending approval
+func putApproval(ctx context.Context, a approval) error {
+	item := map[string]types.AttributeValue{
+		"approvalId":  &types.AttributeValueMemberS{Value: a.ID},
+		"taskToken":   &types.AttributeValueMemberS{Value: a.TaskToken},
+		"account":     &types.AttributeValueMemberS{Value: a.Account},
+		"roleName":    &types.AttributeValueMemberS{Value: a.RoleName},
+		"status":      &types.AttributeValueMemberS{Value: a.Status},
+		"requestedAt": &types.AttributeValueMemberS{Value: a.RequestedAt.UTC().Format(time.RFC3339)},
+		"expiresAt":   unixTime(a.ExpiresAt),
+		"ttl":         unixTime(a.ExpiresAt.Add(recordRetention)),
+	}
+	// Optional fields are left out rather than stored empty
+	for name, value := range map[string]string{
+		"roleArn":      a.RoleArn,
+		"creator":      a.Creator,
+		"executionArn": a.ExecutionArn,
+		"eventId":      a.EventID,
+	} {
+		if value != "" {
+			item[name] = &types.AttributeValueMemberS{Value: value}
+		}
+	}
+
+	_, err := dynamoClient.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(table),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(approvalId)"),
+	})
+	return err
+}
+
+// getApproval reads an approval with a consistent read
+func getApproval(ctx context.Context, id string) (approval, error) {
+	resp, err := dynamoClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(table),
+		Key:            approvalKey(id),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return approval{}, err
+	}
+	if len(resp.Item) == 0 {
+		return approval{}, errNotFound
+	}
+	return fromItem(resp.Item), nil
+}
+
+// decideApproval moves a pending approval to status and returns it. Approvals
+// are only approved or rejected before they expire, and only expired after, so
+// a decision and the expiry sweep never both answer the same task.
+func decideApproval(ctx context.Context, id, status, decidedBy, reason string, now time.Time) (approval, error) {
+	condition := "#status = :pending AND expiresAt > :now"
+	if status == statusExpired {
+		condition = "#status = :pending AND expiresAt <= :now"
+	}
+
+	resp, err := dynamoClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(table),
+		Key:                 approvalKey(id),
+		UpdateExpression:    aws.String("SET #status = :status, decidedBy = :decidedBy, reason = :reason, decidedAt = :decidedAt"),
+		ConditionExpression: aws.String(condition),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":status":    &types.AttributeValueMemberS{Value: status},
+			":pending":   &types.AttributeValueMemberS{Value: statusPending},
+			":decidedBy": &types.AttributeValueMemberS{Value: decidedBy},
+			":reason":    &types.AttributeValueMemberS{Value: reason},
+			":decidedAt": &types.AttributeValueMemberS{Value: now.UTC().Format(time.RFC3339)},
+			":now":       unixTime(now),
+		},
+		ReturnValues:                        types.ReturnValueAllNew,
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	})
+	var conditionErr *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionErr) {
+		if len(conditionErr.Item) == 0 {
+			return approval{}, errNotFound
+		}
+		return approval{}, errNotPending
+	}
+	if err != nil {
+		return approval{}, err
+	}
+	return fromItem(resp.Attributes), nil
+}
+
+// reopenApproval puts a decided approval back to pending, so a decision that
+// could not be sent to Step Functions can be made again
+func reopenApproval(ctx context.Context, id, status string) error {
+	_, err := dynamoClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(table),
+		Key:                 approvalKey(id),
+		UpdateExpression:    aws.String("SET #status = :pending REMOVE decidedBy, reason, decidedAt"),
+		ConditionExpression: aws.String("#status = :status"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":status":  &types.AttributeValueMemberS{Value: status},
+			":pending": &types.AttributeValueMemberS{Value: statusPending},
+		},
+	})
+	return err
+}
+
+// overdueApprovals lists the IDs of pending approvals that expired by now. The
+// table only holds approvals of roles waiting for a decision plus recent
+// history, so a filtered scan stays cheap.
+func overdueApprovals(ctx context.Context, now time.Time) ([]string, error) {
+	paginator := dynamodb.NewScanPaginator(dynamoClient, &dynamodb.ScanInput{
+		TableName:            aws.String(table),
+		ProjectionExpression: aws.String("approvalId"),
+		FilterExpression:     aws.String("#status = :pending AND expiresAt <= :now"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":pending": &types.AttributeValueMemberS{Value: statusPending},
+			":now":     unixTime(now),
+		},
+	})
+
+	var ids []string
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page.Items {
+			ids = append(ids, stringAttr(item, "approvalId"))
+		}
+	}
+	return ids, nil
+}
+
+func approvalKey(id string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"approvalId": &types.AttributeValueMemberS{Value: id},
+	}
+}
+
+func unixTime(t time.Time) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: strconv.FormatInt(t.Unix(), 10)}
+}
+
+func fromItem(item map[string]types.AttributeValue) approval {
+	a := approval{
+		ID:           stringAttr(item, "approvalId"),
+		TaskToken:    stringAttr(item, "taskToken"),
+		Account:      stringAttr(item, "account"),
+		RoleName:     stringAttr(item, "roleName"),
+		RoleArn:      stringAttr(item, "roleArn"),
+		Creator:      stringAttr(item, "creator"),
+		ExecutionArn: stringAttr(item, "executionArn"),
+		EventID:      stringAttr(item, "eventId"),
+		Status:       stringAttr(item, "status"),
+		DecidedBy:    stringAttr(item, "decidedBy"),
+		Reason:       stringAttr(item, "reason"),
+	}
+	a.RequestedAt, _ = time.Parse(time.RFC3339, stringAttr(item, "requestedAt"))
+	if n, ok := item["expiresAt"].(*types.AttributeValueMemberN); ok {
+		if seconds, err := strconv.ParseInt(n.Value, 10, 64); err == nil {
+			a.ExpiresAt = time.Unix(seconds, 0)
+		}
+	}
+	return a
+}
+
+func stringAttr(item map[string]types.AttributeValue, name string) string {
+	if s, ok := item[name].(*types.AttributeValueMemberS); ok {
+		return s.Value
+	}
+	return ""
+}
+
+// String identifies the approval in errors and messages
+func (a approval) String() string {
+	return fmt.Sprintf("role %s in account %s", a.RoleName, a.Account)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"html/template"
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/approval/src/logging"
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/approval/src/metrics"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// linkApprover is recorded as the approver when a signed link's form leaves
+// the name empty
+const linkApprover = "signed link"
+
+// page is the HTML returned to approvers following a signed link
+var page = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Title}}</title></head>
+<body>
+<h1>{{.Title}}</h1>
+<p>{{.Message}}</p>
+{{with .Approval}}<table>
+<tr><th>Role</th><td>{{.RoleName}}</td></tr>
+{{if .RoleArn}}<tr><th>Role ARN</th><td>{{.RoleArn}}</td></tr>
+{{end}}<tr><th>Account</th><td>{{.Account}}</td></tr>
+<tr><th>Created by</th><td>{{if .Creator}}{{.Creator}}{{else}}unknown{{end}}</td></tr>
+<tr><th>Requested</th><td>{{.RequestedAt.UTC.Format "2006-01-02 15:04 MST"}}</td></tr>
+<tr><th>Expires</th><td>{{.ExpiresAt.UTC.Format "2006-01-02 15:04 MST"}}</td></tr>
+</table>{{end}}
+{{if .Decision}}<form method="post">
+<p><label>Your name <input name="approver"></label></p>
+<p><label>Reason <input name="reason"></label></p>
+<p><button type="submit">{{.Decision}}</button></p>
+</form>{{end}}
+</body>
+</html>
+`))
+
+type pageData struct {
+	Title    string
+	Message  string
+	Approval *approval
+	Decision string
+}
+
+// handleURL serves signed approval links. Following a link only shows the
+// approval and a confirmation form, since mail scanners open links on their
+// own; the decision is made when the form is posted back to the same URL.
+func handleURL(ctx context.Context, recorder *metrics.Recorder, req events.LambdaFunctionURLRequest) Response {
+	logger := logging.ForInvocation(ctx, baseLogger,
+		slog.String("method", req.RequestContext.HTTP.Method),
+		slog.String("sourceIp", req.RequestContext.HTTP.SourceIP),
+	)
+
+	links, err := getLinkSettings(ctx, logger)
+	if err != nil {
+		logger.Error("Error loading approval link settings", "error", err)
+		return htmlResponse(500, "Error", "The approval service is unavailable. Try again later.")
+	}
+
+	query := url.Values{}
+	for name, value := range req.QueryStringParameters {
+		query.Set(name, value)
+	}
+	id, decision, err := links.verify(query, time.Now())
+	if err != nil {
+		logger.Warn("Rejected approval link", "approvalId", id, "error", err)
+		return htmlResponse(statusCodeFor(err), "Link not valid", "This approval link is invalid or has expired.")
+	}
+	logger = logger.With("approvalId", id)
+
+	switch req.RequestContext.HTTP.Method {
+	case "GET":
+		return confirmPage(ctx, logger, id, decision)
+	case "POST":
+		form, err := formValues(req)
+		if err != nil {
+			logger.Warn("Error reading form", "error", err)
+			return htmlResponse(400, "Bad request", "The form could not be read.")
+		}
+		approver := strings.TrimSpace(form.Get("approver"))
+		if approver == "" {
+			approver = linkApprover
+		}
+
+		a, err := decide(ctx, logger, recorder, id, decision, approver, strings.TrimSpace(form.Get("reason")))
+		if err != nil {
+			logger.Error("Error deciding approval", "error", err)
+			return htmlResponse(statusCodeFor(err), "Decision not recorded", decisionErrorMessage(err))
+		}
+		return renderPage(200, pageData{Title: "Role " + a.Status, Message: "The decision was recorded.", Approval: &a})
+	}
+
+	return htmlResponse(405, "Method not allowed", "Open the approval link in a browser.")
+}
+
+// confirmPage shows a pending approval with a form to confirm the decision
+func confirmPage(ctx context.Context, logger *slog.Logger, id, decision string) Response {
+	a, err := getApproval(ctx, id)
+	if err != nil {
+		logger.Error("Error getting approval", "error", err)
+		return htmlResponse(statusCodeFor(err), "Approval not found", decisionErrorMessage(err))
+	}
+	if a.Status != statusPending {
+		return renderPage(409, pageData{Title: "Role already " + a.Status, Message: "This approval has already been answered.", Approval: &a})
+	}
+
+	label := "Approve"
+	if decision == decisionReject {
+		label = "Reject"
+	}
+	return renderPage(200, pageData{Title: label + " role?", Message: "Confirm the decision for this role.", Approval: &a, Decision: label})
+}
+
+func decisionErrorMessage(err error) string {
+	switch statusCodeFor(err) {
+	case 404:
+		return "No approval matches this link."
+	case 409:
+		return "This approval has already been answered or has expired."
+	case 410:
+		return "The workflow is no longer waiting for this approval."
+	}
+	return "The decision could not be recorded. Try again later."
+}
+
+func formValues(req events.LambdaFunctionURLRequest) (url.Values, error) {
+	body := req.Body
+	if req.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			return nil, err
+		}
+		body = string(decoded)
+	}
+	return url.ParseQuery(body)
+}
+
+func htmlResponse(statusCode int, title, message string) Response {
+	return renderPage(statusCode, pageData{Title: title, Message: message})
+}
+
+func renderPage(statusCode int, data pageData) Response {
+	var body bytes.Buffer
+	if err := page.Execute(&body, data); err != nil {
+		return Response{StatusCode: 500}
+	}
+	return Response{
+		StatusCode: statusCode,
+		Headers: map[string]string{
+			"Content-Type":            "text/html; charset=utf-8",
+			"Cache-Control":           "no-store",
+			"Referrer-Policy":         "no-referrer",
+			"Content-Security-Policy": "default-src 'none'; form-action 'self'",
+		},
+		Body: body.String(),
+	}
+}
@@ -7,12 +7,14 @@ from botocore.exceptions import ClientError
 logger = logging.getLogger()
 logger.setLevel(logging.INFO)
 
-OIDC_PROVIDER_ARN_TEMPLATE = "arn:aws:iam::{account_id}:oidc-provider/{oidc_url}"
-CROSS_ACCOUNT_ROLE_ARN_TEMPLATE = "arn:aws:iam::{account_id}:role/{cross_account_role_name}"
+CROSS_ACCOUNT_ROLE_ARN_TEMPLATE = "arn:{partition}:iam::{account_id}:role/{cross_account_role_name}"
+
+# Cross-account roles and OIDC providers live in the same partition (aws, aws-us-gov, aws-cn) as this function
+PARTITION = boto3.session.Session().get_partition_for_region(os.environ.get("AWS_REGION", "us-east-1"))
 
 def assume_role(account_id, role_name):
     sts_client = boto3.client("sts")
-    role_arn = CROSS_ACCOUNT_ROLE_ARN_TEMPLATE.format(account_id=account_id, cross_account_role_name=role_name)
+    role_arn = CROSS_ACCOUNT_ROLE_ARN_TEMPLATE.format(partition=PARTITION, account_id=account_id, cross_account_role_name=role_name)
     try:
         response = sts_client.assume_role(
             RoleArn=role_arn,
@@ -24,36 +26,31 @@ def assume_role(account_id, role_name):
         logger.error(f"Error assuming role {role_arn}: {e}")
         raise
 
-def update_trust_relationship(iam_client, account_id, role_name, audience, oidc_url):
+def update_trust_relationship(iam_client, role_name, trust_policy):
+    """Writes the trust policy the create step rewrote for the app.
+
+    The create step sets the audience and claim conditions in every statement
+    federating with the OIDC provider, whatever the shape of its Action and
+    condition operators, and leaves the other statements as they were. The
+    update is only written while the role still has the policy it was made
+    from, so changes made to the role since are not overwritten.
+    """
     try:
         role = iam_client.get_role(RoleName=role_name)
-        trust_policy = role["Role"]["AssumeRolePolicyDocument"]
-
-        updated = False
-        oidc_provider_arn = OIDC_PROVIDER_ARN_TEMPLATE.format(account_id=account_id, oidc_url=oidc_url)
-        audience_key = f"{oidc_url}:aud"
-        audience = f"api://{audience}"
-
-        for stmt in trust_policy.get("Statement", []):
-            if (
-                stmt.get("Principal", {}).get("Federated") == oidc_provider_arn and
-                stmt.get("Action") == "sts:AssumeRoleWithWebIdentity" and
-                "Condition" in stmt and
-                "StringEquals" in stmt["Condition"] and
-                audience_key in stmt["Condition"]["StringEquals"]
-            ):
-                stmt["Condition"]["StringEquals"][audience_key] = [audience]
-                updated = True
-
-        if not updated:
-            logger.error("OIDC trust relationship statement not found or malformed.")
-            raise Exception("OIDC trust relationship statement not found or malformed.")
+        current = role["Role"]["AssumeRolePolicyDocument"]
+
+        if current == json.loads(trust_policy["updated"]):
+            logger.info(f"Trust relationship for role {role_name} is already up to date")
+            return False
+        if current != json.loads(trust_policy["original"]):
+            logger.error(f"Trust policy of role {role_name} changed since the create step read it")
+            raise Exception("Trust policy changed since the create step read it.")
 
         iam_client.update_assume_role_policy(
             RoleName=role_name,
-            PolicyDocument=json.dumps(trust_policy)
+            PolicyDocument=trust_policy["updated"]
         )
-        logger.info(f"Updated trust relationship for role {role_name} in account {account_id} with audience {audience}")
+        logger.info(f"Updated trust relationship for role {role_name}")
         return True
     except ClientError as e:
         logger.error(f"Error updating trust relationship for role {role_name}: {e}")
@@ -62,16 +59,33 @@ def update_trust_relationship(iam_client, account_id, role_name, audience, oidc_
 def lambda_handler(event, context):
     logger.info(f"Received event: {json.dumps(event)}")
 
+    # A dry run of the create step leaves the member account alone too
+    if event.get("dryRun") or event.get("sfnParam", {}).get("dryRun"):
+        logger.info("Dry run, not changing the member account")
+        return {"status": "planned", "dryRun": True}
+
+    # Roles the create step skipped have no audience. The state machine doesn't
+    # run this step for them either, but a missing status never counts as success.
+    status = event.get("status")
+    if status != "success":
+        logger.info(f"Nothing to do, the create step returned status {status}")
+        return {"status": "skipped", "reason": f"create step returned status {status}"}
+
     sfn_param = event.get("sfnParam", {})
     account_id = sfn_param.get("account")
     role_name = sfn_param.get("roleName")
     audience = event.get("audience")
-    oidc_url = os.environ.get("OIDC_URL")
+    conditions = event.get("conditions")
+    trust_policy = event.get("trustPolicy")
     cross_account_role_name = os.environ.get("CROSS_ACCOUNT_ROLE_NAME")
 
-    if not account_id or not role_name or not audience or not oidc_url or not role_name:
+    if not account_id or not role_name or not audience:
         logger.error("Missing required parameters.")
         raise Exception("Missing required parameters.")
+    # The create step leaves it out when no statement conditions the audience
+    if not trust_policy:
+        logger.error("OIDC trust relationship statement not found or malformed.")
+        raise Exception("OIDC trust relationship statement not found or malformed.")
 
     # Assume role in target account
     credentials = assume_role(account_id, cross_account_role_name)
@@ -83,11 +97,12 @@ def lambda_handler(event, context):
         aws_session_token=credentials["SessionToken"],
     )
 
-    update_trust_relationship(iam_client, account_id, role_name, audience, oidc_url)
+    update_trust_relationship(iam_client, role_name, trust_policy)
 
     return {
         "status": "success",
         "roleName": role_name,
         "account": account_id,
-        "audience": audience
+        "audience": audience,
+        "conditions": conditions
     }
\ No newline at end of file
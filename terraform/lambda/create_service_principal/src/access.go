@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/create_service_principal/src/config"
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/create_service_principal/src/graphhelper"
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/create_service_principal/src/metrics"
+)
+
+// accessState is who may obtain tokens for an app
+type accessState struct {
+	AssignmentRequired bool     `json:"assignmentRequired"`
+	AppRoleID          string   `json:"appRoleId,omitempty"`
+	Assignees          []string `json:"assignees,omitempty"`
+}
+
+// accessManaged reports whether the document or the role's tags configure
+// access to the app. Apps whose access isn't configured are left alone.
+func accessManaged(settings config.Access, rs roleSettings) bool {
+	return settings.AssignmentRequired || len(settings.Principals) > 0 ||
+		rs.AssignmentRequired != nil || len(rs.Assignees) > 0
+}
+
+// reconcileAccess brings the app's access in line with the document and the
+// role's tags: it sets appRoleAssignmentRequired, adds the app role when it is
+// missing, and assigns the role to exactly the configured principals.
+// Assignments to other app roles are left alone. Failures that leave fewer
+// principals with access than configured are recorded as warnings, since the
+// next event retries them. Failures that leave more principals with access,
+// such as an assignment that could not be removed, are returned.
+func reconcileAccess(ctx context.Context, logger *slog.Logger, graphHelper *graphhelper.GraphHelper, recorder *metrics.Recorder, state *appState, settings config.Access, rs roleSettings, dryRun bool) (*accessState, error) {
+	access := &accessState{AssignmentRequired: settings.AssignmentRequired}
+	if rs.AssignmentRequired != nil {
+		access.AssignmentRequired = *rs.AssignmentRequired
+	}
+	for _, principal := range append(slices.Clone(settings.Principals), rs.Assignees...) {
+		// Graph returns object IDs in lower case
+		principal = strings.ToLower(principal)
+		if !slices.Contains(access.Assignees, principal) {
+			access.Assignees = append(access.Assignees, principal)
+		}
+	}
+
+	warn := func(msg string, err error) {
+		logger.Warn("Failed to reconcile app access", "step", msg, "error", err)
+		state.Warnings = append(state.Warnings, fmt.Sprintf("%s: %v", msg, err))
+	}
+	var errs []error
+	fail := func(msg string, err error) {
+		logger.Error("Failed to reconcile app access", "step", msg, "error", err)
+		errs = append(errs, fmt.Errorf("%s: %w", msg, err))
+	}
+
+	if dryRun {
+		state.Plan = append(state.Plan, plannedOperation{Operation: opPatchServicePrincipal, Name: fmt.Sprintf("appRoleAssignmentRequired=%t", access.AssignmentRequired), ID: state.ServicePrincipalID})
+	} else if state.ServicePrincipalID != "" {
+		err := graphHelper.SetAppRoleAssignmentRequired(ctx, state.ServicePrincipalID, access.AssignmentRequired)
+		switch {
+		case err != nil && access.AssignmentRequired:
+			fail("failed to set appRoleAssignmentRequired", err)
+		case err != nil:
+			warn("failed to clear appRoleAssignmentRequired", err)
+		}
+	}
+
+	// A new app in a dry run has no object to read yet
+	if state.ObjectID == "" {
+		state.Plan = append(state.Plan, plannedOperation{Operation: opAddAppRole, Name: settings.AppRole})
+		for _, principal := range access.Assignees {
+			state.Plan = append(state.Plan, plannedOperation{Operation: opAssignAppRole, ID: principal})
+		}
+		return access, nil
+	}
+
+	roleID, err := graphHelper.AppRoleID(ctx, state.ObjectID, settings.AppRole)
+	switch {
+	case errors.Is(err, graphhelper.ErrNotFound) && dryRun:
+		state.Plan = append(state.Plan, plannedOperation{Operation: opAddAppRole, Name: settings.AppRole, ID: state.ObjectID})
+	case errors.Is(err, graphhelper.ErrNotFound):
+		roleID, err = graphHelper.AddAppRole(ctx, state.ObjectID, settings.AppRole)
+		if err != nil {
+			// Without the role nobody can be assigned it
+			warn("failed to add app role "+settings.AppRole, err)
+			return access, errors.Join(errs...)
+		}
+		logger.Info("Added app role", "appRole", settings.AppRole, "appRoleId", roleID)
+	case err != nil:
+		// Assignments that should be removed can't be found without the role
+		fail("failed to read app role "+settings.AppRole, err)
+		return access, errors.Join(errs...)
+	}
+	access.AppRoleID = roleID
+
+	var assigned []graphhelper.AppRoleAssignment
+	if roleID != "" && state.ServicePrincipalID != "" {
+		all, err := graphHelper.AppRoleAssignments(ctx, state.ServicePrincipalID)
+		if err != nil {
+			fail("failed to list app role assignments", err)
+			return access, errors.Join(errs...)
+		}
+		for _, a := range all {
+			if a.AppRoleID == roleID {
+				assigned = append(assigned, a)
+			}
+		}
+	}
+
+	for _, principal := range access.Assignees {
+		if slices.ContainsFunc(assigned, func(a graphhelper.AppRoleAssignment) bool { return a.PrincipalID == principal }) {
+			continue
+		}
+		if dryRun {
+			state.Plan = append(state.Plan, plannedOperation{Operation: opAssignAppRole, ID: principal})
+			continue
+		}
+		if state.ServicePrincipalID == "" {
+			continue
+		}
+		if err := graphHelper.AssignAppRole(ctx, state.ServicePrincipalID, principal, roleID); err != nil {
+			warn("failed to assign "+principal, err)
+			continue
+		}
+		logger.Info("Assigned app role", "principalId", principal)
+		recorder.Count(metrics.AssignmentsAdded)
+	}
+
+	for _, a := range assigned {
+		if slices.Contains(access.Assignees, a.PrincipalID) {
+			continue
+		}
+		if dryRun {
+			state.Plan = append(state.Plan, plannedOperation{Operation: opRemoveAppRoleAssignment, Name: a.PrincipalID, ID: a.ID})
+			continue
+		}
+		if err := graphHelper.RemoveAppRoleAssignment(ctx, state.ServicePrincipalID, a.ID); err != nil {
+			fail("failed to remove assignment of "+a.PrincipalID, err)
+			continue
+		}
+		logger.Info("Removed app role assignment", "principalId", a.PrincipalID)
+		recorder.Count(metrics.AssignmentsRemoved)
+	}
+
+	return access, errors.Join(errs...)
+}
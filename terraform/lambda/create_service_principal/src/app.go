@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/create_service_principal/src/config"
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/create_service_principal/src/graphhelper"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+)
+
+// Actions reported in the create result
+const (
+	actionCreated  = "created"
+	actionReused   = "reused"
+	actionRepaired = "repaired"
+	actionUpdated  = "updated"
+)
+
+// Graph writes reported in a dry run plan
+const (
+	opCreateApplication      = "createApplication"
+	opCreateServicePrincipal = "createServicePrincipal"
+	opPatchIdentifierUris    = "patchIdentifierUris"
+
+	opAddApplicationOwner      = "addApplicationOwner"
+	opAddServicePrincipalOwner = "addServicePrincipalOwner"
+	opPatchApplication         = "patchApplication"
+	opPatchServicePrincipal    = "patchServicePrincipal"
+	opAddAppRole               = "addAppRole"
+	opAssignAppRole            = "assignAppRole"
+	opRemoveAppRoleAssignment  = "removeAppRoleAssignment"
+
+	opAddPreAuthorizedApplication    = "addPreAuthorizedApplication"
+	opRemovePreAuthorizedApplication = "removePreAuthorizedApplication"
+	opAssignAppManagementPolicy      = "assignAppManagementPolicy"
+	opCreateTokenLifetimePolicy      = "createTokenLifetimePolicy"
+	opPatchTokenLifetimePolicy       = "patchTokenLifetimePolicy"
+	opAssignTokenLifetimePolicy      = "assignTokenLifetimePolicy"
+	opUnassignTokenLifetimePolicy    = "unassignTokenLifetimePolicy"
+	opSetSecurityAttributes          = "setCustomSecurityAttributes"
+)
+
+// plannedOperation is a Graph write that a dry run stopped short of
+type plannedOperation struct {
+	Operation string `json:"operation"`
+	Name      string `json:"name,omitempty"`
+	ID        string `json:"id,omitempty"`
+}
+
+// plannedAppID stands in for the ID of an app a dry run would create
+const plannedAppID = "<appId>"
+
+// appState describes the app registration and service principal backing a role
+type appState struct {
+	AppID              string
+	ObjectID           string
+	ServicePrincipalID string
+	IdentifierURIs     []string
+	TokenVersion       int32
+	Action             string
+	Warnings           []string
+	Plan               []plannedOperation
+}
+
+func createApp(ctx context.Context, logger *slog.Logger, graphHelper *graphhelper.GraphHelper, naming config.Naming, spec graphhelper.AppSpec, dryRun bool) (*appState, error) {
+	if dryRun {
+		state := &appState{
+			TokenVersion: spec.TokenVersion,
+			Action:       actionCreated,
+			Plan: []plannedOperation{
+				{Operation: opCreateApplication, Name: spec.Name},
+				{Operation: opCreateServicePrincipal, Name: spec.Name},
+				{Operation: opPatchIdentifierUris, Name: naming.FormatIdentifierURI(plannedAppID)},
+			},
+			IdentifierURIs: []string{naming.FormatIdentifierURI(plannedAppID)},
+		}
+		for _, appId := range spec.API.PreAuthorizedAppIDs {
+			state.Plan = append(state.Plan, plannedOperation{Operation: opAddPreAuthorizedApplication, Name: spec.API.Scope, ID: appId})
+		}
+		if policyId := spec.Hardening.AppManagementPolicyID; policyId != "" {
+			state.Plan = append(state.Plan, plannedOperation{Operation: opAssignAppManagementPolicy, ID: policyId})
+		}
+		return state, nil
+	}
+
+	// Create both app registration and service principal
+	app, err := graphHelper.CreateApp(ctx, spec)
+	if err != nil {
+		logger.Error("Error creating app", "error", err)
+		return nil, err
+	}
+
+	state := stateFromApp(app)
+	state.TokenVersion = spec.TokenVersion
+	state.Action = actionCreated
+	if state.AppID == "" {
+		return nil, errors.New("app ID is nil after creation")
+	}
+
+	sp, err := graphHelper.CreateServicePrincipal(ctx, state.AppID)
+	if err != nil {
+		logger.Error("Error creating service principal", "error", err)
+		return nil, fmt.Errorf("failed to create service principal for app %s: %w", state.AppID, err)
+	}
+	if sp.GetId() != nil {
+		state.ServicePrincipalID = *sp.GetId()
+	}
+
+	if _, err := setIdentifierUri(ctx, logger, graphHelper, naming, spec.API, state); err != nil {
+		return nil, err
+	}
+	// A retry reuses the app and assigns the policy again
+	if policyId := spec.Hardening.AppManagementPolicyID; policyId != "" {
+		if err := assignAppManagementPolicy(ctx, logger, graphHelper, state, policyId); err != nil {
+			return nil, err
+		}
+	}
+
+	logger.Info("Created app", "appId", state.AppID, "servicePrincipalId", state.ServicePrincipalID)
+	return state, nil
+}
+
+// reuseApp returns the existing app registration named in spec, recreating its
+// service principal and identifier URI when they are missing and reapplying the
+// hardening profile when the app drifted from it.
+func reuseApp(ctx context.Context, logger *slog.Logger, graphHelper *graphhelper.GraphHelper, naming config.Naming, spec graphhelper.AppSpec, dryRun bool) (*appState, error) {
+	name := spec.Name
+	app, err := graphHelper.GetApplication(ctx, name)
+	if err != nil {
+		logger.Error("Error getting app", "error", err)
+		return nil, err
+	}
+
+	state := stateFromApp(app)
+	// Existing apps keep the token version they were created with
+	state.TokenVersion = graphhelper.TokenVersion(app)
+	state.Action = actionReused
+	if state.AppID == "" {
+		return nil, errors.New("appId is nil")
+	}
+
+	sp, err := graphHelper.GetServicePrincipalByAppId(ctx, state.AppID)
+	switch {
+	case errors.Is(err, graphhelper.ErrNotFound) && dryRun:
+		state.Plan = append(state.Plan, plannedOperation{Operation: opCreateServicePrincipal, Name: name, ID: state.AppID})
+		state.Action = actionRepaired
+	case errors.Is(err, graphhelper.ErrNotFound):
+		sp, err = graphHelper.CreateServicePrincipal(ctx, state.AppID)
+		if err != nil {
+			logger.Error("Error recreating service principal", "error", err)
+			return nil, err
+		}
+		logger.Info("Recreated missing service principal", "appId", state.AppID)
+		state.Action = actionRepaired
+	case err != nil:
+		logger.Error("Error getting service principal", "error", err)
+		return nil, err
+	}
+	if sp != nil && sp.GetId() != nil {
+		state.ServicePrincipalID = *sp.GetId()
+	}
+
+	applicationIdUri := naming.FormatIdentifierURI(state.AppID)
+	if !slices.Contains(state.IdentifierURIs, applicationIdUri) {
+		logger.Warn("App is missing its identifier URI", "appId", state.AppID)
+		if dryRun {
+			state.Plan = append(state.Plan, plannedOperation{Operation: opPatchIdentifierUris, Name: applicationIdUri, ID: state.ObjectID})
+			state.IdentifierURIs = []string{applicationIdUri}
+			state.Action = actionRepaired
+		} else {
+			set, err := setIdentifierUri(ctx, logger, graphHelper, naming, spec.API, state)
+			if err != nil {
+				return nil, err
+			}
+			if set {
+				state.Action = actionRepaired
+			}
+		}
+	}
+
+	if spec.Hardening.Enabled {
+		repaired, err := repairHardening(ctx, logger, graphHelper, app, state, dryRun)
+		if err != nil {
+			return nil, err
+		}
+		if repaired {
+			state.Action = actionRepaired
+		}
+	}
+	if policyId := spec.Hardening.AppManagementPolicyID; policyId != "" {
+		assigned, err := ensureAppManagementPolicy(ctx, logger, graphHelper, state, policyId, dryRun)
+		if err != nil {
+			return nil, err
+		}
+		if assigned {
+			state.Action = actionRepaired
+		}
+	}
+
+	return state, nil
+}
+
+// setIdentifierUri exposes the app as an API with its scope and pre-authorized
+// client apps and reports whether it did. v1 tokens carry the identifier URI as
+// their audience, so for v1 apps a failure is returned; v2 tokens carry the bare
+// app ID, so for v2 apps it is recorded as a warning.
+func setIdentifierUri(ctx context.Context, logger *slog.Logger, graphHelper *graphhelper.GraphHelper, naming config.Naming, api graphhelper.ExposedAPI, state *appState) (bool, error) {
+	applicationIdUri := naming.FormatIdentifierURI(state.AppID)
+
+	err := graphHelper.SetApplicationIdUri(ctx, state.AppID, applicationIdUri, api)
+	if err != nil && state.TokenVersion == graphhelper.AccessTokenV1 {
+		logger.Error("Failed to set Application ID URI", "appId", state.AppID, "error", err)
+		return false, fmt.Errorf("failed to set identifier URI %s, the audience of v1 tokens: %w", applicationIdUri, err)
+	}
+	if err != nil {
+		logger.Warn("Failed to set Application ID URI", "appId", state.AppID, "error", err)
+		state.Warnings = append(state.Warnings, fmt.Sprintf("failed to set identifier URI %s: %v", applicationIdUri, err))
+		return false, nil
+	}
+
+	state.IdentifierURIs = []string{applicationIdUri}
+	return true, nil
+}
+
+func stateFromApp(app models.Applicationable) *appState {
+	state := &appState{IdentifierURIs: app.GetIdentifierUris()}
+	if app.GetAppId() != nil {
+		state.AppID = *app.GetAppId()
+	}
+	if app.GetId() != nil {
+		state.ObjectID = *app.GetId()
+	}
+	return state
+}
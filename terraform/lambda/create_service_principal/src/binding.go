@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// principalsTag lists the Entra object IDs allowed in the sub and oid claims
+	principalsTag = "entra:principals"
+	// clientAppIDsTag lists the Entra application IDs allowed in the appid claim
+	clientAppIDsTag = "entra:client-app-ids"
+)
+
+// subjectConditions returns the token claims, keyed by claim name, that the trust
+// policy should pin for the role, and warnings for claims it can't pin. claims
+// lists sub, oid and appid; it defaults to sub. The values come from the role's
+// tags only: the service principal created for the role is the token's
+// audience, not a caller, so a claim without a tagged value is left unbound.
+func subjectConditions(claims []string, tags map[string]string) (map[string][]string, []string, error) {
+	if len(claims) == 0 {
+		claims = []string{"sub"}
+	}
+
+	principals := splitTagValue(tags[principalsTag])
+	clientAppIDs := splitTagValue(tags[clientAppIDsTag])
+
+	conditions := map[string][]string{}
+	var warnings []string
+	for _, claim := range claims {
+		claim = strings.TrimSpace(claim)
+		switch claim {
+		case "sub", "oid":
+			if len(principals) == 0 {
+				warnings = append(warnings, fmt.Sprintf("%s claim not bound, role has no %s tag", claim, principalsTag))
+				continue
+			}
+			conditions[claim] = principals
+		case "appid":
+			if len(clientAppIDs) == 0 {
+				warnings = append(warnings, fmt.Sprintf("%s claim not bound, role has no %s tag", claim, clientAppIDsTag))
+				continue
+			}
+			conditions[claim] = clientAppIDs
+		case "":
+		default:
+			return nil, nil, fmt.Errorf("unsupported claim %q in create.bindClaims", claim)
+		}
+	}
+
+	if len(conditions) == 0 {
+		return nil, warnings, nil
+	}
+	return conditions, warnings, nil
+}
+
+// splitTagValue splits a tag value on spaces. IAM tag values cannot contain
+// commas, so lists in tags are space separated.
+func splitTagValue(value string) []string {
+	return strings.Fields(value)
+}
@@ -0,0 +1,447 @@
+// Package config loads the handlers' settings from a versioned JSON or YAML
+// document, validated against the embedded JSON schema.
+//
+// CONFIG_SOURCE selects where the document is read from:
+//   - file:<path>: a file in the deployment package or a layer
+//   - ssm:<name>: an SSM parameter, decrypted when it is a SecureString
+//   - appconfig:<application>/<environment>/<profile>: an AppConfig configuration profile
+//
+// Without CONFIG_SOURCE the document is built from the individual environment
+// variables (CLIENT_ID, TENANT_ID, ...), so existing deployments keep working.
+// CONFIG_TTL sets how often the document is reloaded, 5m by default; 0 loads it
+// once per cold start.
+package config
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/create_service_principal/src/tenant"
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"sigs.k8s.io/yaml"
+)
+
+// SchemaVersion is the document version this package understands
+const SchemaVersion = 1
+
+// Defaults for settings the document leaves out
+const (
+	DefaultAppName       = "{partition}-{account}-{role}"
+	DefaultIdentifierURI = "api://{appId}"
+	DefaultCacheTTL      = "15m"
+	DefaultOwnerRoleTag  = "entra:owners"
+	DefaultAppRole       = "Access"
+	DefaultAPIScope      = "user_impersonation"
+)
+
+//go:embed schema.json
+var schemaJSON []byte
+
+// schemaURL is the $id of the embedded schema
+const schemaURL = "https://github.com/borkod/poc-aws-azure-oidc/config/schema.json"
+
+// schema is compiled once; it is embedded, so failing to compile it is a bug
+var schema = mustCompileSchema()
+
+// Document is the configuration shared by the handlers
+type Document struct {
+	Version int    `json:"version"`
+	Azure   Azure  `json:"azure"`
+	Naming  Naming `json:"naming"`
+	Create  Create `json:"create"`
+	Graph   Graph  `json:"graph"`
+	DryRun  bool   `json:"dryRun"`
+
+	Organizations Organizations `json:"organizations"`
+	// TenantRouting is the tenant routing table, which replaces the single
+	// tenant in Azure. It is left raw for the tenant package to parse.
+	TenantRouting json.RawMessage `json:"tenantRouting,omitempty"`
+	// Policy holds the rules deciding which roles get an app. It is left raw
+	// for the create handler's guardrails package to parse.
+	Policy json.RawMessage `json:"policy,omitempty"`
+}
+
+// Azure is the Entra tenant serving every role when there is no tenant routing,
+// and the default cloud of routed tenants.
+type Azure struct {
+	Cloud           string `json:"cloud,omitempty"`
+	TenantID        string `json:"tenantId,omitempty"`
+	ClientID        string `json:"clientId,omitempty"`
+	ClientSecretSSM string `json:"clientSecretSsm,omitempty"`
+	OIDCURL         string `json:"oidcUrl,omitempty"`
+}
+
+// Naming holds the templates for the names and descriptions given to Entra
+// objects. Besides {partition}, {account} and {role}, templates can use the
+// account fields {accountName}, {accountEmail}, {ouPath} and {accountTag:<key>}
+// when organizations.enrich is on, except for the application name.
+type Naming struct {
+	// AppName is the application name. The delete and tag update steps find
+	// the app by rebuilding it, so it only uses fields that never change.
+	AppName string `json:"appName,omitempty"`
+	// IdentifierURI is the application ID URI, with {appId}
+	IdentifierURI string `json:"identifierUri,omitempty"`
+	// Notes is the application's notes, shown on its overview in Entra
+	Notes string `json:"notes,omitempty"`
+	// Tags are the application's tags
+	Tags []string `json:"tags,omitempty"`
+}
+
+// Fields are the values substituted into naming templates
+type Fields struct {
+	Partition    string
+	Account      string
+	Role         string
+	AccountName  string
+	AccountEmail string
+	OUPath       string
+	AccountTags  map[string]string
+}
+
+// placeholder matches {name} and {accountTag:<key>}
+var placeholder = regexp.MustCompile(`\{(partition|account|role|accountName|accountEmail|ouPath|accountTag:[^{}]+)\}`)
+
+// accountPlaceholder matches the placeholders filled in from Organizations
+var accountPlaceholder = regexp.MustCompile(`\{(accountName|accountEmail|ouPath|accountTag:[^{}]+)\}`)
+
+// Expand replaces the placeholders in template. A tag the account doesn't
+// have expands to "".
+func (f Fields) Expand(template string) string {
+	return placeholder.ReplaceAllStringFunc(template, func(match string) string {
+		name := match[1 : len(match)-1]
+		switch name {
+		case "partition":
+			return f.Partition
+		case "account":
+			return f.Account
+		case "role":
+			return f.Role
+		case "accountName":
+			return f.AccountName
+		case "accountEmail":
+			return f.AccountEmail
+		case "ouPath":
+			return f.OUPath
+		}
+		return f.AccountTags[strings.TrimPrefix(name, "accountTag:")]
+	})
+}
+
+// FormatAppName returns the application name for a role
+func (n Naming) FormatAppName(f Fields) string {
+	return f.Expand(n.AppName)
+}
+
+// FormatNotes returns the application notes for a role
+func (n Naming) FormatNotes(f Fields) string {
+	return f.Expand(n.Notes)
+}
+
+// FormatTags returns the application tags for a role. Tags that expand to
+// nothing are left out.
+func (n Naming) FormatTags(f Fields) []string {
+	var tags []string
+	for _, template := range n.Tags {
+		if tag := f.Expand(template); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// FormatIdentifierURI returns the application ID URI for an app
+func (n Naming) FormatIdentifierURI(appID string) string {
+	return strings.ReplaceAll(n.IdentifierURI, "{appId}", appID)
+}
+
+// Create holds the settings of the create step
+type Create struct {
+	AudiencePlaceholder string `json:"audiencePlaceholder,omitempty"`
+	// RequireFederation skips roles without a trust policy statement that
+	// federates with the OIDC provider and carries AudiencePlaceholder
+	RequireFederation    bool               `json:"requireFederation,omitempty"`
+	CrossAccountRoleName string             `json:"crossAccountRoleName,omitempty"`
+	AccessTokenVersion   int32              `json:"accessTokenVersion,omitempty"`
+	BindSubject          bool               `json:"bindSubject,omitempty"`
+	BindClaims           []string           `json:"bindClaims,omitempty"`
+	Owners               Owners             `json:"owners"`
+	Access               Access             `json:"access"`
+	API                  API                `json:"api"`
+	Hardening            Hardening          `json:"hardening"`
+	TokenLifetime        TokenLifetime      `json:"tokenLifetime"`
+	SecurityAttributes   SecurityAttributes `json:"securityAttributes"`
+}
+
+// Bounds Entra ID puts on the lifetime of access tokens
+const (
+	MinTokenLifetime = 10 * time.Minute
+	MaxTokenLifetime = 24 * time.Hour
+)
+
+// TokenLifetime holds the token lifetime policies and how one is chosen for a
+// role's app: the policy named by the role's entra:token-lifetime tag, else
+// the policy of the first matching route, else Default. Apps that get no
+// policy keep the tenant's default lifetime.
+type TokenLifetime struct {
+	// Policies maps a policy name to the lifetime of the access tokens it
+	// issues, such as 1h
+	Policies map[string]string    `json:"policies,omitempty"`
+	Default  string               `json:"default,omitempty"`
+	Routes   []TokenLifetimeRoute `json:"routes,omitempty"`
+}
+
+// TokenLifetimeRoute assigns a policy to the roles its selector matches
+type TokenLifetimeRoute struct {
+	Policy string `json:"policy"`
+	tenant.Selector
+}
+
+// Select returns the name of the policy of the first route matching the role,
+// else Default
+func (t TokenLifetime) Select(account, ouPath string, tags map[string]string) string {
+	for _, route := range t.Routes {
+		if route.Matches(account, ouPath, tags) {
+			return route.Policy
+		}
+	}
+	return t.Default
+}
+
+// NeedsOUPath reports whether any route matches on OU paths
+func (t TokenLifetime) NeedsOUPath() bool {
+	for _, route := range t.Routes {
+		if len(route.OUPaths) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Lifetime returns the access token lifetime of the named policy
+func (t TokenLifetime) Lifetime(name string) (time.Duration, bool) {
+	value, ok := t.Policies[name]
+	if !ok {
+		return 0, false
+	}
+	d, err := time.ParseDuration(value)
+	return d, err == nil
+}
+
+// SecurityAttributes holds the custom security attributes set on each new
+// service principal, so governance tooling can filter apps by them
+type SecurityAttributes struct {
+	// Set is the attribute set the attributes are defined in
+	Set string `json:"set,omitempty"`
+	// Attributes maps an attribute name to a template of its value, using the
+	// naming placeholders and {roleTag:<key>}. Attributes whose value expands
+	// to nothing are not set.
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// roleTagPlaceholder matches {roleTag:<key>}
+var roleTagPlaceholder = regexp.MustCompile(`\{roleTag:[^{}]+\}`)
+
+// Values returns the attribute values for a role. A tag the role doesn't have
+// expands to "".
+func (s SecurityAttributes) Values(f Fields, roleTags map[string]string) map[string]string {
+	values := make(map[string]string, len(s.Attributes))
+	for name, template := range s.Attributes {
+		value := roleTagPlaceholder.ReplaceAllStringFunc(template, func(match string) string {
+			return roleTags[strings.TrimSuffix(strings.TrimPrefix(match, "{roleTag:"), "}")]
+		})
+		if value = strings.TrimSpace(f.Expand(value)); value != "" {
+			values[name] = value
+		}
+	}
+	return values
+}
+
+// Hardening holds the security profile applied to apps when they are created
+// and when they are reused after drifting from it
+type Hardening struct {
+	// Enabled locks the service principal, limits sign-in to the tenant,
+	// clears redirect URIs and the implicit grant, and turns off public
+	// client flows
+	Enabled bool `json:"enabled,omitempty"`
+	// AppManagementPolicyID is an app management policy assigned to every
+	// app, such as one that forbids password and key credentials
+	AppManagementPolicyID string `json:"appManagementPolicyId,omitempty"`
+}
+
+// API holds the delegated permission scope the apps expose and the client
+// apps pre-authorized for it, such as CI/CD platforms registered in Entra ID
+type API struct {
+	// Scope is the value of the oauth2PermissionScope defined with the
+	// identifier URI
+	Scope string `json:"scope,omitempty"`
+	// PreAuthorizedApps are the app IDs of the client apps that get tokens
+	// for every app without a consent prompt. The role's
+	// entra:preauthorized-apps tag adds to them.
+	PreAuthorizedApps []string `json:"preAuthorizedApps,omitempty"`
+}
+
+// Access holds who may obtain tokens for the apps. Principals are assigned an
+// app role created on each app, and the assignments of that role are kept in
+// line with Principals and the role's entra:assignees tag.
+type Access struct {
+	// AssignmentRequired makes Entra ID issue tokens only to principals
+	// assigned to the app. The entra:assignment-required tag overrides it.
+	AssignmentRequired bool `json:"assignmentRequired,omitempty"`
+	// AppRole is the value of the app role assigned to the principals
+	AppRole string `json:"appRole,omitempty"`
+	// Principals are the object IDs of the users, groups, service principals
+	// and managed identities assigned to every app
+	Principals []string `json:"principals,omitempty"`
+}
+
+// Owners holds how the users owning an app are chosen. The first source that
+// yields a user wins: the role tag, then the CreateRole caller, then the
+// fallback group.
+type Owners struct {
+	// Assign adds the chosen users as owners of the application and its
+	// service principal
+	Assign bool `json:"assign,omitempty"`
+	// RoleTag is the role tag holding the owners' UPNs or emails, space
+	// separated. It is only honoured when Assign is on.
+	RoleTag string `json:"roleTag,omitempty"`
+	// FallbackGroupID is an Entra group whose members own apps whose creator
+	// can't be mapped to a user
+	FallbackGroupID string `json:"fallbackGroupId,omitempty"`
+}
+
+// Organizations holds the settings of account enrichment
+type Organizations struct {
+	// Enrich resolves each event's account through Organizations and rejects
+	// accounts that are not active members of the organization
+	Enrich bool `json:"enrich,omitempty"`
+	// CacheTTL is how long an account's details are reused, e.g. "15m"
+	CacheTTL string `json:"cacheTtl,omitempty"`
+}
+
+// CacheDuration returns CacheTTL as a duration
+func (o Organizations) CacheDuration() time.Duration {
+	// The schema only admits valid durations
+	d, _ := time.ParseDuration(o.CacheTTL)
+	return d
+}
+
+// Graph holds the Microsoft Graph client settings
+type Graph struct {
+	// RequestTimeout bounds each Graph request attempt, e.g. "10s"
+	RequestTimeout string `json:"requestTimeout,omitempty"`
+}
+
+// Parse validates a JSON or YAML document against the schema and returns it
+// with defaults filled in.
+func Parse(data []byte) (*Document, error) {
+	// JSON is valid YAML, so every document goes through the YAML decoder
+	jsonData, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("document is neither JSON nor YAML: %w", err)
+	}
+
+	instance, err := jsonschema.UnmarshalJSON(bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	if err := schema.Validate(instance); err != nil {
+		return nil, err
+	}
+
+	var doc Document
+	if err := json.Unmarshal(jsonData, &doc); err != nil {
+		return nil, err
+	}
+	doc.applyDefaults()
+	if err := doc.check(); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// check catches what the schema can't express
+func (d *Document) check() error {
+	var errs []error
+	// Renaming, moving or retagging the account would change the name and
+	// orphan the app
+	if match := accountPlaceholder.FindString(d.Naming.AppName); match != "" {
+		errs = append(errs, fmt.Errorf("naming.appName %q uses %s, which can change while the app exists; use {partition}, {account} and {role}", d.Naming.AppName, match))
+	}
+	if !d.Organizations.Enrich {
+		templates := append([]string{d.Naming.Notes}, d.Naming.Tags...)
+		for _, template := range templates {
+			if match := accountPlaceholder.FindString(template); match != "" {
+				errs = append(errs, fmt.Errorf("naming template %q uses %s, which requires organizations.enrich", template, match))
+			}
+		}
+		for name, template := range d.Create.SecurityAttributes.Attributes {
+			if match := accountPlaceholder.FindString(template); match != "" {
+				errs = append(errs, fmt.Errorf("security attribute %s uses %s, which requires organizations.enrich", name, match))
+			}
+		}
+	}
+
+	lifetime := d.Create.TokenLifetime
+	for name := range lifetime.Policies {
+		if value, ok := lifetime.Lifetime(name); !ok || value < MinTokenLifetime || value > MaxTokenLifetime {
+			errs = append(errs, fmt.Errorf("token lifetime policy %q must last between %s and %s", name, MinTokenLifetime, MaxTokenLifetime))
+		}
+	}
+	if _, ok := lifetime.Policies[lifetime.Default]; lifetime.Default != "" && !ok {
+		errs = append(errs, fmt.Errorf("default token lifetime policy %q is not defined", lifetime.Default))
+	}
+	for i, route := range lifetime.Routes {
+		if _, ok := lifetime.Policies[route.Policy]; !ok {
+			errs = append(errs, fmt.Errorf("token lifetime route %d: policy %q is not defined", i, route.Policy))
+		}
+		if len(route.Accounts) == 0 && len(route.OUPaths) == 0 && len(route.Tags) == 0 {
+			errs = append(errs, fmt.Errorf("token lifetime route %d: needs accounts, ouPaths or tags", i))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (d *Document) applyDefaults() {
+	if d.Naming.AppName == "" {
+		d.Naming.AppName = DefaultAppName
+	}
+	if d.Naming.IdentifierURI == "" {
+		d.Naming.IdentifierURI = DefaultIdentifierURI
+	}
+	if d.Organizations.CacheTTL == "" {
+		d.Organizations.CacheTTL = DefaultCacheTTL
+	}
+	if d.Create.AccessTokenVersion == 0 {
+		d.Create.AccessTokenVersion = 1
+	}
+	if d.Create.Owners.RoleTag == "" {
+		d.Create.Owners.RoleTag = DefaultOwnerRoleTag
+	}
+	if d.Create.Access.AppRole == "" {
+		d.Create.Access.AppRole = DefaultAppRole
+	}
+	if d.Create.API.Scope == "" {
+		d.Create.API.Scope = DefaultAPIScope
+	}
+	if len(d.Create.BindClaims) == 0 {
+		d.Create.BindClaims = []string{"sub"}
+	}
+}
+
+func mustCompileSchema() *jsonschema.Schema {
+	doc, err := jsonschema.UnmarshalJSON(bytes.NewReader(schemaJSON))
+	if err != nil {
+		panic(err)
+	}
+	compiler := jsonschema.NewCompiler()
+	if err := compiler.AddResource(schemaURL, doc); err != nil {
+		panic(err)
+	}
+	return compiler.MustCompile(schemaURL)
+}
@@ -0,0 +1,405 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+const (
+	testTenantID = "11111111-1111-1111-1111-111111111111"
+	testClientID = "22222222-2222-2222-2222-222222222222"
+)
+
+// testAzure is the smallest valid azure section
+const testAzure = `{"tenantId": "` + testTenantID + `", "clientId": "` + testClientID + `", "clientSecretSsm": "/entra/secret"}`
+
+var testLogger = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func TestParseDefaults(t *testing.T) {
+	doc, err := Parse([]byte(document("")))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		got  any
+		want any
+	}{
+		{name: "app name", got: doc.Naming.AppName, want: DefaultAppName},
+		{name: "identifier URI", got: doc.Naming.IdentifierURI, want: DefaultIdentifierURI},
+		{name: "cache TTL", got: doc.Organizations.CacheTTL, want: DefaultCacheTTL},
+		{name: "access token version", got: doc.Create.AccessTokenVersion, want: int32(1)},
+		{name: "owner role tag", got: doc.Create.Owners.RoleTag, want: DefaultOwnerRoleTag},
+		{name: "app role", got: doc.Create.Access.AppRole, want: DefaultAppRole},
+		{name: "API scope", got: doc.Create.API.Scope, want: DefaultAPIScope},
+		{name: "bind claims", got: strings.Join(doc.Create.BindClaims, ","), want: "sub"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Fatalf("got %v, want %v", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseKeepsSettings(t *testing.T) {
+	doc, err := Parse([]byte(document(`
+		"naming": {"appName": "{account}-{role}"},
+		"create": {"accessTokenVersion": 2, "api": {"scope": "read"}, "bindClaims": ["oid", "appid"]}
+	`)))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if doc.Naming.AppName != "{account}-{role}" || doc.Create.AccessTokenVersion != 2 || doc.Create.API.Scope != "read" ||
+		!slices.Equal(doc.Create.BindClaims, []string{"oid", "appid"}) {
+		t.Fatalf("Parse() replaced settings with defaults: %+v", doc)
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		// want is a substring of the error, empty when the document is valid
+		want string
+	}{
+		{name: "YAML", doc: "version: 1\nazure: " + testAzure + "\ndryRun: true\n"},
+		{name: "JSON", doc: document(`"dryRun": true`)},
+		{name: "not JSON or YAML", doc: "version: [1", want: "neither JSON nor YAML"},
+		{name: "missing version", doc: `{"azure": ` + testAzure + `, "dryRun": true}`, want: "version"},
+		{name: "other version", doc: `{"version": 2, "azure": ` + testAzure + `}`, want: "version"},
+		{name: "unknown section", doc: document(`"extra": {}`), want: "extra"},
+		{name: "unknown setting", doc: document(`"create": {"bindSubjects": true}`), want: "bindSubjects"},
+		{name: "unsupported claim", doc: document(`"create": {"bindClaims": ["email"]}`), want: "bindClaims"},
+		{name: "client ID not a GUID", doc: document(`"azure": {"clientId": "client"}`), want: "clientId"},
+
+		{name: "shortest token lifetime", doc: document(`"create": {"tokenLifetime": {"policies": {"short": "10m"}}}`)},
+		{name: "longest token lifetime", doc: document(`"create": {"tokenLifetime": {"policies": {"long": "24h"}}}`)},
+		{name: "token lifetime too short", doc: document(`"create": {"tokenLifetime": {"policies": {"short": "9m59s"}}}`), want: `"short" must last between`},
+		{name: "token lifetime too long", doc: document(`"create": {"tokenLifetime": {"policies": {"long": "25h"}}}`), want: `"long" must last between`},
+		{name: "undefined default policy", doc: document(`"create": {"tokenLifetime": {"policies": {"a": "1h"}, "default": "b"}}`), want: `default token lifetime policy "b"`},
+		{name: "route to an undefined policy", doc: document(`"create": {"tokenLifetime": {"policies": {"a": "1h"}, "routes": [{"policy": "b", "accounts": ["111111111111"]}]}}`), want: `route 0: policy "b"`},
+		{name: "route without a selector", doc: document(`"create": {"tokenLifetime": {"policies": {"a": "1h"}, "routes": [{"policy": "a"}]}}`), want: "route 0: needs accounts"},
+
+		{name: "account placeholder with enrichment", doc: document(`"naming": {"notes": "{accountName}"}, "organizations": {"enrich": true}`)},
+		{name: "account placeholder without enrichment", doc: document(`"naming": {"notes": "{accountName}"}`), want: "requires organizations.enrich"},
+		{name: "account name in the app name", doc: document(`"naming": {"appName": "{account}-{accountName}-{role}"}, "organizations": {"enrich": true}`), want: "uses {accountName}, which can change"},
+		{name: "account tag in the app name", doc: document(`"naming": {"appName": "{account}-{role}-{accountTag:env}"}, "organizations": {"enrich": true}`), want: "uses {accountTag:env}, which can change"},
+		{name: "account tag in notes without enrichment", doc: document(`"naming": {"notes": "{accountTag:team}"}`), want: "requires organizations.enrich"},
+		{name: "security attribute without enrichment", doc: document(`"create": {"securityAttributes": {"set": "Aws", "attributes": {"Env": "{accountTag:env}"}}}`), want: "security attribute Env"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.doc))
+			switch {
+			case tt.want == "" && err != nil:
+				t.Fatalf("Parse() error = %v", err)
+			case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+				t.Fatalf("Parse() error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnvSource(t *testing.T) {
+	env := map[string]string{
+		"AZURE_CLOUD":             "AzurePublic",
+		"TENANT_ID":               testTenantID,
+		"CLIENT_ID":               testClientID,
+		"CLIENT_SECRET_SSM":       "/entra/secret",
+		"ACCESS_TOKEN_VERSION":    "2",
+		"BIND_SUBJECT":            "true",
+		"BIND_CLAIMS":             "sub, appid,",
+		"ASSIGN_OWNERS":           "true",
+		"OWNER_ROLE_TAG":          "owners",
+		"ASSIGNMENT_REQUIRED":     "true",
+		"ACCESS_PRINCIPALS":       "33333333-3333-3333-3333-333333333333, 44444444-4444-4444-4444-444444444444",
+		"API_SCOPE":               "read",
+		"HARDEN_APPS":             "true",
+		"TOKEN_LIFETIME":          `{"policies": {"short": "30m"}, "default": "short"}`,
+		"GRAPH_REQUEST_TIMEOUT":   "10s",
+		"DRY_RUN":                 "true",
+		"ORGANIZATIONS_ENRICH":    "true",
+		"ORGANIZATIONS_CACHE_TTL": "1h",
+	}
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+
+	src := &envSource{}
+	data, err := src.fetch(context.Background())
+	if err != nil {
+		t.Fatalf("fetch() error = %v", err)
+	}
+	doc, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse() error = %v for %s", err, data)
+	}
+
+	tests := []struct {
+		name string
+		got  any
+		want any
+	}{
+		{name: "tenant", got: doc.Azure.TenantID, want: testTenantID},
+		{name: "client", got: doc.Azure.ClientID, want: testClientID},
+		{name: "client secret", got: doc.Azure.ClientSecretSSM, want: "/entra/secret"},
+		{name: "token version", got: doc.Create.AccessTokenVersion, want: int32(2)},
+		{name: "bind subject", got: doc.Create.BindSubject, want: true},
+		{name: "bind claims", got: strings.Join(doc.Create.BindClaims, ","), want: "sub,appid"},
+		{name: "assign owners", got: doc.Create.Owners.Assign, want: true},
+		{name: "owner role tag", got: doc.Create.Owners.RoleTag, want: "owners"},
+		{name: "assignment required", got: doc.Create.Access.AssignmentRequired, want: true},
+		{name: "access principals", got: len(doc.Create.Access.Principals), want: 2},
+		{name: "API scope", got: doc.Create.API.Scope, want: "read"},
+		{name: "hardening", got: doc.Create.Hardening.Enabled, want: true},
+		{name: "token lifetime", got: doc.Create.TokenLifetime.Default, want: "short"},
+		{name: "request timeout", got: doc.Graph.RequestTimeout, want: "10s"},
+		{name: "dry run", got: doc.DryRun, want: true},
+		{name: "enrich", got: doc.Organizations.Enrich, want: true},
+		{name: "cache TTL", got: doc.Organizations.CacheDuration(), want: time.Hour},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Fatalf("got %v, want %v", tt.got, tt.want)
+			}
+		})
+	}
+
+	// The environment can't change while the function runs
+	if data, err := src.fetch(context.Background()); data != nil || err != nil {
+		t.Fatalf("second fetch() = %s, %v, want no change", data, err)
+	}
+}
+
+func TestEnvSourceErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		key   string
+		value string
+		want  string
+	}{
+		{name: "token version", key: "ACCESS_TOKEN_VERSION", value: "v2", want: "ACCESS_TOKEN_VERSION"},
+		{name: "tenant routing", key: "TENANT_ROUTING", value: "{", want: "TENANT_ROUTING"},
+		{name: "token lifetime", key: "TOKEN_LIFETIME", value: "1h", want: "TOKEN_LIFETIME"},
+		{name: "security attributes", key: "SECURITY_ATTRIBUTES", value: "Env=prod", want: "SECURITY_ATTRIBUTES"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(tt.key, tt.value)
+			_, err := (&envSource{}).fetch(context.Background())
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("fetch() error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+// fakeSource returns its documents in turn, then reports no change
+type fakeSource struct {
+	results []fakeResult
+	fetches int
+}
+
+type fakeResult struct {
+	data string
+	err  error
+}
+
+func (s *fakeSource) fetch(ctx context.Context) ([]byte, error) {
+	s.fetches++
+	if len(s.results) == 0 {
+		return nil, nil
+	}
+	result := s.results[0]
+	s.results = s.results[1:]
+	if result.err != nil || result.data == "" {
+		return nil, result.err
+	}
+	return []byte(result.data), nil
+}
+
+func (s *fakeSource) String() string {
+	return "fake"
+}
+
+func TestLoader(t *testing.T) {
+	errUnavailable := errors.New("unavailable")
+
+	tests := []struct {
+		name    string
+		results []fakeResult
+		// wantDryRun is the dry run setting of each Get's document, or nil
+		// when Get fails
+		wantDryRun []*bool
+	}{
+		{
+			name:       "reloads after the TTL",
+			results:    []fakeResult{{data: document("")}, {data: document(`"dryRun": true`)}},
+			wantDryRun: []*bool{ptr(false), ptr(true)},
+		},
+		{
+			name:       "keeps the document when unchanged",
+			results:    []fakeResult{{data: document(`"dryRun": true`)}, {}},
+			wantDryRun: []*bool{ptr(true), ptr(true)},
+		},
+		{
+			name:       "keeps the document when the reload fails",
+			results:    []fakeResult{{data: document(`"dryRun": true`)}, {err: errUnavailable}},
+			wantDryRun: []*bool{ptr(true), ptr(true)},
+		},
+		{
+			name:       "keeps the document when the new one is invalid",
+			results:    []fakeResult{{data: document(`"dryRun": true`)}, {data: `{"version": 2, "azure": ` + testAzure + `}`}, {data: document("")}},
+			wantDryRun: []*bool{ptr(true), ptr(true), ptr(false)},
+		},
+		{
+			name:       "first load fails",
+			results:    []fakeResult{{err: errUnavailable}, {data: document("")}},
+			wantDryRun: []*bool{nil, ptr(false)},
+		},
+		{
+			name:       "first load finds no document",
+			results:    []fakeResult{{}},
+			wantDryRun: []*bool{nil},
+		},
+		{
+			name:       "first document is invalid",
+			results:    []fakeResult{{data: document(`"naming": {"appName": "{ouPath}"}`)}},
+			wantDryRun: []*bool{nil},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			loader := &Loader{source: &fakeSource{results: tt.results}, ttl: time.Minute}
+			for i, want := range tt.wantDryRun {
+				// Every Get after the first finds the TTL passed
+				loader.loadedAt = loader.loadedAt.Add(-time.Hour)
+
+				doc, err := loader.Get(context.Background(), testLogger)
+				switch {
+				case want == nil && err == nil:
+					t.Fatalf("Get() %d = %+v, want an error", i, doc)
+				case want != nil && err != nil:
+					t.Fatalf("Get() %d error = %v", i, err)
+				case want != nil && doc.DryRun != *want:
+					t.Fatalf("Get() %d dryRun = %v, want %v", i, doc.DryRun, *want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoaderTTL(t *testing.T) {
+	src := &fakeSource{results: []fakeResult{{data: document("")}, {data: document(`"dryRun": true`)}}}
+	loader := &Loader{source: src, ttl: time.Hour}
+
+	for range 3 {
+		if _, err := loader.Get(context.Background(), testLogger); err != nil {
+			t.Fatalf("Get() error = %v", err)
+		}
+	}
+	if src.fetches != 1 {
+		t.Fatalf("fetched %d times within the TTL, want 1", src.fetches)
+	}
+
+	// A TTL of 0 loads the document once
+	loader = &Loader{source: src, ttl: 0, doc: loader.doc, loadedAt: time.Now().Add(-48 * time.Hour)}
+	if doc, err := loader.Get(context.Background(), testLogger); err != nil || doc.DryRun || src.fetches != 1 {
+		t.Fatalf("Get() with TTL 0 = %+v, %v after %d fetches, want the loaded document", doc, err, src.fetches)
+	}
+}
+
+func TestLoaderValidate(t *testing.T) {
+	errRejected := errors.New("rejected")
+	src := &fakeSource{results: []fakeResult{{data: document("")}}}
+	loader := &Loader{source: src, ttl: time.Minute, validate: func(*Document) error { return errRejected }}
+
+	if _, err := loader.Get(context.Background(), testLogger); !errors.Is(err, errRejected) {
+		t.Fatalf("Get() error = %v, want %v", err, errRejected)
+	}
+}
+
+func TestNewSource(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "", want: "environment variables"},
+		{value: "file:/opt/config.yaml", want: "file:/opt/config.yaml"},
+		{value: "ssm:/entra/config", want: "ssm:/entra/config"},
+		{value: "appconfig:app/env/profile", want: "appconfig:app/env/profile"},
+		{value: "appconfig:app/env"},
+		{value: "s3:bucket/key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			src, err := newSource(aws.Config{}, tt.value)
+			switch {
+			case tt.want == "" && err == nil:
+				t.Fatalf("newSource() = %s, want an error", src)
+			case tt.want != "" && err != nil:
+				t.Fatalf("newSource() error = %v", err)
+			case tt.want != "" && src.String() != tt.want:
+				t.Fatalf("newSource() = %s, want %s", src, tt.want)
+			}
+		})
+	}
+}
+
+func TestFieldsExpand(t *testing.T) {
+	fields := Fields{
+		Partition:   "aws",
+		Account:     "111111111111",
+		Role:        "ci",
+		AccountName: "prod",
+		OUPath:      "o-a1b2c3d4e5/r-ab12/",
+		AccountTags: map[string]string{"team": "payments"},
+	}
+
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{template: DefaultAppName, want: "aws-111111111111-ci"},
+		{template: "{accountName}/{role}", want: "prod/ci"},
+		{template: "{accountTag:team}-{accountTag:missing}", want: "payments-"},
+		{template: "{ouPath}", want: "o-a1b2c3d4e5/r-ab12/"},
+		{template: "{unknown} {appId}", want: "{unknown} {appId}"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			if got := fields.Expand(tt.template); got != tt.want {
+				t.Fatalf("Expand() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// document returns a valid document with the given extra members
+func document(members string) string {
+	if members == "" {
+		return `{"version": 1, "azure": ` + testAzure + `}`
+	}
+	return `{"version": 1, "azure": ` + testAzure + `, ` + members + `}`
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+// DefaultTTL is how long a document is used before it is reloaded
+const DefaultTTL = 5 * time.Minute
+
+// Loader keeps the current document and reloads it once its TTL has passed
+type Loader struct {
+	source   source
+	ttl      time.Duration
+	validate func(*Document) error
+
+	doc      *Document
+	loadedAt time.Time
+}
+
+// NewLoader returns a Loader for CONFIG_SOURCE and CONFIG_TTL. validate runs
+// after the schema check for checks the schema cannot express; it may be nil.
+func NewLoader(awsCfg aws.Config, validate func(*Document) error) (*Loader, error) {
+	src, err := newSource(awsCfg, os.Getenv("CONFIG_SOURCE"))
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := DefaultTTL
+	if value := os.Getenv("CONFIG_TTL"); value != "" {
+		ttl, err = time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CONFIG_TTL %q: %w", value, err)
+		}
+	}
+
+	return &Loader{source: src, ttl: ttl, validate: validate}, nil
+}
+
+// Get returns the current document, reloading it when the TTL has passed. The
+// first load must succeed. A failed reload keeps the previous document, so a
+// bad edit does not break running functions; it is logged and retried after
+// another TTL.
+func (l *Loader) Get(ctx context.Context, logger *slog.Logger) (*Document, error) {
+	if l.doc != nil && (l.ttl == 0 || time.Since(l.loadedAt) < l.ttl) {
+		return l.doc, nil
+	}
+
+	doc, err := l.load(ctx)
+	if err != nil {
+		if l.doc == nil {
+			return nil, err
+		}
+		logger.Warn("Keeping previous configuration", "source", l.source.String(), "error", err)
+		l.loadedAt = time.Now()
+		return l.doc, nil
+	}
+
+	if doc != nil {
+		if l.doc != nil {
+			logger.Info("Configuration reloaded", "source", l.source.String())
+		}
+		l.doc = doc
+	}
+	l.loadedAt = time.Now()
+	return l.doc, nil
+}
+
+// load fetches and validates the document. It returns nil when the source
+// reports no change.
+func (l *Loader) load(ctx context.Context) (*Document, error) {
+	data, err := l.source.fetch(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configuration from %s: %w", l.source, err)
+	}
+	if data == nil {
+		if l.doc == nil {
+			return nil, fmt.Errorf("configuration source %s returned no document", l.source)
+		}
+		return nil, nil
+	}
+
+	doc, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration from %s: %w", l.source, err)
+	}
+	if l.validate != nil {
+		if err := l.validate(doc); err != nil {
+			return nil, fmt.Errorf("invalid configuration from %s: %w", l.source, err)
+		}
+	}
+	return doc, nil
+}
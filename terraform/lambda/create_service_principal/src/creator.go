@@ -0,0 +1,138 @@
+package main
+
+import (
+	"strings"
+)
+
+// Kinds of CreateRole callers reported in the create result
+const (
+	creatorIAMUser        = "iamUser"
+	creatorIdentityCenter = "identityCenter"
+	creatorAssumedRole    = "assumedRole"
+	creatorFederatedUser  = "federatedUser"
+	creatorCloudFormation = "cloudFormation"
+	creatorService        = "awsService"
+	creatorRoot           = "root"
+	creatorUnknown        = "unknown"
+)
+
+// identityCenterRolePrefix starts the names of the roles IAM Identity Center
+// creates for permission sets
+const identityCenterRolePrefix = "AWSReservedSSO_"
+
+// cloudFormationService is the invokedBy of calls CloudFormation makes for a stack
+const cloudFormationService = "cloudformation.amazonaws.com"
+
+// userIdentity is the CloudTrail userIdentity of the CreateRole call
+type userIdentity struct {
+	Type           string          `json:"type"`
+	PrincipalID    string          `json:"principalId,omitempty"`
+	Arn            string          `json:"arn,omitempty"`
+	UserName       string          `json:"userName,omitempty"`
+	InvokedBy      string          `json:"invokedBy,omitempty"`
+	SessionContext *sessionContext `json:"sessionContext,omitempty"`
+}
+
+type sessionContext struct {
+	SessionIssuer sessionIssuer `json:"sessionIssuer"`
+}
+
+// sessionIssuer is the role or user whose credentials started the session
+type sessionIssuer struct {
+	Type     string `json:"type,omitempty"`
+	Arn      string `json:"arn,omitempty"`
+	UserName string `json:"userName,omitempty"`
+}
+
+// creator is who created the role, as far as CloudTrail tells
+type creator struct {
+	Arn  string
+	Kind string
+	// Login is the UPN or email the caller signed in with, or "" when the
+	// identity doesn't carry one
+	Login string
+}
+
+// describeCreator attributes the role to its creator. Without a userIdentity
+// in the event, the caller's ARN is all there is to go on.
+func describeCreator(id *userIdentity, arn string) creator {
+	if id == nil {
+		id = identityFromArn(arn)
+	}
+	if id == nil {
+		return creator{Kind: creatorUnknown}
+	}
+
+	c := creator{Arn: id.Arn, Kind: creatorUnknown}
+	switch id.Type {
+	case "IAMUser":
+		c.Kind = creatorIAMUser
+		c.Login = loginName(id.UserName)
+	case "AssumedRole":
+		// Identity Center and SAML sessions are named after the signed in
+		// user, usually their UPN or email
+		c.Kind = creatorAssumedRole
+		if id.SessionContext != nil && strings.HasPrefix(id.SessionContext.SessionIssuer.UserName, identityCenterRolePrefix) {
+			c.Kind = creatorIdentityCenter
+		}
+		c.Login = loginName(sessionName(id))
+	case "SAMLUser", "WebIdentityUser", "FederatedUser":
+		c.Kind = creatorFederatedUser
+		c.Login = loginName(id.UserName)
+	case "AWSService":
+		c.Kind = creatorService
+	case "Root":
+		c.Kind = creatorRoot
+	}
+
+	// A stack without a service role creates roles with its caller's
+	// credentials, so the login still names the caller
+	if id.InvokedBy == cloudFormationService {
+		c.Kind = creatorCloudFormation
+	}
+	return c
+}
+
+// identityFromArn rebuilds the parts of a userIdentity an ARN carries
+func identityFromArn(arn string) *userIdentity {
+	parts := strings.SplitN(arn, ":", 6)
+	if len(parts) != 6 {
+		return nil
+	}
+	resource := parts[5]
+
+	id := &userIdentity{Arn: arn}
+	switch {
+	case resource == "root":
+		id.Type = "Root"
+	case strings.HasPrefix(resource, "user/"):
+		id.Type = "IAMUser"
+		id.UserName = resource[strings.LastIndex(resource, "/")+1:]
+	case strings.HasPrefix(resource, "assumed-role/"):
+		id.Type = "AssumedRole"
+		role, _, _ := strings.Cut(strings.TrimPrefix(resource, "assumed-role/"), "/")
+		id.SessionContext = &sessionContext{SessionIssuer: sessionIssuer{Type: "Role", UserName: role}}
+	case strings.HasPrefix(resource, "federated-user/"):
+		id.Type = "FederatedUser"
+		id.UserName = strings.TrimPrefix(resource, "federated-user/")
+	}
+	return id
+}
+
+// sessionName returns the name of an assumed role session
+func sessionName(id *userIdentity) string {
+	if i := strings.LastIndex(id.Arn, "/"); i >= 0 && strings.Contains(id.Arn, ":assumed-role/") {
+		return id.Arn[i+1:]
+	}
+	// principalId is <role id>:<session name>
+	_, name, _ := strings.Cut(id.PrincipalID, ":")
+	return name
+}
+
+// loginName returns name when it could be a UPN or email
+func loginName(name string) string {
+	if !strings.Contains(name, "@") {
+		return ""
+	}
+	return name
+}
@@ -0,0 +1,209 @@
+package graphhelper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	msgraphgocore "github.com/microsoftgraph/msgraph-sdk-go-core"
+	"github.com/microsoftgraph/msgraph-sdk-go/applications"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/microsoftgraph/msgraph-sdk-go/serviceprincipals"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// appRoleMemberTypes lets users, groups, service principals and managed
+// identities be assigned an app role
+var appRoleMemberTypes = []string{"User", "Application"}
+
+// assignRetryDelays are the waits between attempts to assign an app role that
+// Entra ID hasn't replicated to the service principal yet
+var assignRetryDelays = []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second}
+
+// AppRoleAssignment is a principal's assignment to an app role of a service principal
+type AppRoleAssignment struct {
+	ID          string
+	PrincipalID string
+	AppRoleID   string
+}
+
+// AppRoleID returns the ID of the app role with the given value on the app
+// registration with the given object ID
+func (g *GraphHelper) AppRoleID(ctx context.Context, objectId string, value string) (_ string, err error) {
+	ctx, end := startSpan(ctx, "AppRoleID", attribute.String("app.objectId", objectId))
+	defer end(&err)
+
+	roles, err := g.appRoles(ctx, objectId)
+	if err != nil {
+		return "", err
+	}
+	for _, role := range roles {
+		if deref(role.GetValue()) != value || role.GetId() == nil {
+			continue
+		}
+		if enabled := role.GetIsEnabled(); enabled != nil && !*enabled {
+			return "", fmt.Errorf("app role %s is disabled", value)
+		}
+		return role.GetId().String(), nil
+	}
+	return "", fmt.Errorf("%w: no app role %s", ErrNotFound, value)
+}
+
+// AddAppRole adds an enabled app role with the given value, which users,
+// groups and service principals can be assigned, and returns its ID
+func (g *GraphHelper) AddAppRole(ctx context.Context, objectId string, value string) (_ string, err error) {
+	ctx, end := startSpan(ctx, "AddAppRole", attribute.String("app.objectId", objectId))
+	defer end(&err)
+
+	roles, err := g.appRoles(ctx, objectId)
+	if err != nil {
+		return "", err
+	}
+
+	id := uuid.New()
+	description := "Obtain tokens for the application"
+	enabled := true
+	role := models.NewAppRole()
+	role.SetId(&id)
+	role.SetValue(&value)
+	role.SetDisplayName(&value)
+	role.SetDescription(&description)
+	role.SetAllowedMemberTypes(appRoleMemberTypes)
+	role.SetIsEnabled(&enabled)
+
+	// appRoles is replaced as a whole, so the existing roles are sent along
+	requestBody := models.NewApplication()
+	requestBody.SetAppRoles(append(roles, role))
+	_, err = g.appClient.Applications().ByApplicationId(objectId).Patch(withOperation(ctx, "patchAppRoles"), requestBody, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to add app role %s: %w", value, graphError(err))
+	}
+	return id.String(), nil
+}
+
+func (g *GraphHelper) appRoles(ctx context.Context, objectId string) ([]models.AppRoleable, error) {
+	configuration := &applications.ApplicationItemRequestBuilderGetRequestConfiguration{
+		QueryParameters: &applications.ApplicationItemRequestBuilderGetQueryParameters{
+			Select: []string{"id", "appRoles"},
+		},
+	}
+	app, err := g.appClient.Applications().ByApplicationId(objectId).Get(withOperation(ctx, "getApplication"), configuration)
+	if err != nil {
+		return nil, graphError(err)
+	}
+	return app.GetAppRoles(), nil
+}
+
+// AppRoleAssignments returns every assignment to the service principal's app
+// roles, following the next page links
+func (g *GraphHelper) AppRoleAssignments(ctx context.Context, servicePrincipalId string) (_ []AppRoleAssignment, err error) {
+	ctx, end := startSpan(ctx, "AppRoleAssignments", attribute.String("servicePrincipal.id", servicePrincipalId))
+	defer end(&err)
+
+	top := int32(999)
+	configuration := &serviceprincipals.ItemAppRoleAssignedToRequestBuilderGetRequestConfiguration{
+		QueryParameters: &serviceprincipals.ItemAppRoleAssignedToRequestBuilderGetQueryParameters{
+			Select: []string{"id", "principalId", "appRoleId"},
+			Top:    &top,
+		},
+	}
+	ctx = withOperation(ctx, "listAppRoleAssignments")
+	resp, err := g.appClient.ServicePrincipals().ByServicePrincipalId(servicePrincipalId).AppRoleAssignedTo().
+		Get(ctx, configuration)
+	if err != nil {
+		return nil, graphError(err)
+	}
+
+	// An assignment missed on a later page would never be removed
+	pages, err := msgraphgocore.NewPageIterator[models.AppRoleAssignmentable](resp, g.appClient.GetAdapter(), models.CreateAppRoleAssignmentCollectionResponseFromDiscriminatorValue)
+	if err != nil {
+		return nil, err
+	}
+
+	var assignments []AppRoleAssignment
+	err = pages.Iterate(ctx, func(a models.AppRoleAssignmentable) bool {
+		assignment := AppRoleAssignment{ID: deref(a.GetId())}
+		if a.GetPrincipalId() != nil {
+			assignment.PrincipalID = a.GetPrincipalId().String()
+		}
+		if a.GetAppRoleId() != nil {
+			assignment.AppRoleID = a.GetAppRoleId().String()
+		}
+		assignments = append(assignments, assignment)
+		return true
+	})
+	if err != nil {
+		return nil, graphError(err)
+	}
+	return assignments, nil
+}
+
+// AssignAppRole assigns the principal, a user, group or service principal, to
+// an app role of the service principal. An existing assignment is not an
+// error. A role added moments ago may not have reached the service principal
+// yet, so the assignment is retried a few times.
+func (g *GraphHelper) AssignAppRole(ctx context.Context, servicePrincipalId string, principalId string, appRoleId string) (err error) {
+	ctx, end := startSpan(ctx, "AssignAppRole",
+		attribute.String("servicePrincipal.id", servicePrincipalId),
+		attribute.String("principal.id", principalId),
+	)
+	defer end(&err)
+
+	principal, err := uuid.Parse(principalId)
+	if err != nil {
+		return fmt.Errorf("invalid principal ID %q: %w", principalId, err)
+	}
+	resource, err := uuid.Parse(servicePrincipalId)
+	if err != nil {
+		return fmt.Errorf("invalid service principal ID %q: %w", servicePrincipalId, err)
+	}
+	role, err := uuid.Parse(appRoleId)
+	if err != nil {
+		return fmt.Errorf("invalid app role ID %q: %w", appRoleId, err)
+	}
+
+	requestBody := models.NewAppRoleAssignment()
+	requestBody.SetPrincipalId(&principal)
+	requestBody.SetResourceId(&resource)
+	requestBody.SetAppRoleId(&role)
+
+	for attempt := 0; ; attempt++ {
+		_, err = g.appClient.ServicePrincipals().ByServicePrincipalId(servicePrincipalId).AppRoleAssignedTo().
+			Post(withOperation(ctx, "assignAppRole"), requestBody, nil)
+		err = graphError(err)
+		if isGraphError(err, 400, "already exists") {
+			return nil
+		}
+		if attempt == len(assignRetryDelays) || !isGraphError(err, 400, "not found on application") {
+			return err
+		}
+
+		g.logger.Debug("Waiting for app role to replicate", "appRoleId", appRoleId, "attempt", attempt+1)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(assignRetryDelays[attempt]):
+		}
+	}
+}
+
+// RemoveAppRoleAssignment removes an assignment to an app role of the service principal
+func (g *GraphHelper) RemoveAppRoleAssignment(ctx context.Context, servicePrincipalId string, assignmentId string) (err error) {
+	ctx, end := startSpan(ctx, "RemoveAppRoleAssignment", attribute.String("servicePrincipal.id", servicePrincipalId))
+	defer end(&err)
+
+	err = g.appClient.ServicePrincipals().ByServicePrincipalId(servicePrincipalId).AppRoleAssignedTo().
+		ByAppRoleAssignmentId(assignmentId).Delete(withOperation(ctx, "removeAppRoleAssignment"), nil)
+	return graphError(err)
+}
+
+// isGraphError reports whether err is a Graph error with the status code and
+// a message containing text
+func isGraphError(err error, statusCode int, text string) bool {
+	var graphErr *GraphError
+	return errors.As(err, &graphErr) && graphErr.StatusCode == statusCode &&
+		strings.Contains(strings.ToLower(graphErr.Message), text)
+}
@@ -0,0 +1,204 @@
+package graphhelper
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/microsoftgraph/msgraph-sdk-go/applications"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ExposedAPI is the delegated permission scope an app exposes with its
+// identifier URI, and the client apps that may use it without consent
+type ExposedAPI struct {
+	// Scope is the value of the oauth2PermissionScope; none is defined when
+	// it is empty
+	Scope string
+	// PreAuthorizedAppIDs are the app IDs of the client apps pre-authorized
+	// for the scope
+	PreAuthorizedAppIDs []string
+}
+
+// PreAuthorizedApplications returns the app IDs of the client apps
+// pre-authorized on the app registration with the given object ID
+func (g *GraphHelper) PreAuthorizedApplications(ctx context.Context, objectId string) (_ []string, err error) {
+	ctx, end := startSpan(ctx, "PreAuthorizedApplications", attribute.String("app.objectId", objectId))
+	defer end(&err)
+
+	api, err := g.api(ctx, objectId)
+	if err != nil {
+		return nil, err
+	}
+	var appIds []string
+	for _, preAuthorized := range api.GetPreAuthorizedApplications() {
+		if appId := deref(preAuthorized.GetAppId()); appId != "" {
+			appIds = append(appIds, appId)
+		}
+	}
+	return appIds, nil
+}
+
+// AddPreAuthorizedApplication pre-authorizes the client app for the scope of
+// the app registration with the given object ID, defining the scope when it
+// is missing. A client app that is already pre-authorized is not an error.
+func (g *GraphHelper) AddPreAuthorizedApplication(ctx context.Context, objectId string, scope string, clientAppId string) (err error) {
+	ctx, end := startSpan(ctx, "AddPreAuthorizedApplication",
+		attribute.String("app.objectId", objectId),
+		attribute.String("client.appId", clientAppId),
+	)
+	defer end(&err)
+
+	api, err := g.api(ctx, objectId)
+	if err != nil {
+		return err
+	}
+
+	scopeId, added, err := ensureScope(api, scope)
+	if err != nil {
+		return err
+	}
+	if added {
+		// A scope has to exist before clients can be pre-authorized for it
+		if err := g.patchAPI(ctx, objectId, api); err != nil {
+			return fmt.Errorf("failed to add scope %s: %w", scope, err)
+		}
+	}
+
+	preAuthorized := api.GetPreAuthorizedApplications()
+	for _, existing := range preAuthorized {
+		if deref(existing.GetAppId()) != clientAppId {
+			continue
+		}
+		if slices.Contains(existing.GetDelegatedPermissionIds(), scopeId) {
+			return nil
+		}
+		existing.SetDelegatedPermissionIds(append(existing.GetDelegatedPermissionIds(), scopeId))
+		return g.patchAPI(ctx, objectId, api)
+	}
+
+	client := models.NewPreAuthorizedApplication()
+	client.SetAppId(&clientAppId)
+	client.SetDelegatedPermissionIds([]string{scopeId})
+	api.SetPreAuthorizedApplications(append(preAuthorized, client))
+	return g.patchAPI(ctx, objectId, api)
+}
+
+// RemovePreAuthorizedApplication removes the client app from the apps
+// pre-authorized on the app registration with the given object ID. A client
+// app that isn't pre-authorized is not an error.
+func (g *GraphHelper) RemovePreAuthorizedApplication(ctx context.Context, objectId string, clientAppId string) (err error) {
+	ctx, end := startSpan(ctx, "RemovePreAuthorizedApplication",
+		attribute.String("app.objectId", objectId),
+		attribute.String("client.appId", clientAppId),
+	)
+	defer end(&err)
+
+	api, err := g.api(ctx, objectId)
+	if err != nil {
+		return err
+	}
+
+	preAuthorized := api.GetPreAuthorizedApplications()
+	kept := slices.DeleteFunc(slices.Clone(preAuthorized), func(p models.PreAuthorizedApplicationable) bool {
+		return deref(p.GetAppId()) == clientAppId
+	})
+	if len(kept) == len(preAuthorized) {
+		return nil
+	}
+	api.SetPreAuthorizedApplications(kept)
+	return g.patchAPI(ctx, objectId, api)
+}
+
+// exposeAPI defines the scope on api and pre-authorizes the client apps for
+// it, then writes it to the app registration with the given object ID
+func (g *GraphHelper) exposeAPI(ctx context.Context, objectId string, api models.ApiApplicationable, spec ExposedAPI) error {
+	if spec.Scope == "" {
+		return nil
+	}
+
+	scopeId, added, err := ensureScope(api, spec.Scope)
+	if err != nil {
+		return err
+	}
+	if added {
+		if err := g.patchAPI(ctx, objectId, api); err != nil {
+			return fmt.Errorf("failed to add scope %s: %w", spec.Scope, err)
+		}
+	}
+	if len(spec.PreAuthorizedAppIDs) == 0 {
+		return nil
+	}
+
+	var preAuthorized []models.PreAuthorizedApplicationable
+	for _, appId := range spec.PreAuthorizedAppIDs {
+		client := models.NewPreAuthorizedApplication()
+		client.SetAppId(&appId)
+		client.SetDelegatedPermissionIds([]string{scopeId})
+		preAuthorized = append(preAuthorized, client)
+	}
+	api.SetPreAuthorizedApplications(preAuthorized)
+	if err := g.patchAPI(ctx, objectId, api); err != nil {
+		return fmt.Errorf("failed to pre-authorize client apps: %w", err)
+	}
+	return nil
+}
+
+// ensureScope returns the ID of the enabled scope with the given value,
+// adding it to api when it is missing
+func ensureScope(api models.ApiApplicationable, value string) (_ string, added bool, err error) {
+	for _, scope := range api.GetOauth2PermissionScopes() {
+		if deref(scope.GetValue()) != value || scope.GetId() == nil {
+			continue
+		}
+		if enabled := scope.GetIsEnabled(); enabled != nil && !*enabled {
+			return "", false, fmt.Errorf("scope %s is disabled", value)
+		}
+		return scope.GetId().String(), false, nil
+	}
+
+	id := uuid.New()
+	scopeType := "User"
+	displayName := "Access the API"
+	description := "Obtain tokens for the application on behalf of the signed-in user"
+	enabled := true
+	scope := models.NewPermissionScope()
+	scope.SetId(&id)
+	scope.SetValue(&value)
+	scope.SetTypeEscaped(&scopeType)
+	scope.SetAdminConsentDisplayName(&displayName)
+	scope.SetAdminConsentDescription(&description)
+	scope.SetUserConsentDisplayName(&displayName)
+	scope.SetUserConsentDescription(&description)
+	scope.SetIsEnabled(&enabled)
+	api.SetOauth2PermissionScopes(append(api.GetOauth2PermissionScopes(), scope))
+	return id.String(), true, nil
+}
+
+// api returns the API settings of the app registration with the given object ID
+func (g *GraphHelper) api(ctx context.Context, objectId string) (models.ApiApplicationable, error) {
+	configuration := &applications.ApplicationItemRequestBuilderGetRequestConfiguration{
+		QueryParameters: &applications.ApplicationItemRequestBuilderGetQueryParameters{
+			Select: []string{"id", "api"},
+		},
+	}
+	app, err := g.appClient.Applications().ByApplicationId(objectId).Get(withOperation(ctx, "getApplication"), configuration)
+	if err != nil {
+		return nil, graphError(err)
+	}
+	if app.GetApi() == nil {
+		return models.NewApiApplication(), nil
+	}
+	return app.GetApi(), nil
+}
+
+// patchAPI writes api to the app registration. The api property is replaced
+// as a whole, so api carries the settings read from the app.
+func (g *GraphHelper) patchAPI(ctx context.Context, objectId string, api models.ApiApplicationable) error {
+	requestBody := models.NewApplication()
+	requestBody.SetApi(api)
+	_, err := g.appClient.Applications().ByApplicationId(objectId).Patch(withOperation(ctx, "patchApi"), requestBody, nil)
+	return graphError(err)
+}
@@ -0,0 +1,44 @@
+package graphhelper
+
+import (
+	nethttp "net/http"
+	"time"
+
+	khttp "github.com/microsoft/kiota-http-go"
+	msgraphsdk "github.com/microsoftgraph/msgraph-sdk-go"
+	msgraphgocore "github.com/microsoftgraph/msgraph-sdk-go-core"
+)
+
+// transport is shared by every GraphHelper in the process, so connections to
+// Graph stay open across warm Lambda invocations.
+var transport = newTransport()
+
+func newTransport() *nethttp.Transport {
+	t := khttp.GetDefaultTransport().(*nethttp.Transport)
+	t.MaxIdleConns = 20
+	t.MaxIdleConnsPerHost = 10
+	t.IdleConnTimeout = 90 * time.Second
+	t.TLSHandshakeTimeout = 10 * time.Second
+	return t
+}
+
+// newHTTPClient returns the client used by the Graph request adapter. The
+// default Graph middleware (retries, redirects, telemetry) runs first, then
+// logging, observation and diagnostics, and finally the per attempt timeout.
+func (g *GraphHelper) newHTTPClient() *nethttp.Client {
+	clientOptions := msgraphsdk.GetDefaultClientOptions()
+	middleware := append(msgraphgocore.GetDefaultMiddlewaresWithOptions(&clientOptions),
+		newLoggingHandler(g),
+		newObserverHandler(g),
+		diagnosticsHandler{},
+		timeoutHandler{timeout: g.requestTimeout},
+	)
+
+	return &nethttp.Client{
+		Transport: khttp.NewCustomTransportWithParentTransport(transport, middleware...),
+		// Redirects are followed by the Kiota redirect handler
+		CheckRedirect: func(req *nethttp.Request, via []*nethttp.Request) error {
+			return nethttp.ErrUseLastResponse
+		},
+	}
+}
@@ -0,0 +1,250 @@
+package graphhelper
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	nethttp "net/http"
+	"strings"
+	"time"
+
+	khttp "github.com/microsoft/kiota-http-go"
+
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/create_service_principal/src/logging"
+)
+
+// DefaultRequestTimeout bounds each attempt of a Graph request unless
+// SetRequestTimeout says otherwise.
+const DefaultRequestTimeout = 10 * time.Second
+
+// maxLoggedBody caps the size of request and response bodies logged at debug level
+const maxLoggedBody = 8 << 10
+
+// loggingHandler logs every Graph request, including each retry, with the
+// client-request-id sent and the request-id returned by Graph. At debug level
+// the headers and JSON bodies are logged too, with sensitive fields redacted.
+// It logs through the GraphHelper's current logger, so a cached GraphHelper
+// logs with the attributes of the invocation using it.
+type loggingHandler struct {
+	graphHelper *GraphHelper
+}
+
+func newLoggingHandler(graphHelper *GraphHelper) *loggingHandler {
+	return &loggingHandler{graphHelper: graphHelper}
+}
+
+func (h *loggingHandler) Intercept(pipeline khttp.Pipeline, middlewareIndex int, req *nethttp.Request) (*nethttp.Response, error) {
+	ctx := req.Context()
+	logger := h.graphHelper.logger
+	debug := logger.Enabled(ctx, slog.LevelDebug)
+
+	attrs := []any{
+		"operation", operationFromContext(ctx),
+		"method", req.Method,
+		"path", req.URL.Path,
+		"clientRequestId", req.Header.Get("client-request-id"),
+	}
+	if debug {
+		logger.DebugContext(ctx, "Graph request sent", append(attrs,
+			"headers", redactHeaders(req.Header),
+			"body", requestBody(req),
+		)...)
+	}
+
+	start := time.Now()
+	resp, err := pipeline.Next(req, middlewareIndex)
+
+	attrs = append(attrs, "durationMs", time.Since(start).Milliseconds())
+	if err != nil {
+		logger.ErrorContext(ctx, "Graph request failed", append(attrs, "error", err)...)
+		return resp, err
+	}
+
+	attrs = append(attrs, "status", resp.StatusCode, "graphRequestId", resp.Header.Get("request-id"))
+	if debug {
+		attrs = append(attrs, "headers", redactHeaders(resp.Header), "body", responseBody(resp))
+	}
+	level := slog.LevelInfo
+	if resp.StatusCode >= 400 {
+		level = slog.LevelWarn
+	}
+	logger.Log(ctx, level, "Graph request", attrs...)
+
+	return resp, nil
+}
+
+// diagnosticsHandler adds the request details to errors that happen before Graph
+// responds, such as timeouts and connection failures, so they can still be
+// matched with Graph's side of the request.
+type diagnosticsHandler struct{}
+
+func (diagnosticsHandler) Intercept(pipeline khttp.Pipeline, middlewareIndex int, req *nethttp.Request) (*nethttp.Response, error) {
+	resp, err := pipeline.Next(req, middlewareIndex)
+	if err != nil {
+		return resp, fmt.Errorf("graph %s %s failed (operation: %s, client-request-id: %s): %w",
+			req.Method, req.URL.Path, operationFromContext(req.Context()), req.Header.Get("client-request-id"), err)
+	}
+	return resp, nil
+}
+
+// timeoutHandler bounds each attempt of a Graph request, so a hung connection
+// fails fast instead of using up the Lambda timeout.
+type timeoutHandler struct {
+	timeout time.Duration
+}
+
+func (h timeoutHandler) Intercept(pipeline khttp.Pipeline, middlewareIndex int, req *nethttp.Request) (*nethttp.Response, error) {
+	if h.timeout <= 0 {
+		return pipeline.Next(req, middlewareIndex)
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), h.timeout)
+	resp, err := pipeline.Next(req.WithContext(ctx), middlewareIndex)
+	if err != nil || resp == nil || resp.Body == nil {
+		cancel()
+		return resp, err
+	}
+
+	// The body is read after the middleware returns, so the deadline is
+	// released once the caller closes it
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
+
+// operationKey is the context key under which graphhelper methods name the
+// Graph operation they are performing
+type operationKey struct{}
+
+func withOperation(ctx context.Context, operation string) context.Context {
+	return context.WithValue(ctx, operationKey{}, operation)
+}
+
+func operationFromContext(ctx context.Context) string {
+	if operation, ok := ctx.Value(operationKey{}).(string); ok {
+		return operation
+	}
+	return "unknown"
+}
+
+// retryAttemptHeader is set by the Kiota retry handler on retried requests
+const retryAttemptHeader = "Retry-Attempt"
+
+// observerHandler reports every Graph request, including each retry, to the
+// GraphHelper's RequestObserver.
+type observerHandler struct {
+	graphHelper *GraphHelper
+}
+
+func newObserverHandler(graphHelper *GraphHelper) *observerHandler {
+	return &observerHandler{graphHelper: graphHelper}
+}
+
+func (h *observerHandler) Intercept(pipeline khttp.Pipeline, middlewareIndex int, req *nethttp.Request) (*nethttp.Response, error) {
+	observer := h.graphHelper.observer
+	if observer == nil {
+		return pipeline.Next(req, middlewareIndex)
+	}
+
+	start := time.Now()
+	resp, err := pipeline.Next(req, middlewareIndex)
+
+	statusCode := 0
+	if err == nil && resp != nil {
+		statusCode = resp.StatusCode
+	}
+	retry := req.Header.Get(retryAttemptHeader) != ""
+	observer.ObserveGraphRequest(operationFromContext(req.Context()), statusCode, time.Since(start), retry)
+
+	return resp, err
+}
+
+// requestBody returns the redacted request body and puts the body back so it
+// can still be sent.
+func requestBody(req *nethttp.Request) any {
+	if req.Body == nil || req.Body == nethttp.NoBody {
+		return nil
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return fmt.Sprintf("<unreadable: %v>", err)
+	}
+	return redactBody(req.Header.Get("Content-Type"), body)
+}
+
+// responseBody returns the redacted response body and replaces it with an
+// in-memory copy for the caller.
+func responseBody(resp *nethttp.Response) any {
+	if resp.Body == nil {
+		return nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return fmt.Sprintf("<unreadable: %v>", err)
+	}
+	return redactBody(resp.Header.Get("Content-Type"), body)
+}
+
+func redactBody(contentType string, body []byte) any {
+	if len(body) == 0 {
+		return nil
+	}
+	if !strings.Contains(contentType, "json") {
+		return fmt.Sprintf("<%d bytes of %s>", len(body), contentType)
+	}
+	if len(body) > maxLoggedBody {
+		return fmt.Sprintf("<%d bytes of JSON, over the %d byte logging limit>", len(body), maxLoggedBody)
+	}
+
+	var value any
+	if err := json.Unmarshal(body, &value); err != nil {
+		return fmt.Sprintf("<%d bytes of invalid JSON>", len(body))
+	}
+	return redactValue(value)
+}
+
+func redactValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		for key, field := range v {
+			if logging.Sensitive(key) {
+				v[key] = logging.Redacted
+			} else {
+				v[key] = redactValue(field)
+			}
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = redactValue(item)
+		}
+	}
+	return value
+}
+
+func redactHeaders(headers nethttp.Header) map[string]string {
+	out := make(map[string]string, len(headers))
+	for name, values := range headers {
+		if logging.Sensitive(name) {
+			out[name] = logging.Redacted
+			continue
+		}
+		out[name] = strings.Join(values, ", ")
+	}
+	return out
+}
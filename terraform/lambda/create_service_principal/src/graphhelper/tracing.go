@@ -0,0 +1,42 @@
+package graphhelper
+
+import (
+	"context"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer records a span for every GraphHelper operation. Kiota adds spans for
+// the HTTP requests underneath through the same global tracer provider.
+var tracer = otel.Tracer("github.com/borkod/poc-aws-azure-oidc/graphhelper")
+
+// startSpan starts the span of a GraphHelper operation. The returned function
+// ends it, recording *err as the span status.
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, func(*error)) {
+	ctx, span := tracer.Start(ctx, "graphhelper."+name, trace.WithAttributes(attrs...))
+	return ctx, func(err *error) {
+		if err != nil && *err != nil {
+			span.RecordError(*err)
+			span.SetStatus(codes.Error, (*err).Error())
+		}
+		span.End()
+	}
+}
+
+// tracedCredential records a span for every Entra ID token request, so token
+// issuance shows up separately from the Graph call that needed the token.
+type tracedCredential struct {
+	credential azcore.TokenCredential
+}
+
+func (c tracedCredential) GetToken(ctx context.Context, options policy.TokenRequestOptions) (token azcore.AccessToken, err error) {
+	ctx, end := startSpan(ctx, "GetToken")
+	defer end(&err)
+
+	return c.credential.GetToken(ctx, options)
+}
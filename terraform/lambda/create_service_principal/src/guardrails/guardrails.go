@@ -0,0 +1,230 @@
+// Package guardrails decides whether a role may get an Entra app, based on
+// declarative allow and deny rules over the account, its OU path, the role's
+// name and path and its tags. With account enrichment, rules can also match the
+// account's name and tags.
+//
+// Rules are the policy section of the configuration document and are tried in
+// order; the first rule whose conditions all match decides. When no rule
+// matches, defaultAction decides:
+//
+//	policy:
+//	  defaultAction: allow
+//	  rules:
+//	    - name: no-sandbox
+//	      action: deny
+//	      ouPaths: [o-a1b2c3d4e5/r-ab12/ou-ab12-sandbox1/]
+//	    - name: no-service-roles
+//	      action: deny
+//	      rolePath: ^/service-role/
+//	    - name: production-naming
+//	      action: allow
+//	      ouPaths: [o-a1b2c3d4e5/r-ab12/ou-ab12-prod1111/]
+//	      roleName: ^app-[a-z0-9-]+$
+//	    - name: production-other
+//	      action: deny
+//	      ouPaths: [o-a1b2c3d4e5/r-ab12/ou-ab12-prod1111/]
+//	    - name: decommissioning
+//	      action: deny
+//	      accountTags: {lifecycle: decommissioning}
+//
+// A role whose path is unknown is denied when any rule matches on rolePath,
+// since it can't be told which of those rules apply.
+package guardrails
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Rule actions
+const (
+	Allow = "allow"
+	Deny  = "deny"
+)
+
+// Rule allows or denies the roles matching all of its conditions. Conditions
+// that are left out match every role.
+type Rule struct {
+	Name     string            `json:"name"`
+	Action   string            `json:"action"`
+	Accounts []string          `json:"accounts,omitempty"`
+	OUPaths  []string          `json:"ouPaths,omitempty"`
+	RoleName string            `json:"roleName,omitempty"`
+	RolePath string            `json:"rolePath,omitempty"`
+	Tags     map[string]string `json:"tags,omitempty"`
+
+	// AccountName and AccountTags need account enrichment
+	AccountName string            `json:"accountName,omitempty"`
+	AccountTags map[string]string `json:"accountTags,omitempty"`
+
+	roleName    *regexp.Regexp
+	rolePath    *regexp.Regexp
+	accountName *regexp.Regexp
+}
+
+// Policy is the ordered rule set
+type Policy struct {
+	DefaultAction string `json:"defaultAction"`
+	Rules         []Rule `json:"rules,omitempty"`
+}
+
+// Role is what the rules are evaluated against
+type Role struct {
+	Account string
+	// OUPath is in the form of aws:PrincipalOrgPaths, empty when not looked up
+	OUPath string
+	Name   string
+	// Path is the role path, e.g. /service-role/, empty when unknown
+	Path string
+	Tags map[string]string
+	// AccountName and AccountTags are empty unless the account was enriched
+	AccountName string
+	AccountTags map[string]string
+}
+
+// Decision is the outcome of evaluating a role
+type Decision struct {
+	Allowed bool
+	// Rule names the rule that decided, empty when the default action did
+	Rule string
+	// PathUnknown is set when the role was denied for lack of a path
+	PathUnknown bool
+}
+
+// AllowAll returns the policy used when none is configured
+func AllowAll() *Policy {
+	return &Policy{DefaultAction: Allow}
+}
+
+// Parse parses a JSON policy and compiles its regular expressions
+func Parse(data []byte) (*Policy, error) {
+	var policy Policy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return nil, err
+	}
+	if policy.DefaultAction == "" {
+		policy.DefaultAction = Allow
+	}
+
+	var errs []error
+	if policy.DefaultAction != Allow && policy.DefaultAction != Deny {
+		errs = append(errs, fmt.Errorf("defaultAction must be %s or %s, not %q", Allow, Deny, policy.DefaultAction))
+	}
+	names := map[string]bool{}
+	for i := range policy.Rules {
+		rule := &policy.Rules[i]
+		if rule.Name == "" {
+			errs = append(errs, fmt.Errorf("rule %d has no name", i))
+		} else if names[rule.Name] {
+			errs = append(errs, fmt.Errorf("rule %q is defined twice", rule.Name))
+		}
+		names[rule.Name] = true
+		if rule.Action != Allow && rule.Action != Deny {
+			errs = append(errs, fmt.Errorf("rule %q: action must be %s or %s, not %q", rule.Name, Allow, Deny, rule.Action))
+		}
+
+		var err error
+		if rule.roleName, err = compile(rule.RoleName); err != nil {
+			errs = append(errs, fmt.Errorf("rule %q: invalid roleName: %w", rule.Name, err))
+		}
+		if rule.rolePath, err = compile(rule.RolePath); err != nil {
+			errs = append(errs, fmt.Errorf("rule %q: invalid rolePath: %w", rule.Name, err))
+		}
+		if rule.accountName, err = compile(rule.AccountName); err != nil {
+			errs = append(errs, fmt.Errorf("rule %q: invalid accountName: %w", rule.Name, err))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// NeedsOUPath reports whether any rule matches on OU paths, so callers only
+// look up an account's OU when it matters.
+func (p *Policy) NeedsOUPath() bool {
+	for _, rule := range p.Rules {
+		if len(rule.OUPaths) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// NeedsRolePath reports whether any rule matches on the role's path, so
+// callers read it from IAM when the event doesn't carry it.
+func (p *Policy) NeedsRolePath() bool {
+	for _, rule := range p.Rules {
+		if rule.RolePath != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// NeedsAccount reports whether any rule matches on the account's name or tags,
+// which are only known when accounts are enriched.
+func (p *Policy) NeedsAccount() bool {
+	for _, rule := range p.Rules {
+		if rule.AccountName != "" || len(rule.AccountTags) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Evaluate returns the decision of the first matching rule, or the default
+// action when no rule matches.
+func (p *Policy) Evaluate(role Role) Decision {
+	if role.Path == "" && p.NeedsRolePath() {
+		return Decision{PathUnknown: true}
+	}
+	for _, rule := range p.Rules {
+		if rule.matches(role) {
+			return Decision{Allowed: rule.Action == Allow, Rule: rule.Name}
+		}
+	}
+	return Decision{Allowed: p.DefaultAction == Allow}
+}
+
+func (r Rule) matches(role Role) bool {
+	if len(r.Accounts) > 0 && !slices.Contains(r.Accounts, role.Account) {
+		return false
+	}
+	if len(r.OUPaths) > 0 && !slices.ContainsFunc(r.OUPaths, func(path string) bool {
+		return role.OUPath != "" && strings.HasPrefix(role.OUPath, strings.TrimSuffix(path, "/")+"/")
+	}) {
+		return false
+	}
+	if r.roleName != nil && !r.roleName.MatchString(role.Name) {
+		return false
+	}
+	if r.rolePath != nil && !r.rolePath.MatchString(role.Path) {
+		return false
+	}
+	if r.accountName != nil && !r.accountName.MatchString(role.AccountName) {
+		return false
+	}
+	return hasTags(role.Tags, r.Tags) && hasTags(role.AccountTags, r.AccountTags)
+}
+
+// hasTags reports whether tags has every key of want with the same value
+func hasTags(tags, want map[string]string) bool {
+	for key, value := range want {
+		if tagValue, ok := tags[key]; !ok || tagValue != value {
+			return false
+		}
+	}
+	return true
+}
+
+func compile(expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	return regexp.Compile(expr)
+}
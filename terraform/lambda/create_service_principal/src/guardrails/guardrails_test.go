@@ -0,0 +1,128 @@
+package guardrails
+
+import (
+	"strings"
+	"testing"
+)
+
+const testPolicy = `{
+	"defaultAction": "allow",
+	"rules": [
+		{"name": "no-sandbox", "action": "deny", "ouPaths": ["o-a1b2c3d4e5/r-ab12/ou-ab12-sandbox1/"]},
+		{"name": "no-service-roles", "action": "deny", "rolePath": "^/service-role/"},
+		{"name": "production-naming", "action": "allow", "ouPaths": ["o-a1b2c3d4e5/r-ab12/ou-ab12-prod1111"], "roleName": "^app-[a-z0-9-]+$"},
+		{"name": "production-other", "action": "deny", "ouPaths": ["o-a1b2c3d4e5/r-ab12/ou-ab12-prod1111/"]},
+		{"name": "decommissioning", "action": "deny", "accountTags": {"lifecycle": "decommissioning"}},
+		{"name": "audit", "action": "deny", "accounts": ["222222222222"], "tags": {"team": "audit"}}
+	]
+}`
+
+func TestEvaluate(t *testing.T) {
+	policy, err := Parse([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	const (
+		sandbox    = "o-a1b2c3d4e5/r-ab12/ou-ab12-sandbox1/"
+		production = "o-a1b2c3d4e5/r-ab12/ou-ab12-prod1111/"
+	)
+
+	tests := []struct {
+		name        string
+		role        Role
+		wantAllowed bool
+		wantRule    string
+	}{
+		{name: "no rule matches", role: Role{Name: "ci", Path: "/"}, wantAllowed: true},
+		{name: "OU", role: Role{OUPath: sandbox, Name: "ci", Path: "/"}, wantRule: "no-sandbox"},
+		{name: "OU below", role: Role{OUPath: sandbox + "ou-ab12-team1111/", Name: "ci", Path: "/"}, wantRule: "no-sandbox"},
+		{name: "OU with the same prefix", role: Role{OUPath: "o-a1b2c3d4e5/r-ab12/ou-ab12-sandbox12/", Name: "ci", Path: "/"}, wantAllowed: true},
+		{name: "OU above", role: Role{OUPath: "o-a1b2c3d4e5/r-ab12/", Name: "ci", Path: "/"}, wantAllowed: true},
+		{name: "OU not looked up", role: Role{Name: "ci", Path: "/"}, wantAllowed: true},
+		{name: "rule OU without trailing slash", role: Role{OUPath: production, Name: "app-orders", Path: "/"}, wantAllowed: true, wantRule: "production-naming"},
+		{name: "first match wins", role: Role{OUPath: production, Name: "app-orders", Path: "/service-role/"}, wantRule: "no-service-roles"},
+		{name: "later rule after a partial match", role: Role{OUPath: production, Name: "Legacy", Path: "/"}, wantRule: "production-other"},
+		{name: "role path", role: Role{Name: "ci", Path: "/service-role/"}, wantRule: "no-service-roles"},
+		{name: "nested role path", role: Role{Name: "ci", Path: "/teams/service-role/"}, wantAllowed: true},
+		{name: "account tags", role: Role{Name: "ci", Path: "/", AccountTags: map[string]string{"lifecycle": "decommissioning"}}, wantRule: "decommissioning"},
+		{name: "account tag with another value", role: Role{Name: "ci", Path: "/", AccountTags: map[string]string{"lifecycle": "active"}}, wantAllowed: true},
+		{name: "account and role tags", role: Role{Account: "222222222222", Name: "ci", Path: "/", Tags: map[string]string{"team": "audit"}}, wantRule: "audit"},
+		{name: "role tags in another account", role: Role{Account: "111111111111", Name: "ci", Path: "/", Tags: map[string]string{"team": "audit"}}, wantAllowed: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := policy.Evaluate(tt.role)
+			if got.Allowed != tt.wantAllowed || got.Rule != tt.wantRule || got.PathUnknown {
+				t.Fatalf("Evaluate() = %+v, want allowed %v by rule %q", got, tt.wantAllowed, tt.wantRule)
+			}
+		})
+	}
+}
+
+func TestEvaluateDefaultDeny(t *testing.T) {
+	policy, err := Parse([]byte(`{"defaultAction": "deny", "rules": [{"name": "apps", "action": "allow", "roleName": "^app-"}]}`))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got := policy.Evaluate(Role{Name: "app-orders"}); !got.Allowed || got.Rule != "apps" {
+		t.Fatalf("Evaluate() = %+v, want allowed by apps", got)
+	}
+	if got := policy.Evaluate(Role{Name: "ci"}); got.Allowed || got.Rule != "" {
+		t.Fatalf("Evaluate() = %+v, want denied by the default action", got)
+	}
+}
+
+func TestEvaluateUnknownPath(t *testing.T) {
+	policy, err := Parse([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if got := policy.Evaluate(Role{Name: "ci"}); got.Allowed || !got.PathUnknown {
+		t.Fatalf("Evaluate() = %+v, want denied for the unknown path", got)
+	}
+
+	// Without rolePath rules the path doesn't matter
+	if got := AllowAll().Evaluate(Role{Name: "ci"}); !got.Allowed {
+		t.Fatalf("AllowAll().Evaluate() = %+v, want allowed", got)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		want   string
+	}{
+		{name: "default action", policy: `{"defaultAction": "maybe"}`, want: "defaultAction"},
+		{name: "no name", policy: `{"rules": [{"action": "deny"}]}`, want: "rule 0 has no name"},
+		{name: "duplicate name", policy: `{"rules": [{"name": "a", "action": "deny"}, {"name": "a", "action": "allow"}]}`, want: `rule "a" is defined twice`},
+		{name: "action", policy: `{"rules": [{"name": "a", "action": "block"}]}`, want: `rule "a": action`},
+		{name: "roleName", policy: `{"rules": [{"name": "a", "action": "deny", "roleName": "("}]}`, want: "invalid roleName"},
+		{name: "rolePath", policy: `{"rules": [{"name": "a", "action": "deny", "rolePath": "["}]}`, want: "invalid rolePath"},
+		{name: "accountName", policy: `{"rules": [{"name": "a", "action": "deny", "accountName": "*"}]}`, want: "invalid accountName"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.policy))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Parse() error = %v, want one containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNeeds(t *testing.T) {
+	policy, err := Parse([]byte(testPolicy))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if !policy.NeedsOUPath() || !policy.NeedsRolePath() || !policy.NeedsAccount() {
+		t.Fatalf("Needs* = %v %v %v, want all true", policy.NeedsOUPath(), policy.NeedsRolePath(), policy.NeedsAccount())
+	}
+	if p := AllowAll(); p.NeedsOUPath() || p.NeedsRolePath() || p.NeedsAccount() {
+		t.Fatal("AllowAll() needs lookups")
+	}
+}
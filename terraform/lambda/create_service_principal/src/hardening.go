@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/create_service_principal/src/graphhelper"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+)
+
+// repairHardening reapplies the hardening profile to an app that drifted from
+// it and reports whether it did. A failure is returned, since an app that
+// stays out of the profile could be given credentials or redirect URIs.
+func repairHardening(ctx context.Context, logger *slog.Logger, graphHelper *graphhelper.GraphHelper, app models.Applicationable, state *appState, dryRun bool) (bool, error) {
+	drift := graphhelper.HardeningDrift(app)
+	if len(drift) == 0 {
+		return false, nil
+	}
+
+	logger.Warn("App drifted from the hardening profile", "appId", state.AppID, "properties", drift)
+	if dryRun {
+		state.Plan = append(state.Plan, plannedOperation{Operation: opPatchApplication, Name: "hardening", ID: state.ObjectID})
+		return true, nil
+	}
+	if err := graphHelper.HardenApplication(ctx, state.ObjectID); err != nil {
+		logger.Error("Failed to harden app", "appId", state.AppID, "error", err)
+		return false, fmt.Errorf("failed to harden app %s: %w", state.AppID, err)
+	}
+	return true, nil
+}
+
+// ensureAppManagementPolicy assigns the app management policy to an existing
+// app that lacks it and reports whether it did. An app can only have one
+// policy, and one with another policy was given it on purpose, so it keeps it
+// and gets a warning.
+func ensureAppManagementPolicy(ctx context.Context, logger *slog.Logger, graphHelper *graphhelper.GraphHelper, state *appState, policyId string, dryRun bool) (bool, error) {
+	assigned, err := graphHelper.AppManagementPolicyIDs(ctx, state.ObjectID)
+	if err != nil {
+		logger.Error("Failed to read app management policies", "appId", state.AppID, "error", err)
+		return false, fmt.Errorf("failed to read app management policies of app %s: %w", state.AppID, err)
+	}
+	switch {
+	case slices.Contains(assigned, policyId):
+		return false, nil
+	case len(assigned) > 0:
+		state.Warnings = append(state.Warnings, fmt.Sprintf("app management policy %s not assigned, app has policy %s", policyId, assigned[0]))
+		return false, nil
+	case dryRun:
+		state.Plan = append(state.Plan, plannedOperation{Operation: opAssignAppManagementPolicy, ID: policyId})
+		return true, nil
+	}
+	if err := assignAppManagementPolicy(ctx, logger, graphHelper, state, policyId); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// assignAppManagementPolicy assigns the app management policy to the app. The
+// policy is what stops owners from adding secrets, so a failure is returned.
+func assignAppManagementPolicy(ctx context.Context, logger *slog.Logger, graphHelper *graphhelper.GraphHelper, state *appState, policyId string) error {
+	if err := graphHelper.AssignAppManagementPolicy(ctx, state.ObjectID, policyId); err != nil {
+		logger.Error("Failed to assign app management policy", "appId", state.AppID, "policyId", policyId, "error", err)
+		return fmt.Errorf("failed to assign app management policy %s to app %s: %w", policyId, state.AppID, err)
+	}
+	return nil
+}
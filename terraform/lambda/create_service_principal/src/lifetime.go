@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/create_service_principal/src/config"
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/create_service_principal/src/graphhelper"
+)
+
+// chooseTokenLifetime returns the name of the token lifetime policy for the
+// role, or "" when it gets none. A tag naming an undefined policy is ignored
+// with a warning.
+func chooseTokenLifetime(settings config.TokenLifetime, account, ouPath string, tags map[string]string, state *appState) string {
+	if value, ok := tags[tokenLifetimeTag]; ok {
+		name := strings.TrimSpace(value)
+		if _, defined := settings.Policies[name]; defined {
+			return name
+		}
+		state.Warnings = append(state.Warnings, fmt.Sprintf("ignored role tag %s=%q, expected one of the token lifetime policies", tokenLifetimeTag, value))
+	}
+	return settings.Select(account, ouPath, tags)
+}
+
+// reconcileTokenLifetime makes the named policy the only managed token
+// lifetime policy of the service principal, creating it or updating its
+// lifetime as needed, and returns its ID. An empty name removes the managed
+// policies. Policies not managed by the automation are left alone. A lifetime
+// only bounds how long an issued token stays valid, within the day Entra ID
+// allows, and never who gets one, so failures are recorded as warnings.
+func reconcileTokenLifetime(ctx context.Context, logger *slog.Logger, graphHelper *graphhelper.GraphHelper, state *appState, settings config.TokenLifetime, name string, dryRun bool) string {
+	warn := func(msg string, err error) string {
+		logger.Warn("Failed to reconcile token lifetime policy", "step", msg, "error", err)
+		state.Warnings = append(state.Warnings, fmt.Sprintf("%s: %v", msg, err))
+		return ""
+	}
+
+	var target graphhelper.Policy
+	if name != "" {
+		lifetime, _ := settings.Lifetime(name)
+		displayName, definition := graphhelper.TokenLifetimePolicy(name, lifetime)
+
+		policy, err := graphHelper.FindTokenLifetimePolicy(ctx, displayName)
+		switch {
+		case errors.Is(err, graphhelper.ErrNotFound) && dryRun:
+			state.Plan = append(state.Plan, plannedOperation{Operation: opCreateTokenLifetimePolicy, Name: displayName})
+			policy = graphhelper.Policy{DisplayName: displayName}
+		case errors.Is(err, graphhelper.ErrNotFound):
+			policy.ID, err = graphHelper.CreateTokenLifetimePolicy(ctx, displayName, definition)
+			if err != nil {
+				return warn("failed to create token lifetime policy "+displayName, err)
+			}
+			policy.DisplayName = displayName
+			logger.Info("Created token lifetime policy", "policyName", displayName, "policyId", policy.ID)
+		case err != nil:
+			return warn("failed to find token lifetime policy "+displayName, err)
+		case policy.Definition != definition && dryRun:
+			state.Plan = append(state.Plan, plannedOperation{Operation: opPatchTokenLifetimePolicy, Name: displayName, ID: policy.ID})
+		case policy.Definition != definition:
+			// The lifetime changes for every app the policy is assigned to
+			if err := graphHelper.UpdateTokenLifetimePolicy(ctx, policy.ID, definition); err != nil {
+				return warn("failed to update token lifetime policy "+displayName, err)
+			}
+			logger.Info("Updated token lifetime policy", "policyName", displayName, "lifetime", lifetime)
+		}
+		target = policy
+	}
+
+	// A new app in a dry run has no service principal yet
+	if state.ServicePrincipalID == "" {
+		if target.DisplayName != "" && dryRun {
+			state.Plan = append(state.Plan, plannedOperation{Operation: opAssignTokenLifetimePolicy, Name: target.DisplayName, ID: target.ID})
+		}
+		return target.ID
+	}
+
+	assigned, err := graphHelper.TokenLifetimePolicies(ctx, state.ServicePrincipalID)
+	if err != nil {
+		return warn("failed to read token lifetime policies", err)
+	}
+
+	var others []graphhelper.Policy
+	for _, policy := range assigned {
+		switch {
+		case target.ID != "" && policy.ID == target.ID:
+		case !policy.Managed():
+			others = append(others, policy)
+		case dryRun:
+			state.Plan = append(state.Plan, plannedOperation{Operation: opUnassignTokenLifetimePolicy, Name: policy.DisplayName, ID: policy.ID})
+		default:
+			if err := graphHelper.UnassignTokenLifetimePolicy(ctx, state.ServicePrincipalID, policy.ID); err != nil {
+				warn("failed to unassign token lifetime policy "+policy.DisplayName, err)
+				continue
+			}
+			logger.Info("Unassigned token lifetime policy", "policyName", policy.DisplayName, "policyId", policy.ID)
+		}
+	}
+
+	switch {
+	case target.DisplayName == "":
+		return ""
+	case target.ID != "" && slices.ContainsFunc(assigned, func(p graphhelper.Policy) bool { return p.ID == target.ID }):
+		return target.ID
+	case len(others) > 0:
+		// A service principal can only have one token lifetime policy
+		state.Warnings = append(state.Warnings, fmt.Sprintf("token lifetime policy %s not assigned, service principal has policy %s", target.DisplayName, others[0].DisplayName))
+		return ""
+	case dryRun:
+		state.Plan = append(state.Plan, plannedOperation{Operation: opAssignTokenLifetimePolicy, Name: target.DisplayName, ID: target.ID})
+		return target.ID
+	}
+
+	if err := graphHelper.AssignTokenLifetimePolicy(ctx, state.ServicePrincipalID, target.ID); err != nil {
+		return warn("failed to assign token lifetime policy "+target.DisplayName, err)
+	}
+	logger.Info("Assigned token lifetime policy", "policyName", target.DisplayName, "policyId", target.ID)
+	return target.ID
+}
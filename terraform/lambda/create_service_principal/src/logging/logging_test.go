@@ -0,0 +1,76 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+func TestSensitive(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{name: "Authorization", want: true},
+		{name: "clientSecret", want: true},
+		{name: "secretText", want: true},
+		{name: "passwordCredentials", want: true},
+		{name: "keyCredentials", want: true},
+		{name: "client_assertion", want: true},
+		{name: "Set-Cookie", want: true},
+		{name: "accessToken", want: true},
+		{name: "taskToken", want: true},
+		{name: "refresh_token", want: true},
+		{name: "TOKEN", want: true},
+		{name: "tokenVersion", want: false},
+		{name: "requestedAccessTokenVersion", want: false},
+		{name: "appId", want: false},
+		{name: "roleArn", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Sensitive(tt.name); got != tt.want {
+				t.Fatalf("Sensitive(%q) = %v, want %v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedact(t *testing.T) {
+	var out bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&out, &slog.HandlerOptions{ReplaceAttr: redact}))
+	logger.Info("token issued",
+		"clientSecret", "s3cr3t",
+		"taskToken", "AAAA",
+		"tokenVersion", 2,
+		slog.Group("request", "Authorization", "Bearer eyJ0", "appId", "app"),
+	)
+
+	var entry map[string]any
+	if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+		t.Fatalf("unmarshal %s: %v", out.Bytes(), err)
+	}
+	request, _ := entry["request"].(map[string]any)
+
+	tests := []struct {
+		name string
+		got  any
+		want any
+	}{
+		{name: "client secret", got: entry["clientSecret"], want: Redacted},
+		{name: "task token", got: entry["taskToken"], want: Redacted},
+		{name: "token version", got: entry["tokenVersion"], want: float64(2)},
+		{name: "grouped authorization", got: request["Authorization"], want: Redacted},
+		{name: "grouped app ID", got: request["appId"], want: "app"},
+		{name: "message", got: entry[slog.MessageKey], want: "token issued"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.got != tt.want {
+				t.Fatalf("got %v, want %v", tt.got, tt.want)
+			}
+		})
+	}
+}
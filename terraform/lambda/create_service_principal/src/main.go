@@ -5,153 +5,505 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
+	"time"
 
 	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/create_service_principal/src/graphhelper"
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/create_service_principal/src/guardrails"
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/create_service_principal/src/logging"
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/create_service_principal/src/metrics"
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/create_service_principal/src/partition"
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/create_service_principal/src/tracing"
 
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/aws/aws-sdk-go-v2/otelaws"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
-// Response structure
+// resultVersion is bumped whenever a Response field is removed or changes meaning
+const resultVersion = "1"
+
+// Response is the versioned result of the create step. It carries everything
+// later steps and external consumers need, so they don't have to call Graph.
 type Response struct {
-	StatusCode int               `json:"statusCode"`
-	Headers    map[string]string `json:"headers"`
-	Audience   string            `json:"audience"`
+	Version    string `json:"version"`
+	StatusCode int    `json:"statusCode"`
+	Status     string `json:"status,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+	Action     string `json:"action,omitempty"`
+
+	AppID                 string            `json:"appId,omitempty"`
+	ApplicationObjectID   string            `json:"applicationObjectId,omitempty"`
+	ServicePrincipalID    string            `json:"servicePrincipalId,omitempty"`
+	IdentifierURIs        []string          `json:"identifierUris,omitempty"`
+	Audience              string            `json:"audience,omitempty"`
+	TenantID              string            `json:"tenantId,omitempty"`
+	Issuer                string            `json:"issuer,omitempty"`
+	OIDCURL               string            `json:"oidcUrl,omitempty"`
+	Tenant                string            `json:"tenant,omitempty"`
+	AccountName           string            `json:"accountName,omitempty"`
+	RoleArn               string            `json:"roleArn,omitempty"`
+	PolicyRule            string            `json:"policyRule,omitempty"`
+	Creator               string            `json:"creator,omitempty"`
+	CreatorType           string            `json:"creatorType,omitempty"`
+	Owners                []owner           `json:"owners,omitempty"`
+	Access                *accessState      `json:"access,omitempty"`
+	PreAuthorizedApps     []string          `json:"preAuthorizedApps,omitempty"`
+	TokenLifetimePolicyID string            `json:"tokenLifetimePolicyId,omitempty"`
+	SecurityAttributes    map[string]string `json:"securityAttributes,omitempty"`
+
+	Conditions  map[string][]string `json:"conditions,omitempty"`
+	TrustPolicy *trustPolicyUpdate  `json:"trustPolicy,omitempty"`
+	Warnings    []string            `json:"warnings,omitempty"`
+
+	DryRun bool               `json:"dryRun,omitempty"`
+	Plan   []plannedOperation `json:"plan,omitempty"`
 }
 
 type eventStruct struct {
-	Account   string `json:"account"`
-	EventName string `json:"eventName"`
-	RoleName  string `json:"roleName"`
+	Account                  string        `json:"account"`
+	EventName                string        `json:"eventName"`
+	RoleName                 string        `json:"roleName"`
+	RoleArn                  string        `json:"roleArn,omitempty"`
+	AssumeRolePolicyDocument string        `json:"assumeRolePolicyDocument,omitempty"`
+	Tags                     []roleTag     `json:"tags,omitempty"`
+	TagKeys                  []string      `json:"tagKeys,omitempty"`
+	Creator                  string        `json:"creator,omitempty"`
+	UserIdentity             *userIdentity `json:"userIdentity,omitempty"`
+	DryRun                   bool          `json:"dryRun,omitempty"`
+	EventID                  string        `json:"eventID,omitempty"`
+	Region                   string        `json:"region,omitempty"`
+	ExecutionArn             string        `json:"executionArn,omitempty"`
+}
+
+// actionMetrics maps the create action to the metric that counts it
+var actionMetrics = map[string]string{
+	actionCreated:  metrics.AppsCreated,
+	actionReused:   metrics.AppsReused,
+	actionRepaired: metrics.AppsRepaired,
+	actionUpdated:  metrics.AppsUpdated,
+}
+
+// roleTag is a tag as it appears in the CloudTrail CreateRole request parameters
+type roleTag struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
 }
 
 var (
-	ssmClient *ssm.Client
+	awsCfg         aws.Config
+	ssmClient      *ssm.Client
+	baseLogger     *slog.Logger
+	tracerProvider *sdktrace.TracerProvider
 )
 
 func init() {
+	baseLogger = logging.New()
+
 	// Initialize the S3 client outside of the handler, during the init phase
 	cfg, err := config.LoadDefaultConfig(context.TODO())
 	if err != nil {
-		log.Fatalf("unable to load SDK config, %v", err)
+		baseLogger.Error("unable to load SDK config", "error", err)
+		os.Exit(1)
+	}
+
+	tracerProvider, err = tracing.Init(context.TODO(), "create-service-principal")
+	if err != nil {
+		baseLogger.Error("unable to set up tracing", "error", err)
+		os.Exit(1)
+	}
+	if tracerProvider != nil {
+		// Spans for SSM, STS and IAM calls
+		otelaws.AppendMiddlewares(&cfg.APIOptions)
 	}
 
+	err = initSettings(context.TODO(), baseLogger, cfg)
+	if err != nil {
+		baseLogger.Error("unable to load configuration", "error", err)
+		os.Exit(1)
+	}
+
+	awsCfg = cfg
 	ssmClient = ssm.NewFromConfig(cfg)
 }
 
 func handleRequest(ctx context.Context, event json.RawMessage) (Response, error) {
-	clientID := os.Getenv("CLIENT_ID")
-	tenantID := os.Getenv("TENANT_ID")
-	paramName := os.Getenv("CLIENT_SECRET_SSM")
+	recorder := metrics.New("CreateServicePrincipal")
+	defer flushMetrics(ctx, recorder)
 
-	clientSecret, err := getSSMParamValue(ctx, paramName)
+	var evt eventStruct
+	err := json.Unmarshal(event, &evt)
 	if err != nil {
-		log.Println("Error getting SSM parameter:", err)
-		return Response{StatusCode: 500}, err
+		logging.ForInvocation(ctx, baseLogger).Error("Error unmarshalling event", "error", err)
+		return Response{Version: resultVersion, StatusCode: 400}, err
 	}
 
-	var evt eventStruct
-	err = json.Unmarshal(event, &evt)
+	// ARNs and the app name are built in the role's partition, taken from the
+	// role ARN or the event's region, falling back to this function's region
+	awsPartition := partition.Resolve(evt.RoleArn, evt.Region, os.Getenv("AWS_REGION"))
+
+	logger := logging.ForInvocation(ctx, baseLogger,
+		slog.String("executionArn", evt.ExecutionArn),
+		slog.String("eventId", evt.EventID),
+		slog.String("partition", awsPartition),
+		slog.String("account", evt.Account),
+		slog.String("role", evt.RoleName),
+	)
+	recorder.SetDimension("Account", evt.Account)
+
+	doc, err := loadSettings(ctx, logger)
 	if err != nil {
-		log.Println("Error unmarshalling event:", err)
-		return Response{StatusCode: 400}, err
+		logger.Error("Error loading configuration", "error", err)
+		return Response{Version: resultVersion, StatusCode: 500}, err
 	}
+	tokenVersion := doc.Create.AccessTokenVersion
+	placeholder := doc.Create.AudiencePlaceholder
 
-	graphHelper := graphhelper.NewGraphHelper()
+	// Organizations vouches for the account and tells naming templates and
+	// rules about it
+	account, err := enrichAccount(ctx, doc, evt.Account)
+	if errors.Is(err, errAccountRejected) {
+		logger.Warn("Rejecting event from account", "error", err)
+		recorder.Count(metrics.EventsRejected)
+		return Response{
+			Version:    resultVersion,
+			StatusCode: 200,
+			Status:     "skipped",
+			Reason:     err.Error(),
+		}, nil
+	}
+	if err != nil {
+		logger.Error("Error describing account", "error", err)
+		return Response{Version: resultVersion, StatusCode: 500}, err
+	}
+	fields := nameFields(awsPartition, evt.Account, evt.RoleName, account)
+	if account != nil {
+		logger = logger.With("accountName", account.Name)
+	}
+	createdBy := describeCreator(evt.UserIdentity, evt.Creator)
+	if createdBy.Arn != "" {
+		logger = logger.With("creator", createdBy.Arn)
+	}
+
+	if evt.EventName == eventTagRole || evt.EventName == eventUntagRole {
+		return updateFromTags(ctx, logger, recorder, doc, evt, fields, account)
+	}
+
+	role, err := getRole(ctx, logger, evt, awsPartition, doc.Create.CrossAccountRoleName, rules.NeedsRolePath())
+	if err != nil {
+		logger.Error("Error getting role", "error", err)
+		return Response{Version: resultVersion, StatusCode: 500}, err
+	}
+
+	// The role's entra: tags customise its app
+	roleConfig := parseRoleSettings(role.Tags)
+	for _, warning := range roleConfig.Warnings {
+		logger.Warn("Ignoring role tag", "warning", warning)
+	}
+	if roleConfig.TokenVersion != 0 {
+		tokenVersion = roleConfig.TokenVersion
+	}
+
+	// Claim conditions are checked before anything is written to Graph
+	var conditions map[string][]string
+	var bindWarnings []string
+	if doc.Create.BindSubject {
+		conditions, bindWarnings, err = subjectConditions(doc.Create.BindClaims, role.Tags)
+		if err != nil {
+			logger.Error("Error building subject conditions", "error", err)
+			return Response{Version: resultVersion, StatusCode: 400}, err
+		}
+		if len(bindWarnings) > 0 {
+			logger.Warn("Role not fully bound to Entra principals", "warnings", bindWarnings)
+		}
+	}
 
-	err = initializeGraph(graphHelper, clientID, tenantID, clientSecret)
+	ouPath, err := ouPathIfNeeded(ctx, doc, account, evt.Account, tenants.NeedsOUPath() || rules.NeedsOUPath() || doc.Create.TokenLifetime.NeedsOUPath())
 	if err != nil {
-		log.Println("Error initializing graph:", err)
-		return Response{StatusCode: 500}, err
+		logger.Error("Error getting account OU", "error", err)
+		return Response{Version: resultVersion, StatusCode: 500}, err
+	}
+
+	// Guardrails decide whether the role may get an app at all
+	decision := rules.Evaluate(guardrails.Role{
+		Account: evt.Account,
+		OUPath:  ouPath,
+		Name:    evt.RoleName,
+		Path:    role.Path,
+		Tags:    role.Tags,
+
+		AccountName: fields.AccountName,
+		AccountTags: fields.AccountTags,
+	})
+	if !decision.Allowed {
+		logger.Info("Skipping role denied by policy", "policyRule", decision.Rule)
+		recorder.Count(metrics.EventsDenied)
+		return Response{
+			Version:     resultVersion,
+			StatusCode:  200,
+			Status:      "skipped",
+			Reason:      deniedReason(decision),
+			PolicyRule:  decision.Rule,
+			AccountName: fields.AccountName,
+			RoleArn:     role.Arn,
+		}, nil
 	}
 
-	appName := "aws-" + evt.Account + "-" + evt.RoleName
+	if roleConfig.Skip {
+		logger.Info("Skipping role tagged to skip", "tag", skipTag)
+		recorder.Count(metrics.EventsSkipped)
+		return Response{
+			Version:     resultVersion,
+			StatusCode:  200,
+			Status:      "skipped",
+			Reason:      "role is tagged " + skipTag,
+			AccountName: fields.AccountName,
+			RoleArn:     role.Arn,
+			PolicyRule:  decision.Rule,
+		}, nil
+	}
+
+	// The account, its OU or the role's tags select the Entra tenant
+	profile := tenants.Route(evt.Account, ouPath, role.Tags)
+	tenantID := profile.TenantID
+	logger = logger.With("tenant", profile.Name)
+	recorder.SetDimension("Tenant", profile.Name)
 
-	exists, err := graphHelper.CheckAppExists(appName)
+	cloud, err := tenantCloud(doc, profile)
 	if err != nil {
-		log.Println("Error checking if app exists:", err)
-		return Response{StatusCode: 500}, err
+		logger.Error("Error reading Azure cloud", "error", err)
+		return Response{Version: resultVersion, StatusCode: 500}, err
+	}
+	// Without an OIDC URL the provider is expected at the issuer new apps' tokens carry
+	oidcURL := tenantOIDCURL(profile, cloud, tokenVersion)
+
+	// With requireFederation, only roles that federate with the tenant's OIDC
+	// provider get an Entra app
+	providerArn := partition.OIDCProviderARN(awsPartition, evt.Account, oidcURL)
+	if doc.Create.RequireFederation && !isFederatedWithProvider(role.TrustPolicy, providerArn, oidcURL, placeholder) {
+		logger.Info("Skipping role that does not federate with the OIDC provider", "providerArn", providerArn, "placeholder", placeholder)
+		recorder.Count(metrics.EventsSkipped)
+		return Response{
+			Version:     resultVersion,
+			StatusCode:  200,
+			Status:      "skipped",
+			Reason:      "role does not federate with the configured OIDC provider",
+			Tenant:      profile.Name,
+			AccountName: fields.AccountName,
+			RoleArn:     role.Arn,
+			PolicyRule:  decision.Rule,
+		}, nil
 	}
 
-	audience := ""
+	graphHelper, err := graphHelperFor(ctx, logger, recorder, doc, profile, cloud)
+	if err != nil {
+		logger.Error("Error initializing graph", "error", err)
+		return Response{Version: resultVersion, StatusCode: 500}, err
+	}
+
+	appName := doc.Naming.FormatAppName(fields)
+
+	exists, err := graphHelper.CheckAppExists(ctx, appName)
+	if err != nil {
+		logger.Error("Error checking if app exists", "error", err)
+		return Response{Version: resultVersion, StatusCode: 500}, err
+	}
+
+	// In a dry run every lookup still happens, but Graph writes are only planned
+	dryRun := evt.DryRun || doc.DryRun
+
+	api := exposedAPI(doc.Create.API, roleConfig)
+	spec := graphhelper.AppSpec{
+		Name:         appName,
+		TokenVersion: tokenVersion,
+		Notes:        doc.Naming.FormatNotes(fields),
+		Tags:         doc.Naming.FormatTags(fields),
+		API:          api,
+		Hardening: graphhelper.Hardening{
+			Enabled:               doc.Create.Hardening.Enabled,
+			AppManagementPolicyID: doc.Create.Hardening.AppManagementPolicyID,
+		},
+	}
+
+	var state *appState
 	if !exists {
-		audience, err = createApp(graphHelper, appName)
+		state, err = createApp(ctx, logger, graphHelper, doc.Naming, spec, dryRun)
 		if err != nil {
-			log.Println("Error creating app:", err)
-			return Response{StatusCode: 500}, err
+			logger.Error("Error creating app", "error", err)
+			return Response{Version: resultVersion, StatusCode: 500}, err
 		}
 	}
 
 	if exists {
-		appID, err := graphHelper.GetApp(appName)
+		state, err = reuseApp(ctx, logger, graphHelper, doc.Naming, spec, dryRun)
 		if err != nil {
-			log.Println("Error getting app:", err)
-			return Response{StatusCode: 500}, err
+			logger.Error("Error reusing app", "error", err)
+			return Response{Version: resultVersion, StatusCode: 500}, err
 		}
-		audience = appID
+		// Client apps no longer configured are removed, so this runs without any
+		reconcilePreAuthorized(ctx, logger, graphHelper, state, api, dryRun)
 	}
 
-	if audience == "" {
-		log.Println("Error creating app. Audience is empty.")
-		return Response{StatusCode: 500}, nil
+	logger = logger.With("appId", state.AppID)
+
+	// Tags are applied in a dry run too, and only the writes are planned
+	state.Warnings = append(state.Warnings, roleConfig.Warnings...)
+	state.Warnings = append(state.Warnings, bindWarnings...)
+	if roleConfig.TokenVersion != 0 && roleConfig.TokenVersion != state.TokenVersion {
+		state.Warnings = append(state.Warnings, tokenVersionTag+" only applies when the app is created")
 	}
+	applyRoleSettings(ctx, logger, graphHelper, state, roleConfig, roleConfig.presentTags(), dryRun)
 
-	return Response{
-			StatusCode: 200,
-			Headers:    map[string]string{"Content-Type": "application/json"},
-			Audience:   audience,
-		},
-		nil
-}
+	owners, err := chooseOwners(ctx, logger, graphHelper, doc.Create.Owners, createdBy, role.Tags)
+	if err != nil {
+		logger.Warn("Failed to choose app owners", "error", err)
+		state.Warnings = append(state.Warnings, fmt.Sprintf("failed to choose owners: %v", err))
+	}
+	switch {
+	case len(owners) == 0 && err == nil && doc.Create.Owners.Assign:
+		logger.Warn("No owner found for app", "creatorType", createdBy.Kind)
+		recorder.Count(metrics.OwnersUnmapped)
+	case len(owners) > 0 && owners[0].Source == ownerFromFallbackGroup:
+		recorder.Count(metrics.OwnersFallback)
+	}
+	assignOwners(ctx, logger, graphHelper, state, owners, dryRun)
 
-func initializeGraph(graphHelper *graphhelper.GraphHelper, clientID, tenantID, clientSecret string) error {
-	err := graphHelper.InitializeGraphForAppAuth(clientID, tenantID, clientSecret)
+	var access *accessState
+	if accessManaged(doc.Create.Access, roleConfig) {
+		access, err = reconcileAccess(ctx, logger, graphHelper, recorder, state, doc.Create.Access, roleConfig, dryRun)
+		if err != nil {
+			logger.Error("Error reconciling app access", "error", err)
+			return Response{Version: resultVersion, StatusCode: 500}, err
+		}
+	}
+
+	var tokenLifetimePolicyID string
+	if lifetime := doc.Create.TokenLifetime; len(lifetime.Policies) > 0 {
+		policy := chooseTokenLifetime(lifetime, evt.Account, ouPath, role.Tags, state)
+		tokenLifetimePolicyID = reconcileTokenLifetime(ctx, logger, graphHelper, state, lifetime, policy, dryRun)
+	}
+
+	var securityAttributes map[string]string
+	if state.Action == actionCreated && len(doc.Create.SecurityAttributes.Attributes) > 0 {
+		securityAttributes = setSecurityAttributes(ctx, logger, graphHelper, state, doc.Create.SecurityAttributes, fields, role.Tags, dryRun)
+	}
+
+	// A new app's ID is only known once it is created
+	appID := state.AppID
+	if appID == "" {
+		appID = plannedAppID
+	}
+	result := Response{
+		Version:               resultVersion,
+		StatusCode:            200,
+		Status:                "success",
+		Action:                state.Action,
+		AppID:                 state.AppID,
+		ApplicationObjectID:   state.ObjectID,
+		ServicePrincipalID:    state.ServicePrincipalID,
+		IdentifierURIs:        state.IdentifierURIs,
+		Audience:              graphhelper.Audience(appID, doc.Naming.FormatIdentifierURI(appID), state.TokenVersion),
+		TenantID:              tenantID,
+		Issuer:                cloud.Issuer(tenantID, state.TokenVersion),
+		OIDCURL:               tenantOIDCURL(profile, cloud, state.TokenVersion),
+		Tenant:                profile.Name,
+		AccountName:           fields.AccountName,
+		RoleArn:               role.Arn,
+		PolicyRule:            decision.Rule,
+		Creator:               createdBy.Arn,
+		CreatorType:           createdBy.Kind,
+		Owners:                owners,
+		Access:                access,
+		PreAuthorizedApps:     api.PreAuthorizedAppIDs,
+		TokenLifetimePolicyID: tokenLifetimePolicyID,
+		SecurityAttributes:    securityAttributes,
+		Conditions:            conditions,
+	}
+
+	// The Assign Role to Audience step writes the audience and claim conditions
+	// into the role's trust policy
+	result.TrustPolicy, err = updateTrustPolicy(role.TrustPolicy, partition.OIDCProviderARN(awsPartition, evt.Account, result.OIDCURL), result.OIDCURL, result.Audience, conditions)
 	if err != nil {
-		log.Println("Error initializing Graph for app auth: ", err)
-		return err
+		logger.Error("Error updating trust policy", "error", err)
+		return Response{Version: resultVersion, StatusCode: 500}, err
 	}
-	return nil
+	if result.TrustPolicy == nil {
+		logger.Warn("No statement in the trust policy conditions the audience", "oidcUrl", result.OIDCURL)
+		state.Warnings = append(state.Warnings, fmt.Sprintf("no statement federating with %s conditions the audience, so the trust policy can't be updated", result.OIDCURL))
+	}
+	result.Warnings = state.Warnings
+
+	if dryRun {
+		logger.Info("Dry run planned Graph operations", "appName", appName, "operations", len(state.Plan))
+		result.Status = "planned"
+		result.DryRun = true
+		result.Plan = state.Plan
+		return result, nil
+	}
+
+	logger.Info("Create step finished", "action", state.Action, "servicePrincipalId", state.ServicePrincipalID)
+	recorder.Count(actionMetrics[state.Action])
+	return result, nil
 }
 
-func createApp(graphHelper *graphhelper.GraphHelper, name string) (string, error) {
-	// Create both app registration and service principal
-	appID, servicePrincipalID, err := graphHelper.CreateAppWithServicePrincipal(name)
-	if err != nil {
-		log.Println("Error creating app with service principal: ", err)
-		return "", err
+// deniedReason explains a guardrail denial in the result
+func deniedReason(decision guardrails.Decision) string {
+	if decision.PathUnknown {
+		return "role denied because its path is unknown and policy rules match on rolePath"
 	}
+	if decision.Rule == "" {
+		return "role denied by the default policy action"
+	}
+	return fmt.Sprintf("role denied by policy rule %q", decision.Rule)
+}
 
-	applicationIdUri := fmt.Sprintf("api://%s", appID)
+// flushMetrics writes the invocation's metrics. A failure only loses metrics, so
+// it is logged rather than failing the invocation.
+func flushMetrics(ctx context.Context, recorder *metrics.Recorder) {
+	if err := recorder.Flush(); err != nil {
+		logging.ForInvocation(ctx, baseLogger).Warn("Error writing metrics", "error", err)
+	}
+}
 
-	err = graphHelper.SetApplicationIdUri(appID, applicationIdUri)
-	if err != nil {
-		log.Printf("Failed to set Application ID URI: %v", err)
+func initializeGraph(logger *slog.Logger, graphHelper *graphhelper.GraphHelper, clientID, tenantID, clientSecret, requestTimeout string) error {
+	// requestTimeout bounds each Graph request attempt, e.g. "10s"
+	if requestTimeout != "" {
+		timeout, err := time.ParseDuration(requestTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid graph.requestTimeout %q: %w", requestTimeout, err)
+		}
+		graphHelper.SetRequestTimeout(timeout)
 	}
 
-	log.Printf("Created app with ID: %s and service principal ID: %s", appID, servicePrincipalID)
-	return appID, nil
+	err := graphHelper.InitializeGraphForAppAuth(clientID, tenantID, clientSecret)
+	if err != nil {
+		logger.Error("Error initializing Graph for app auth", "error", err)
+		return err
+	}
+	return nil
 }
 
-func getSSMParamValue(ctx context.Context, name string) (string, error) {
+func getSSMParamValue(ctx context.Context, logger *slog.Logger, name string) (string, error) {
 	withDecryption := true
 	resp, err := ssmClient.GetParameter(ctx, &ssm.GetParameterInput{
 		Name:           &name,
 		WithDecryption: &withDecryption,
 	})
 	if err != nil {
-		log.Println("Error getting parameter:", err)
+		logger.Error("Error getting parameter", "error", err)
 		return "", err
 	}
 	if resp == nil || resp.Parameter == nil {
-		log.Println("Parameter not found")
+		logger.Error("Parameter not found", "parameter", name)
 		return "", errors.New("parameter not found")
 	}
 	return *resp.Parameter.Value, nil
 }
 
 func main() {
-	lambda.Start(handleRequest)
+	lambda.Start(tracing.Wrap("CreateServicePrincipal", tracerProvider, handleRequest))
 }
@@ -0,0 +1,254 @@
+// Package metrics records CloudWatch metrics in the Embedded Metric Format (EMF).
+// Records are written to stdout with the logs, and CloudWatch Logs extracts the
+// metrics from them, so recording a metric never makes an API call.
+package metrics
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultNamespace is used when METRICS_NAMESPACE is not set
+const DefaultNamespace = "OIDCAutomation"
+
+// Metric names recorded by ObserveGraphRequest
+const (
+	GraphRequests = "GraphRequests"
+	GraphLatency  = "GraphLatency"
+	GraphRetries  = "GraphRetries"
+)
+
+// Metric names of the create step
+const (
+	SSMLatency     = "SSMLatency"
+	AppsCreated    = "AppsCreated"
+	AppsReused     = "AppsReused"
+	AppsRepaired   = "AppsRepaired"
+	AppsUpdated    = "AppsUpdated"
+	EventsSkipped  = "EventsSkipped"
+	EventsDenied   = "EventsDenied"
+	EventsRejected = "EventsRejected"
+	OwnersFallback = "OwnersFallback"
+	OwnersUnmapped = "OwnersUnmapped"
+
+	AssignmentsAdded   = "AssignmentsAdded"
+	AssignmentsRemoved = "AssignmentsRemoved"
+)
+
+// Unit is a CloudWatch metric unit
+type Unit string
+
+const (
+	Count        Unit = "Count"
+	Milliseconds Unit = "Milliseconds"
+)
+
+// Dimension is a CloudWatch metric dimension
+type Dimension struct {
+	Name  string
+	Value string
+}
+
+// Recorder buffers the metrics of one invocation until Flush is called.
+//
+// Every metric carries the Service dimension plus the dimensions configured in
+// METRICS_DIMENSIONS, a comma separated list. Entries of the form Name=value are
+// static, for example Environment=prod. Bare names, such as Account, are filled
+// in per invocation with SetDimension and left out when no value is set.
+type Recorder struct {
+	mu         sync.Mutex
+	out        io.Writer
+	namespace  string
+	service    string
+	configured []string
+	values     map[string]string
+	records    []*record
+}
+
+// record holds the values of metrics that share the same dimensions
+type record struct {
+	dimensions []Dimension
+	names      []string
+	units      map[string]Unit
+	values     map[string][]float64
+}
+
+// New returns a Recorder for service configured from METRICS_NAMESPACE and
+// METRICS_DIMENSIONS.
+func New(service string) *Recorder {
+	r := &Recorder{
+		out:       os.Stdout,
+		namespace: os.Getenv("METRICS_NAMESPACE"),
+		service:   service,
+		values:    map[string]string{},
+	}
+	if r.namespace == "" {
+		r.namespace = DefaultNamespace
+	}
+
+	for _, entry := range strings.Split(os.Getenv("METRICS_DIMENSIONS"), ",") {
+		name, value, static := strings.Cut(strings.TrimSpace(entry), "=")
+		name = strings.TrimSpace(name)
+		if name == "" || name == "Service" {
+			continue
+		}
+		r.configured = append(r.configured, name)
+		if static {
+			r.values[name] = strings.TrimSpace(value)
+		}
+	}
+
+	return r
+}
+
+// SetDimension sets the value of a configured dimension for the rest of the
+// invocation. Dimensions that are not listed in METRICS_DIMENSIONS are ignored,
+// which keeps metric cardinality under the operator's control.
+func (r *Recorder) SetDimension(name, value string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, configured := range r.configured {
+		if configured == name {
+			r.values[name] = value
+			return
+		}
+	}
+}
+
+// Count adds one to the named counter
+func (r *Recorder) Count(name string, dimensions ...Dimension) {
+	r.Add(name, Count, 1, dimensions...)
+}
+
+// Duration records a latency in milliseconds
+func (r *Recorder) Duration(name string, d time.Duration, dimensions ...Dimension) {
+	r.Add(name, Milliseconds, float64(d.Microseconds())/1000, dimensions...)
+}
+
+// Add records a value for the named metric. The dimensions are added to the
+// Service and configured dimensions.
+func (r *Recorder) Add(name string, unit Unit, value float64, dimensions ...Dimension) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	dims := append(r.baseDimensions(), dimensions...)
+	rec := r.record(dims)
+	if _, ok := rec.units[name]; !ok {
+		rec.names = append(rec.names, name)
+		rec.units[name] = unit
+	}
+	rec.values[name] = append(rec.values[name], value)
+}
+
+// ObserveGraphRequest records the count and latency of a Graph request by
+// operation and status code. A statusCode of 0 means no response was received.
+func (r *Recorder) ObserveGraphRequest(operation string, statusCode int, duration time.Duration, retry bool) {
+	status := "Error"
+	if statusCode > 0 {
+		status = strconv.Itoa(statusCode)
+	}
+
+	dims := []Dimension{{Name: "Operation", Value: operation}, {Name: "StatusCode", Value: status}}
+	r.Count(GraphRequests, dims...)
+	r.Duration(GraphLatency, duration, dims...)
+	if retry {
+		r.Count(GraphRetries, Dimension{Name: "Operation", Value: operation})
+	}
+}
+
+// Flush writes one EMF record per dimension set and resets the Recorder
+func (r *Recorder) Flush() error {
+	r.mu.Lock()
+	records := r.records
+	r.records = nil
+	r.mu.Unlock()
+
+	timestamp := time.Now().UnixMilli()
+	for _, rec := range records {
+		line, err := json.Marshal(rec.document(r.namespace, timestamp))
+		if err != nil {
+			return err
+		}
+		if _, err := r.out.Write(append(line, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Recorder) baseDimensions() []Dimension {
+	dims := []Dimension{{Name: "Service", Value: r.service}}
+	for _, name := range r.configured {
+		if value := r.values[name]; value != "" {
+			dims = append(dims, Dimension{Name: name, Value: value})
+		}
+	}
+	return dims
+}
+
+func (r *Recorder) record(dims []Dimension) *record {
+	for _, rec := range r.records {
+		if sameDimensions(rec.dimensions, dims) {
+			return rec
+		}
+	}
+	rec := &record{
+		dimensions: dims,
+		units:      map[string]Unit{},
+		values:     map[string][]float64{},
+	}
+	r.records = append(r.records, rec)
+	return rec
+}
+
+func (rec *record) document(namespace string, timestamp int64) map[string]any {
+	type metricDefinition struct {
+		Name string `json:"Name"`
+		Unit Unit   `json:"Unit"`
+	}
+
+	doc := map[string]any{}
+	names := make([]string, 0, len(rec.dimensions))
+	for _, dim := range rec.dimensions {
+		names = append(names, dim.Name)
+		doc[dim.Name] = dim.Value
+	}
+
+	definitions := make([]metricDefinition, 0, len(rec.names))
+	for _, name := range rec.names {
+		definitions = append(definitions, metricDefinition{Name: name, Unit: rec.units[name]})
+		if values := rec.values[name]; len(values) == 1 {
+			doc[name] = values[0]
+		} else {
+			doc[name] = values
+		}
+	}
+
+	doc["_aws"] = map[string]any{
+		"Timestamp": timestamp,
+		"CloudWatchMetrics": []map[string]any{{
+			"Namespace":  namespace,
+			"Dimensions": [][]string{names},
+			"Metrics":    definitions,
+		}},
+	}
+	return doc
+}
+
+func sameDimensions(a, b []Dimension) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
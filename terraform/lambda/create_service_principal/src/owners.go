@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/create_service_principal/src/config"
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/create_service_principal/src/graphhelper"
+)
+
+// Sources of an owner reported in the create result
+const (
+	ownerFromRoleTag       = "roleTag"
+	ownerFromCreator       = "creator"
+	ownerFromFallbackGroup = "fallbackGroup"
+)
+
+// owner is a user made an owner of the app, and how it was chosen
+type owner struct {
+	ID                string `json:"id"`
+	UserPrincipalName string `json:"userPrincipalName,omitempty"`
+	Source            string `json:"source"`
+}
+
+// chooseOwners maps the role to the Entra users that should own its app: the
+// users named in the owners role tag, else the user the role's creator signed
+// in as, else the members of the fallback group. Anyone who may tag a role
+// could name any user in the tag, so nothing is chosen unless settings.Assign
+// is on. It returns nil when none of them yields a user.
+func chooseOwners(ctx context.Context, logger *slog.Logger, graphHelper *graphhelper.GraphHelper, settings config.Owners, c creator, roleTags map[string]string) ([]owner, error) {
+	if !settings.Assign {
+		return nil, nil
+	}
+	owners, err := taggedOwners(ctx, logger, graphHelper, settings.RoleTag, roleTags)
+	if err != nil || len(owners) > 0 {
+		return owners, err
+	}
+
+	if c.Login != "" {
+		user, err := findOwner(ctx, graphHelper, c.Login)
+		if err != nil {
+			return nil, err
+		}
+		if user != nil {
+			return []owner{{ID: user.ID, UserPrincipalName: user.UserPrincipalName, Source: ownerFromCreator}}, nil
+		}
+		logger.Warn("Creator matches no Entra user", "login", c.Login)
+	}
+
+	if settings.FallbackGroupID == "" {
+		return nil, nil
+	}
+	users, err := graphHelper.GroupUsers(ctx, settings.FallbackGroupID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list members of fallback owner group %s: %w", settings.FallbackGroupID, err)
+	}
+	for _, user := range users {
+		owners = append(owners, owner{ID: user.ID, UserPrincipalName: user.UserPrincipalName, Source: ownerFromFallbackGroup})
+	}
+	return owners, nil
+}
+
+// taggedOwners returns the users named by UPN or email in the owners role tag.
+// Names that match no user are logged and left out.
+func taggedOwners(ctx context.Context, logger *slog.Logger, graphHelper *graphhelper.GraphHelper, tag string, roleTags map[string]string) ([]owner, error) {
+	var owners []owner
+	for _, login := range splitTagValue(roleTags[tag]) {
+		user, err := findOwner(ctx, graphHelper, login)
+		if err != nil {
+			return nil, err
+		}
+		if user == nil {
+			logger.Warn("Owner role tag names no Entra user", "tag", tag, "login", login)
+			continue
+		}
+		owners = append(owners, owner{ID: user.ID, UserPrincipalName: user.UserPrincipalName, Source: ownerFromRoleTag})
+	}
+	return owners, nil
+}
+
+// findOwner returns the user with the UPN or email login, or nil when there
+// is none
+func findOwner(ctx context.Context, graphHelper *graphhelper.GraphHelper, login string) (*graphhelper.User, error) {
+	user, err := graphHelper.FindUser(ctx, login)
+	if errors.Is(err, graphhelper.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up user %s: %w", login, err)
+	}
+	return &user, nil
+}
+
+// assignOwners adds the owners to the app registration and its service
+// principal. Owners only manage the app and tokens are issued without them,
+// so a failure is recorded as a warning; apps left without an owner stay
+// manageable by the directory's administrators.
+func assignOwners(ctx context.Context, logger *slog.Logger, graphHelper *graphhelper.GraphHelper, state *appState, owners []owner, dryRun bool) {
+	for _, o := range owners {
+		if dryRun {
+			state.Plan = append(state.Plan,
+				plannedOperation{Operation: opAddApplicationOwner, Name: o.UserPrincipalName, ID: o.ID},
+				plannedOperation{Operation: opAddServicePrincipalOwner, Name: o.UserPrincipalName, ID: o.ID},
+			)
+			continue
+		}
+
+		if err := graphHelper.AddApplicationOwner(ctx, state.ObjectID, o.ID); err != nil {
+			logger.Warn("Failed to add application owner", "ownerId", o.ID, "error", err)
+			state.Warnings = append(state.Warnings, fmt.Sprintf("failed to add %s as application owner: %v", o.UserPrincipalName, err))
+		}
+		if state.ServicePrincipalID == "" {
+			continue
+		}
+		if err := graphHelper.AddServicePrincipalOwner(ctx, state.ServicePrincipalID, o.ID); err != nil {
+			logger.Warn("Failed to add service principal owner", "ownerId", o.ID, "error", err)
+			state.Warnings = append(state.Warnings, fmt.Sprintf("failed to add %s as service principal owner: %v", o.UserPrincipalName, err))
+		}
+	}
+}
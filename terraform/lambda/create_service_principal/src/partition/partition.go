@@ -0,0 +1,71 @@
+// Package partition builds ARNs and names for the AWS partition a role lives
+// in: aws, aws-us-gov or aws-cn.
+package partition
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+)
+
+// Supported partitions
+const (
+	AWS      = "aws"
+	AWSUSGov = "aws-us-gov"
+	AWSCN    = "aws-cn"
+)
+
+// ForRegion returns the partition of region. Unknown and empty regions are
+// assumed to be in the commercial partition.
+func ForRegion(region string) string {
+	switch {
+	case strings.HasPrefix(region, "us-gov-"):
+		return AWSUSGov
+	case strings.HasPrefix(region, "cn-"):
+		return AWSCN
+	default:
+		return AWS
+	}
+}
+
+// FromARN returns the partition of an ARN
+func FromARN(s string) (string, error) {
+	parsed, err := arn.Parse(s)
+	if err != nil {
+		return "", err
+	}
+	return parsed.Partition, nil
+}
+
+// Resolve returns the partition from the first of resourceArn and the regions
+// that is set, so an event's own ARN wins over its region and the region wins
+// over the function's.
+func Resolve(resourceArn string, regions ...string) string {
+	if resourceArn != "" {
+		if p, err := FromARN(resourceArn); err == nil {
+			return p
+		}
+	}
+	for _, region := range regions {
+		if region != "" {
+			return ForRegion(region)
+		}
+	}
+	return AWS
+}
+
+// OIDCProviderARN returns the ARN of the IAM OIDC provider for oidcURL
+func OIDCProviderARN(partition, account, oidcURL string) string {
+	return fmt.Sprintf("arn:%s:iam::%s:oidc-provider/%s", partition, account, oidcURL)
+}
+
+// RoleARN returns the ARN of a role without a path
+func RoleARN(partition, account, roleName string) string {
+	return fmt.Sprintf("arn:%s:iam::%s:role/%s", partition, account, roleName)
+}
+
+// AssumedRoleARN returns the ARN of a session of an assumed role
+func AssumedRoleARN(partition, account, roleName, sessionName string) string {
+	return fmt.Sprintf("arn:%s:sts::%s:assumed-role/%s/%s", partition, account, roleName, sessionName)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/create_service_principal/src/config"
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/create_service_principal/src/graphhelper"
+)
+
+// exposedAPI returns the scope an app exposes and the client apps
+// pre-authorized for it: those of the document plus those of the role's tag
+func exposedAPI(settings config.API, rs roleSettings) graphhelper.ExposedAPI {
+	api := graphhelper.ExposedAPI{Scope: settings.Scope}
+	for _, appId := range append(slices.Clone(settings.PreAuthorizedApps), rs.PreAuthorizedApps...) {
+		// Graph returns app IDs in lower case
+		appId = strings.ToLower(appId)
+		if !slices.Contains(api.PreAuthorizedAppIDs, appId) {
+			api.PreAuthorizedAppIDs = append(api.PreAuthorizedAppIDs, appId)
+		}
+	}
+	return api
+}
+
+// reconcilePreAuthorized pre-authorizes exactly the api's client apps on an
+// existing app, adding its scope when it is missing. Pre-authorization only
+// spares a client app the consent prompt for the scope; who may obtain tokens
+// is decided by app role assignment, so failures are recorded as warnings.
+func reconcilePreAuthorized(ctx context.Context, logger *slog.Logger, graphHelper *graphhelper.GraphHelper, state *appState, api graphhelper.ExposedAPI, dryRun bool) {
+	current, err := graphHelper.PreAuthorizedApplications(ctx, state.ObjectID)
+	if err != nil {
+		logger.Warn("Failed to read pre-authorized applications", "error", err)
+		state.Warnings = append(state.Warnings, fmt.Sprintf("failed to read pre-authorized applications: %v", err))
+		return
+	}
+
+	for _, appId := range api.PreAuthorizedAppIDs {
+		if slices.Contains(current, appId) {
+			continue
+		}
+		if dryRun {
+			state.Plan = append(state.Plan, plannedOperation{Operation: opAddPreAuthorizedApplication, Name: api.Scope, ID: appId})
+			continue
+		}
+		if err := graphHelper.AddPreAuthorizedApplication(ctx, state.ObjectID, api.Scope, appId); err != nil {
+			logger.Warn("Failed to pre-authorize application", "clientAppId", appId, "error", err)
+			state.Warnings = append(state.Warnings, fmt.Sprintf("failed to pre-authorize %s: %v", appId, err))
+			continue
+		}
+		logger.Info("Pre-authorized application", "clientAppId", appId)
+	}
+
+	for _, appId := range current {
+		if slices.Contains(api.PreAuthorizedAppIDs, appId) {
+			continue
+		}
+		if dryRun {
+			state.Plan = append(state.Plan, plannedOperation{Operation: opRemovePreAuthorizedApplication, ID: appId})
+			continue
+		}
+		if err := graphHelper.RemovePreAuthorizedApplication(ctx, state.ObjectID, appId); err != nil {
+			logger.Warn("Failed to remove pre-authorized application", "clientAppId", appId, "error", err)
+			state.Warnings = append(state.Warnings, fmt.Sprintf("failed to remove pre-authorized %s: %v", appId, err))
+			continue
+		}
+		logger.Info("Removed pre-authorized application", "clientAppId", appId)
+	}
+}
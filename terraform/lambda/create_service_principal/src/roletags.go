@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/create_service_principal/src/graphhelper"
+)
+
+// Role tags that configure the role's app. The owners tag is set by
+// create.owners.roleTag, entra:owners by default.
+const (
+	assignmentRequiredTag = "entra:assignment-required"
+	tokenVersionTag       = "entra:token-version"
+	skipTag               = "entra:skip"
+	descriptionTag        = "entra:description"
+	assigneesTag          = "entra:assignees"
+	preAuthorizedAppsTag  = "entra:preauthorized-apps"
+	tokenLifetimeTag      = "entra:token-lifetime"
+)
+
+// maxDescription is the longest application description Entra ID accepts
+const maxDescription = 1024
+
+// objectID matches an Entra object ID or app ID
+var objectID = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// roleSettings is the provisioning configuration a role carries in its tags
+type roleSettings struct {
+	// AssignmentRequired is nil when the tag is not set
+	AssignmentRequired *bool
+	// TokenVersion is 0 when the tag is not set
+	TokenVersion int32
+	Skip         bool
+	// Description is nil when the tag is not set
+	Description *string
+	// Assignees are the object IDs of the principals assigned to the app
+	Assignees []string
+	// PreAuthorizedApps are the app IDs of the client apps pre-authorized
+	// for the app's scope
+	PreAuthorizedApps []string
+
+	// Warnings explain the tags whose values were ignored
+	Warnings []string
+	invalid  []string
+}
+
+// parseRoleSettings reads the configuration tags of a role. Invalid values
+// are ignored with a warning, so a typo in a tag doesn't keep the role from
+// getting its app.
+func parseRoleSettings(tags map[string]string) roleSettings {
+	var rs roleSettings
+
+	if value, ok := tags[assignmentRequiredTag]; ok {
+		required, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			rs.ignore(assignmentRequiredTag, value, "true or false")
+		} else {
+			rs.AssignmentRequired = &required
+		}
+	}
+
+	if value, ok := tags[tokenVersionTag]; ok {
+		switch strings.TrimSpace(value) {
+		case "1":
+			rs.TokenVersion = graphhelper.AccessTokenV1
+		case "2":
+			rs.TokenVersion = graphhelper.AccessTokenV2
+		default:
+			rs.ignore(tokenVersionTag, value, "1 or 2")
+		}
+	}
+
+	if value, ok := tags[skipTag]; ok {
+		skip, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			rs.ignore(skipTag, value, "true or false")
+		} else {
+			rs.Skip = skip
+		}
+	}
+
+	if value, ok := tags[descriptionTag]; ok {
+		description := strings.TrimSpace(value)
+		if len(description) > maxDescription {
+			description = description[:maxDescription]
+		}
+		rs.Description = &description
+	}
+
+	for _, id := range splitTagValue(tags[assigneesTag]) {
+		if !objectID.MatchString(id) {
+			rs.ignore(assigneesTag, id, "object IDs")
+			continue
+		}
+		rs.Assignees = append(rs.Assignees, strings.ToLower(id))
+	}
+
+	for _, id := range splitTagValue(tags[preAuthorizedAppsTag]) {
+		if !objectID.MatchString(id) {
+			rs.ignore(preAuthorizedAppsTag, id, "app IDs")
+			continue
+		}
+		rs.PreAuthorizedApps = append(rs.PreAuthorizedApps, strings.ToLower(id))
+	}
+
+	return rs
+}
+
+func (rs *roleSettings) ignore(tag, value, expected string) {
+	rs.Warnings = append(rs.Warnings, fmt.Sprintf("ignored role tag %s=%q, expected %s", tag, value, expected))
+	rs.invalid = append(rs.invalid, tag)
+}
+
+// presentTags returns the tags applied to an existing app that the role has
+// set. Access tags are applied by reconcileAccess.
+func (rs roleSettings) presentTags() []string {
+	var tags []string
+	if rs.Description != nil {
+		tags = append(tags, descriptionTag)
+	}
+	return tags
+}
+
+// applyRoleSettings brings the app in line with the role's settings for the
+// given tags. A tag the role no longer has resets its setting. The only
+// setting written here is the app's description, which no token depends on,
+// so failures are recorded as warnings.
+func applyRoleSettings(ctx context.Context, logger *slog.Logger, graphHelper *graphhelper.GraphHelper, state *appState, rs roleSettings, tags []string, dryRun bool) {
+	for _, tag := range tags {
+		if slices.Contains(rs.invalid, tag) {
+			continue
+		}
+
+		switch tag {
+		case descriptionTag:
+			description := ""
+			if rs.Description != nil {
+				description = *rs.Description
+			}
+			if dryRun {
+				state.Plan = append(state.Plan, plannedOperation{Operation: opPatchApplication, Name: tag, ID: state.ObjectID})
+				continue
+			}
+			if err := graphHelper.SetApplicationDescription(ctx, state.ObjectID, description); err != nil {
+				logger.Warn("Failed to set application description", "error", err)
+				state.Warnings = append(state.Warnings, fmt.Sprintf("failed to apply %s: %v", tag, err))
+			}
+
+		case tokenVersionTag, skipTag:
+			state.Warnings = append(state.Warnings, fmt.Sprintf("%s only applies when the app is created", tag))
+		}
+	}
+}
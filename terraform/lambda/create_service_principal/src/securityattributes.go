@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/create_service_principal/src/config"
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/create_service_principal/src/graphhelper"
+)
+
+// setSecurityAttributes sets the configured custom security attributes on a
+// newly created service principal and returns the values set. Reused apps keep
+// the attributes they were created with, which governance tooling may have
+// changed since. The attributes only label the app for governance tooling and
+// nothing in the workflow reads them back, so a failure is recorded as a
+// warning.
+func setSecurityAttributes(ctx context.Context, logger *slog.Logger, graphHelper *graphhelper.GraphHelper, state *appState, settings config.SecurityAttributes, fields config.Fields, roleTags map[string]string, dryRun bool) map[string]string {
+	values := settings.Values(fields, roleTags)
+	if len(values) == 0 {
+		return nil
+	}
+
+	if dryRun {
+		state.Plan = append(state.Plan, plannedOperation{Operation: opSetSecurityAttributes, Name: settings.Set, ID: state.ServicePrincipalID})
+		return values
+	}
+
+	if err := graphHelper.SetSecurityAttributes(ctx, state.ServicePrincipalID, settings.Set, values); err != nil {
+		logger.Warn("Failed to set security attributes", "attributeSet", settings.Set, "error", err)
+		state.Warnings = append(state.Warnings, fmt.Sprintf("failed to set security attributes in %s: %v", settings.Set, err))
+		return nil
+	}
+	logger.Info("Set security attributes", "attributeSet", settings.Set, "attributes", len(values))
+	return values
+}
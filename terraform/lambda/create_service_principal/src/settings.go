@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/create_service_principal/src/config"
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/create_service_principal/src/guardrails"
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/create_service_principal/src/tenant"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+var (
+	configLoader *config.Loader
+	// settings is the current configuration document, tenants and rules the
+	// routing table and provisioning policy built from it
+	settings *config.Document
+	tenants  *tenant.Table
+	rules    *guardrails.Policy
+)
+
+// initSettings loads the configuration document during the init phase, so an
+// invalid document fails the cold start with the validation errors.
+func initSettings(ctx context.Context, logger *slog.Logger, cfg aws.Config) error {
+	loader, err := config.NewLoader(cfg, func(doc *config.Document) error {
+		if _, err := tenantTable(doc); err != nil {
+			return err
+		}
+		_, err := provisioningPolicy(doc)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	configLoader = loader
+
+	_, err = loadSettings(ctx, logger)
+	return err
+}
+
+// loadSettings returns the current configuration document, reloading it once
+// its TTL has passed.
+func loadSettings(ctx context.Context, logger *slog.Logger) (*config.Document, error) {
+	doc, err := configLoader.Get(ctx, logger)
+	if err != nil {
+		return nil, err
+	}
+	if doc != settings {
+		table, err := tenantTable(doc)
+		if err != nil {
+			return nil, err
+		}
+		policy, err := provisioningPolicy(doc)
+		if err != nil {
+			return nil, err
+		}
+		settings, tenants, rules = doc, table, policy
+	}
+	return doc, nil
+}
+
+// tenantTable returns the document's tenant routing table, or a table with the
+// azure section as the only tenant.
+func tenantTable(doc *config.Document) (*tenant.Table, error) {
+	if len(doc.TenantRouting) > 0 {
+		return tenant.Parse(doc.TenantRouting)
+	}
+	return tenant.Single(tenant.Profile{
+		TenantID:        doc.Azure.TenantID,
+		ClientID:        doc.Azure.ClientID,
+		ClientSecretSSM: doc.Azure.ClientSecretSSM,
+		OIDCURL:         doc.Azure.OIDCURL,
+	}), nil
+}
+
+// provisioningPolicy returns the document's policy, or one allowing every role
+func provisioningPolicy(doc *config.Document) (*guardrails.Policy, error) {
+	if len(doc.Policy) == 0 {
+		return guardrails.AllowAll(), nil
+	}
+	policy, err := guardrails.Parse(doc.Policy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy: %w", err)
+	}
+	if policy.NeedsAccount() && !doc.Organizations.Enrich {
+		return nil, errors.New("invalid policy: rules on accountName or accountTags require organizations.enrich")
+	}
+	return policy, nil
+}
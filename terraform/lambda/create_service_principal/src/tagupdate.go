@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/create_service_principal/src/config"
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/create_service_principal/src/graphhelper"
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/create_service_principal/src/metrics"
+)
+
+// Events that change the tags of an existing role
+const (
+	eventTagRole   = "TagRole"
+	eventUntagRole = "UntagRole"
+)
+
+// updateFromTags applies a role's changed configuration tags to its existing
+// app. Tag events don't start the create workflow: the Invoke Step Function
+// Lambda invokes this function directly.
+func updateFromTags(ctx context.Context, logger *slog.Logger, recorder *metrics.Recorder, doc *config.Document, evt eventStruct, fields config.Fields, account *accountInfo) (Response, error) {
+	changed := changedConfigTags(evt, doc.Create.Owners.RoleTag)
+	if len(changed) == 0 {
+		logger.Debug("Ignoring tag change without configuration tags")
+		return Response{
+			Version:    resultVersion,
+			StatusCode: 200,
+			Status:     "skipped",
+			Reason:     "no configuration tags changed",
+		}, nil
+	}
+	logger = logger.With("tags", changed)
+
+	tags, err := currentRoleTags(ctx, logger, evt, fields.Partition, doc.Create.CrossAccountRoleName)
+	if err != nil {
+		logger.Error("Error getting role tags", "error", err)
+		return Response{Version: resultVersion, StatusCode: 500}, err
+	}
+
+	ouPath, err := ouPathIfNeeded(ctx, doc, account, evt.Account, tenants.NeedsOUPath() || doc.Create.TokenLifetime.NeedsOUPath())
+	if err != nil {
+		logger.Error("Error getting account OU", "error", err)
+		return Response{Version: resultVersion, StatusCode: 500}, err
+	}
+
+	profile := tenants.Route(evt.Account, ouPath, tags)
+	logger = logger.With("tenant", profile.Name)
+	recorder.SetDimension("Tenant", profile.Name)
+
+	cloud, err := tenantCloud(doc, profile)
+	if err != nil {
+		logger.Error("Error reading Azure cloud", "error", err)
+		return Response{Version: resultVersion, StatusCode: 500}, err
+	}
+
+	graphHelper, err := graphHelperFor(ctx, logger, recorder, doc, profile, cloud)
+	if err != nil {
+		logger.Error("Error initializing graph", "error", err)
+		return Response{Version: resultVersion, StatusCode: 500}, err
+	}
+
+	appName := doc.Naming.FormatAppName(fields)
+	app, err := graphHelper.GetApplication(ctx, appName)
+	if errors.Is(err, graphhelper.ErrNotFound) {
+		logger.Info("Skipping tag change of role without an app", "appName", appName)
+		return Response{
+			Version:     resultVersion,
+			StatusCode:  200,
+			Status:      "skipped",
+			Reason:      "role has no app",
+			Tenant:      profile.Name,
+			AccountName: fields.AccountName,
+		}, nil
+	}
+	if err != nil {
+		logger.Error("Error getting app", "error", err)
+		return Response{Version: resultVersion, StatusCode: 500}, err
+	}
+
+	state := stateFromApp(app)
+	state.TokenVersion = graphhelper.TokenVersion(app)
+	state.Action = actionUpdated
+	logger = logger.With("appId", state.AppID)
+
+	sp, err := graphHelper.GetServicePrincipalByAppId(ctx, state.AppID)
+	switch {
+	case errors.Is(err, graphhelper.ErrNotFound):
+		logger.Warn("App has no service principal", "appId", state.AppID)
+		state.Warnings = append(state.Warnings, "app has no service principal; it is recreated on the next CreateRole")
+	case err != nil:
+		logger.Error("Error getting service principal", "error", err)
+		return Response{Version: resultVersion, StatusCode: 500}, err
+	case sp.GetId() != nil:
+		state.ServicePrincipalID = *sp.GetId()
+	}
+
+	rs := parseRoleSettings(tags)
+	state.Warnings = append(state.Warnings, rs.Warnings...)
+
+	dryRun := evt.DryRun || doc.DryRun
+	applyRoleSettings(ctx, logger, graphHelper, state, rs, changed, dryRun)
+
+	// Owners are only added, and only when owner assignment is on; owners
+	// removed from the tag keep the app until they are removed in Entra ID
+	var owners []owner
+	if doc.Create.Owners.Assign && slices.Contains(changed, doc.Create.Owners.RoleTag) {
+		owners, err = taggedOwners(ctx, logger, graphHelper, doc.Create.Owners.RoleTag, tags)
+		if err != nil {
+			logger.Warn("Failed to look up tagged owners", "error", err)
+			state.Warnings = append(state.Warnings, "failed to look up owners: "+err.Error())
+		}
+		assignOwners(ctx, logger, graphHelper, state, owners, dryRun)
+	}
+
+	// Removing the tag falls back to the document's client apps
+	var api graphhelper.ExposedAPI
+	if slices.Contains(changed, preAuthorizedAppsTag) {
+		api = exposedAPI(doc.Create.API, rs)
+		reconcilePreAuthorized(ctx, logger, graphHelper, state, api, dryRun)
+	}
+
+	// Removing the tag falls back to the policy the routes select
+	var tokenLifetimePolicyID string
+	if lifetime := doc.Create.TokenLifetime; slices.Contains(changed, tokenLifetimeTag) && len(lifetime.Policies) > 0 {
+		policy := chooseTokenLifetime(lifetime, evt.Account, ouPath, tags, state)
+		tokenLifetimePolicyID = reconcileTokenLifetime(ctx, logger, graphHelper, state, lifetime, policy, dryRun)
+	}
+
+	// Removing an access tag falls back to the document's access settings
+	var access *accessState
+	if slices.Contains(changed, assignmentRequiredTag) || slices.Contains(changed, assigneesTag) {
+		access, err = reconcileAccess(ctx, logger, graphHelper, recorder, state, doc.Create.Access, rs, dryRun)
+		if err != nil {
+			logger.Error("Error reconciling app access", "error", err)
+			return Response{Version: resultVersion, StatusCode: 500}, err
+		}
+	}
+
+	result := Response{
+		Version:               resultVersion,
+		StatusCode:            200,
+		Status:                "success",
+		Action:                state.Action,
+		AppID:                 state.AppID,
+		ApplicationObjectID:   state.ObjectID,
+		ServicePrincipalID:    state.ServicePrincipalID,
+		TenantID:              profile.TenantID,
+		Tenant:                profile.Name,
+		AccountName:           fields.AccountName,
+		Owners:                owners,
+		Access:                access,
+		PreAuthorizedApps:     api.PreAuthorizedAppIDs,
+		TokenLifetimePolicyID: tokenLifetimePolicyID,
+		Warnings:              state.Warnings,
+	}
+	if dryRun {
+		logger.Info("Dry run planned Graph operations", "appName", appName, "operations", len(state.Plan))
+		result.Status = "planned"
+		result.DryRun = true
+		result.Plan = state.Plan
+		return result, nil
+	}
+
+	logger.Info("Applied role tags to app")
+	recorder.Count(metrics.AppsUpdated)
+	return result, nil
+}
+
+// changedConfigTags returns the configuration tags a TagRole or UntagRole
+// event sets or removes
+func changedConfigTags(evt eventStruct, ownersTag string) []string {
+	keys := slices.Clone(evt.TagKeys)
+	for _, tag := range evt.Tags {
+		keys = append(keys, tag.Key)
+	}
+
+	configTags := []string{ownersTag, assignmentRequiredTag, tokenVersionTag, skipTag, descriptionTag, assigneesTag, preAuthorizedAppsTag, tokenLifetimeTag}
+	var changed []string
+	for _, key := range keys {
+		if slices.Contains(configTags, key) && !slices.Contains(changed, key) {
+			changed = append(changed, key)
+		}
+	}
+	return changed
+}
+
+// currentRoleTags returns all of the role's tags, read cross-account when
+// create.crossAccountRoleName is set. Otherwise only the tags the event sets
+// are known, which is enough to apply them but not to route by other tags.
+func currentRoleTags(ctx context.Context, logger *slog.Logger, evt eventStruct, awsPartition, crossAccountRoleName string) (map[string]string, error) {
+	if crossAccountRoleName != "" {
+		role, err := getRole(ctx, logger, evt, awsPartition, crossAccountRoleName, false)
+		if err != nil {
+			return nil, err
+		}
+		return role.Tags, nil
+	}
+
+	tags := map[string]string{}
+	for _, tag := range evt.Tags {
+		tags[tag.Key] = tag.Value
+	}
+	return tags, nil
+}
@@ -0,0 +1,194 @@
+// Package tenant routes AWS accounts and roles to the Entra tenant that serves
+// them.
+//
+// The routing table is the tenantRouting section of the configuration document:
+//
+//	{
+//	  "default": "corporate",
+//	  "tenants": {
+//	    "corporate": {"tenantId": "...", "clientId": "...", "clientSecretSsm": "/oidc/corporate"},
+//	    "regulated": {"tenantId": "...", "clientId": "...", "clientSecretSsm": "/oidc/regulated", "oidcUrl": "..."}
+//	  },
+//	  "routes": [
+//	    {"tenant": "regulated", "accounts": ["111111111111"]},
+//	    {"tenant": "regulated", "ouPaths": ["o-a1b2c3d4e5/r-ab12/ou-ab12-11111111/"]},
+//	    {"tenant": "regulated", "tags": {"entra:tenant": "regulated"}}
+//	  ]
+//	}
+//
+// Without it, the document's azure section is the single tenant, named "default".
+package tenant
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// DefaultName names the tenant of a Single table
+const DefaultName = "default"
+
+// Profile is an Entra tenant and the app registration used to manage it
+type Profile struct {
+	Name            string `json:"-"`
+	TenantID        string `json:"tenantId"`
+	ClientID        string `json:"clientId"`
+	ClientSecretSSM string `json:"clientSecretSsm"`
+	// OIDCURL is the IAM OIDC provider URL for the tenant. When empty it is
+	// derived from the cloud, tenant and access token version.
+	OIDCURL string `json:"oidcUrl,omitempty"`
+	// Cloud is the Microsoft cloud of the tenant, the document's azure.cloud when empty
+	Cloud string `json:"cloud,omitempty"`
+}
+
+// Selector picks roles. It matches when the account is listed, the account is
+// in one of the OUs (or an OU below them), or the role carries all of the tags.
+type Selector struct {
+	Accounts []string          `json:"accounts,omitempty"`
+	OUPaths  []string          `json:"ouPaths,omitempty"`
+	Tags     map[string]string `json:"tags,omitempty"`
+}
+
+// Route sends the roles its selector matches to a tenant. Routes are tried in
+// order.
+type Route struct {
+	Tenant string `json:"tenant"`
+	Selector
+}
+
+// Table is the tenant routing table
+type Table struct {
+	Default string             `json:"default"`
+	Tenants map[string]Profile `json:"tenants"`
+	Routes  []Route            `json:"routes,omitempty"`
+}
+
+// Single returns a table in which one tenant serves every role
+func Single(profile Profile) *Table {
+	return &Table{
+		Default: DefaultName,
+		Tenants: map[string]Profile{DefaultName: profile},
+	}
+}
+
+// Parse parses and validates a JSON routing table
+func Parse(data []byte) (*Table, error) {
+	var table Table
+	if err := json.Unmarshal(data, &table); err != nil {
+		return nil, err
+	}
+	if err := table.validate(); err != nil {
+		return nil, err
+	}
+	return &table, nil
+}
+
+func (t *Table) validate() error {
+	var errs []error
+	for name, profile := range t.Tenants {
+		if profile.TenantID == "" || profile.ClientID == "" || profile.ClientSecretSSM == "" {
+			errs = append(errs, fmt.Errorf("tenant %q needs tenantId, clientId and clientSecretSsm", name))
+		}
+	}
+	if _, ok := t.Tenants[t.Default]; !ok {
+		errs = append(errs, fmt.Errorf("default tenant %q is not defined", t.Default))
+	}
+	for i, route := range t.Routes {
+		if _, ok := t.Tenants[route.Tenant]; !ok {
+			errs = append(errs, fmt.Errorf("route %d: tenant %q is not defined", i, route.Tenant))
+		}
+		if len(route.Accounts) == 0 && len(route.OUPaths) == 0 && len(route.Tags) == 0 {
+			errs = append(errs, fmt.Errorf("route %d: needs accounts, ouPaths or tags", i))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// NeedsOUPath reports whether any route matches on OU paths, so callers only
+// look up an account's OU when it matters.
+func (t *Table) NeedsOUPath() bool {
+	for _, route := range t.Routes {
+		if len(route.OUPaths) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Route returns the tenant for a role: the tenant of the first matching route,
+// or the default tenant.
+func (t *Table) Route(account, ouPath string, tags map[string]string) Profile {
+	for _, route := range t.Routes {
+		if route.Matches(account, ouPath, tags) {
+			return t.profile(route.Tenant)
+		}
+	}
+	return t.profile(t.Default)
+}
+
+// Candidates returns the tenants that may hold the app of a role whose tags are
+// no longer known, such as a deleted role: the tenant routed by account and OU
+// first, then the tenants of tag routes.
+func (t *Table) Candidates(account, ouPath string) []Profile {
+	first := t.Default
+	for _, route := range t.Routes {
+		if route.MatchesAccount(account, ouPath) {
+			first = route.Tenant
+			break
+		}
+	}
+
+	names := []string{first}
+	for _, route := range t.Routes {
+		if len(route.Tags) > 0 && !slices.Contains(names, route.Tenant) {
+			names = append(names, route.Tenant)
+		}
+	}
+
+	profiles := make([]Profile, 0, len(names))
+	for _, name := range names {
+		profiles = append(profiles, t.profile(name))
+	}
+	return profiles
+}
+
+func (t *Table) profile(name string) Profile {
+	profile := t.Tenants[name]
+	profile.Name = name
+	return profile
+}
+
+// Matches reports whether the selector matches the role by account, OU or tags
+func (s Selector) Matches(account, ouPath string, tags map[string]string) bool {
+	return s.MatchesAccount(account, ouPath) || s.matchesTags(tags)
+}
+
+// MatchesAccount reports whether the selector matches the account by ID or OU
+func (s Selector) MatchesAccount(account, ouPath string) bool {
+	if slices.Contains(s.Accounts, account) {
+		return true
+	}
+	if ouPath == "" {
+		return false
+	}
+	for _, path := range s.OUPaths {
+		if strings.HasPrefix(ouPath, strings.TrimSuffix(path, "/")+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+func (s Selector) matchesTags(tags map[string]string) bool {
+	if len(s.Tags) == 0 {
+		return false
+	}
+	for key, value := range s.Tags {
+		if tagValue, ok := tags[key]; !ok || tagValue != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,133 @@
+// Package tracing sets up OpenTelemetry tracing for the Lambda handlers.
+//
+// TRACES_EXPORTER selects where spans are sent:
+//   - none (default): tracing is disabled
+//   - otlp: OTLP/HTTP to OTEL_EXPORTER_OTLP_ENDPOINT
+//   - xray: OTLP/HTTP to the collector of the ADOT Lambda layer, which exports to X-Ray
+//
+// Trace IDs are always X-Ray compatible and each invocation continues the trace
+// Lambda received, so the spans join the Step Functions execution's trace.
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"go.opentelemetry.io/contrib/propagators/aws/xray"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.34.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Exporters accepted in TRACES_EXPORTER
+const (
+	ExporterNone = "none"
+	ExporterOTLP = "otlp"
+	ExporterXRay = "xray"
+)
+
+// adotCollectorEndpoint is the OTLP/HTTP receiver of the ADOT Lambda layer
+const adotCollectorEndpoint = "localhost:4318"
+
+// traceHeader is the header the X-Ray propagator reads the parent trace from
+const traceHeader = "X-Amzn-Trace-Id"
+
+// Init installs the global tracer provider and propagator for serviceName. It
+// returns a nil provider when tracing is disabled.
+func Init(ctx context.Context, serviceName string) (*sdktrace.TracerProvider, error) {
+	var opts []otlptracehttp.Option
+	switch exporter := os.Getenv("TRACES_EXPORTER"); exporter {
+	case "", ExporterNone:
+		return nil, nil
+	case ExporterOTLP:
+	case ExporterXRay:
+		if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(adotCollectorEndpoint), otlptracehttp.WithInsecure())
+		}
+	default:
+		return nil, fmt.Errorf("unsupported TRACES_EXPORTER %q", exporter)
+	}
+
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(serviceName),
+		semconv.CloudProviderAWS,
+		semconv.CloudPlatformAWSLambda,
+		semconv.CloudRegion(os.Getenv("AWS_REGION")),
+		semconv.FaaSName(os.Getenv("AWS_LAMBDA_FUNCTION_NAME")),
+		semconv.FaaSVersion(os.Getenv("AWS_LAMBDA_FUNCTION_VERSION")),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithIDGenerator(xray.NewIDGenerator()),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(xray.Propagator{}, propagation.TraceContext{}))
+
+	return provider, nil
+}
+
+// Wrap runs handler inside a span for the invocation, continuing the trace from
+// the X-Ray trace header Lambda passes in. Spans are flushed before returning,
+// since Lambda may freeze the environment as soon as the handler returns. The
+// handler is returned unchanged when provider is nil.
+func Wrap[T any](name string, provider *sdktrace.TracerProvider, handler func(context.Context, json.RawMessage) (T, error)) func(context.Context, json.RawMessage) (T, error) {
+	if provider == nil {
+		return handler
+	}
+
+	tracer := provider.Tracer("github.com/borkod/poc-aws-azure-oidc/tracing")
+	return func(ctx context.Context, event json.RawMessage) (T, error) {
+		ctx, span := tracer.Start(parentContext(ctx), name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.FaaSTriggerOther),
+		)
+		if lc, ok := lambdacontext.FromContext(ctx); ok {
+			span.SetAttributes(semconv.FaaSInvocationID(lc.AwsRequestID), semconv.CloudResourceID(lc.InvokedFunctionArn))
+		}
+
+		resp, err := handler(ctx, event)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+
+		// A failed export only loses the trace, so the invocation result stands
+		_ = provider.ForceFlush(ctx)
+		return resp, err
+	}
+}
+
+// parentContext extracts the trace Lambda received, set by Step Functions or
+// Lambda's own active tracing.
+func parentContext(ctx context.Context) context.Context {
+	header, _ := ctx.Value("x-amzn-trace-id").(string)
+	if header == "" {
+		header = os.Getenv("_X_AMZN_TRACE_ID")
+	}
+	if header == "" {
+		return ctx
+	}
+
+	carrier := propagation.HeaderCarrier{}
+	carrier.Set(traceHeader, header)
+	return xray.Propagator{}.Extract(ctx, carrier)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/create_service_principal/src/partition"
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/create_service_principal/src/trustpolicy"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/iam"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// roleSessionName names the session used to read roles cross-account
+const roleSessionName = "CreateServicePrincipal"
+
+// roleInfo is what the create workflow needs to know about the IAM role.
+type roleInfo struct {
+	Arn string
+	// Path is the role path, empty when neither the event nor IAM gave it
+	Path        string
+	TrustPolicy *trustpolicy.Document
+	Tags        map[string]string
+}
+
+// getRole returns the role's trust policy document and tags. The values from
+// the CloudTrail event are used when present, otherwise the role is read
+// cross-account in awsPartition through crossAccountRoleName. With needsPath,
+// an event without the role's ARN, and so without its path, is also read
+// cross-account when crossAccountRoleName is set.
+func getRole(ctx context.Context, logger *slog.Logger, evt eventStruct, awsPartition, crossAccountRoleName string, needsPath bool) (*roleInfo, error) {
+	pathFromIAM := needsPath && evt.RoleArn == "" && crossAccountRoleName != ""
+	if evt.AssumeRolePolicyDocument != "" && !pathFromIAM {
+		doc, err := trustpolicy.Parse([]byte(evt.AssumeRolePolicyDocument))
+		if err != nil {
+			return nil, err
+		}
+		tags := map[string]string{}
+		for _, tag := range evt.Tags {
+			tags[tag.Key] = tag.Value
+		}
+		return &roleInfo{Arn: roleArnFromEvent(evt, awsPartition), Path: rolePath(evt.RoleArn), TrustPolicy: doc, Tags: tags}, nil
+	}
+
+	if crossAccountRoleName == "" {
+		return nil, fmt.Errorf("trust policy not in event and create.crossAccountRoleName is not set")
+	}
+
+	roleArn := partition.RoleARN(awsPartition, evt.Account, crossAccountRoleName)
+	logger.Debug("Assuming cross-account role", "sessionArn", partition.AssumedRoleARN(awsPartition, evt.Account, crossAccountRoleName, roleSessionName))
+	cfg := awsCfg.Copy()
+	cfg.Credentials = aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(sts.NewFromConfig(awsCfg), roleArn, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = roleSessionName
+	}))
+
+	resp, err := iam.NewFromConfig(cfg).GetRole(ctx, &iam.GetRoleInput{
+		RoleName: &evt.RoleName,
+	})
+	if err != nil {
+		logger.Error("Error getting role", "error", err)
+		return nil, err
+	}
+	if resp.Role == nil || resp.Role.AssumeRolePolicyDocument == nil {
+		return nil, fmt.Errorf("role %s has no trust policy", evt.RoleName)
+	}
+
+	doc, err := trustpolicy.Parse([]byte(*resp.Role.AssumeRolePolicyDocument))
+	if err != nil {
+		return nil, err
+	}
+	tags := map[string]string{}
+	for _, tag := range resp.Role.Tags {
+		tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	return &roleInfo{Arn: aws.ToString(resp.Role.Arn), Path: aws.ToString(resp.Role.Path), TrustPolicy: doc, Tags: tags}, nil
+}
+
+// roleArnFromEvent returns the role ARN from the CloudTrail response, falling back to
+// an ARN without a path.
+func roleArnFromEvent(evt eventStruct, awsPartition string) string {
+	if evt.RoleArn != "" {
+		return evt.RoleArn
+	}
+	return partition.RoleARN(awsPartition, evt.Account, evt.RoleName)
+}
+
+// rolePath returns the path of a role ARN, such as /service-role/, / when the
+// role has none, or an empty string when roleArn is not a role ARN
+func rolePath(roleArn string) string {
+	_, resource, ok := strings.Cut(roleArn, ":role/")
+	if !ok {
+		return ""
+	}
+	i := strings.LastIndex(resource, "/")
+	if i < 0 {
+		return "/"
+	}
+	return "/" + resource[:i+1]
+}
+
+// isFederatedWithProvider reports whether the trust policy has a statement that
+// federates with the given OIDC provider and still carries the placeholder
+// audience, under any condition operator
+func isFederatedWithProvider(doc *trustpolicy.Document, providerArn, oidcURL, placeholder string) bool {
+	for _, stmt := range doc.WebIdentityStatements(providerArn) {
+		if stmt.HasConditionValue(oidcURL+":aud", placeholder) {
+			return true
+		}
+	}
+	return false
+}
+
+// trustPolicyUpdate is the role's trust policy rewritten for the app, which
+// the Assign Role to Audience step writes to the role
+type trustPolicyUpdate struct {
+	// Original is the policy the update was made from. The update is only
+	// applied while the role still has it.
+	Original string `json:"original"`
+	Updated  string `json:"updated"`
+}
+
+// updateTrustPolicy sets the audience and the claim conditions in every
+// statement federating with the OIDC provider that conditions the audience.
+// The other statements are left byte for byte. It returns nil when no
+// statement conditions the audience.
+func updateTrustPolicy(doc *trustpolicy.Document, providerArn, oidcURL, audience string, conditions map[string][]string) (*trustPolicyUpdate, error) {
+	original, err := doc.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	// Edit a copy, so the role's document stays as it was read
+	updated, err := trustpolicy.Parse(original)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, stmt := range updated.WebIdentityStatements(providerArn) {
+		if len(stmt.ConditionValues(oidcURL+":aud")) == 0 {
+			continue
+		}
+		stmt.SetAudience(oidcURL, []string{audience})
+		for claim, values := range conditions {
+			stmt.SetCondition(oidcURL+":"+claim, values)
+		}
+	}
+	if !updated.Modified() {
+		return nil, nil
+	}
+
+	data, err := updated.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return &trustPolicyUpdate{Original: string(original), Updated: string(data)}, nil
+}
@@ -0,0 +1,522 @@
+// Package trustpolicy parses and edits IAM role trust policies.
+//
+// IAM accepts several shapes for the same policy: Statement, Action, Principal
+// values and condition values may each be a single string or a list. The package
+// reads all of them and only re-serializes the statements that were edited, so
+// unrelated statements keep their original bytes.
+package trustpolicy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// WebIdentityAction is the action used by OIDC federated principals.
+const WebIdentityAction = "sts:AssumeRoleWithWebIdentity"
+
+// DefaultOperator is used when a condition key has to be added to a statement.
+const DefaultOperator = "StringEquals"
+
+// StringList is an IAM field that holds either a single string or a list of
+// strings. Boolean and numeric condition values are kept as their JSON text,
+// and are written back with their original type unless they are changed.
+type StringList []string
+
+func (l *StringList) UnmarshalJSON(data []byte) error {
+	if firstByte(data) == '[' {
+		var items []json.RawMessage
+		if err := json.Unmarshal(data, &items); err != nil {
+			return err
+		}
+		list := make(StringList, 0, len(items))
+		for _, item := range items {
+			s, err := scalarString(item)
+			if err != nil {
+				return err
+			}
+			list = append(list, s)
+		}
+		*l = list
+		return nil
+	}
+
+	s, err := scalarString(data)
+	if err != nil {
+		return err
+	}
+	*l = StringList{s}
+	return nil
+}
+
+func scalarString(data []byte) (string, error) {
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return "", err
+	}
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool, float64:
+		return string(bytes.TrimSpace(data)), nil
+	default:
+		return "", errors.New("expected a string or a list of strings")
+	}
+}
+
+func (l StringList) MarshalJSON() ([]byte, error) {
+	switch len(l) {
+	case 0:
+		// IAM rejects null where it expects values
+		return []byte("[]"), nil
+	case 1:
+		return json.Marshal(l[0])
+	}
+	return json.Marshal([]string(l))
+}
+
+// Contains reports whether the list holds value.
+func (l StringList) Contains(value string) bool {
+	for _, v := range l {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Principal is the Principal element of a statement. Wildcard is set when the
+// principal is the string "*".
+type Principal struct {
+	Wildcard  bool
+	AWS       StringList
+	Federated StringList
+	Service   StringList
+}
+
+func (p *Principal) UnmarshalJSON(data []byte) error {
+	var wildcard string
+	if err := json.Unmarshal(data, &wildcard); err == nil {
+		if wildcard != "*" {
+			return fmt.Errorf("unsupported principal %q", wildcard)
+		}
+		p.Wildcard = true
+		return nil
+	}
+
+	var fields struct {
+		AWS       StringList `json:"AWS"`
+		Federated StringList `json:"Federated"`
+		Service   StringList `json:"Service"`
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return fmt.Errorf("invalid principal: %w", err)
+	}
+	p.AWS = fields.AWS
+	p.Federated = fields.Federated
+	p.Service = fields.Service
+	return nil
+}
+
+// Condition maps a condition operator, such as StringEquals or
+// ForAnyValue:StringLike, to its condition keys and values.
+type Condition map[string]map[string]StringList
+
+// ConditionMatch is one occurrence of a condition key in a statement.
+type ConditionMatch struct {
+	Operator string
+	Key      string
+	Values   StringList
+}
+
+// Statement is a single trust policy statement.
+type Statement struct {
+	Sid       string
+	Effect    string
+	Principal Principal
+	Action    StringList
+	Condition Condition
+
+	raw      json.RawMessage
+	fields   object
+	modified bool
+}
+
+// Document is a parsed trust policy.
+type Document struct {
+	Version    string
+	Statements []*Statement
+
+	raw             []byte
+	fields          object
+	statementIsList bool
+}
+
+// Parse reads a trust policy document. URL-encoded documents, as returned by
+// iam:GetRole, are decoded first.
+func Parse(data []byte) (*Document, error) {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) > 0 && trimmed[0] != '{' {
+		decoded, err := url.QueryUnescape(string(trimmed))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode policy document: %w", err)
+		}
+		trimmed = bytes.TrimSpace([]byte(decoded))
+	}
+
+	fields, err := parseObject(trimmed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse policy document: %w", err)
+	}
+
+	doc := &Document{raw: trimmed, fields: fields}
+
+	if version, ok := fields.get("Version"); ok {
+		if err := json.Unmarshal(version, &doc.Version); err != nil {
+			return nil, fmt.Errorf("invalid Version: %w", err)
+		}
+	}
+
+	statements, ok := fields.get("Statement")
+	if !ok {
+		return nil, errors.New("policy document has no Statement")
+	}
+
+	var raws []json.RawMessage
+	switch firstByte(statements) {
+	case '[':
+		doc.statementIsList = true
+		if err := json.Unmarshal(statements, &raws); err != nil {
+			return nil, fmt.Errorf("invalid Statement: %w", err)
+		}
+	case '{':
+		raws = []json.RawMessage{statements}
+	default:
+		return nil, errors.New("Statement must be an object or a list of objects")
+	}
+
+	for i, raw := range raws {
+		stmt, err := parseStatement(raw)
+		if err != nil {
+			return nil, fmt.Errorf("statement %d: %w", i, err)
+		}
+		doc.Statements = append(doc.Statements, stmt)
+	}
+
+	return doc, nil
+}
+
+func parseStatement(raw json.RawMessage) (*Statement, error) {
+	fields, err := parseObject(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := &Statement{raw: raw, fields: fields}
+
+	if v, ok := fields.get("Sid"); ok {
+		if err := json.Unmarshal(v, &stmt.Sid); err != nil {
+			return nil, fmt.Errorf("invalid Sid: %w", err)
+		}
+	}
+	if v, ok := fields.get("Effect"); ok {
+		if err := json.Unmarshal(v, &stmt.Effect); err != nil {
+			return nil, fmt.Errorf("invalid Effect: %w", err)
+		}
+	}
+	if v, ok := fields.get("Principal"); ok {
+		if err := json.Unmarshal(v, &stmt.Principal); err != nil {
+			return nil, err
+		}
+	}
+	if v, ok := fields.get("Action"); ok {
+		if err := json.Unmarshal(v, &stmt.Action); err != nil {
+			return nil, fmt.Errorf("invalid Action: %w", err)
+		}
+	}
+	if v, ok := fields.get("Condition"); ok {
+		if err := json.Unmarshal(v, &stmt.Condition); err != nil {
+			return nil, fmt.Errorf("invalid Condition: %w", err)
+		}
+	}
+
+	return stmt, nil
+}
+
+// WebIdentityStatements returns the Allow statements that let the given OIDC
+// provider ARN assume the role with a web identity token.
+func (d *Document) WebIdentityStatements(providerArn string) []*Statement {
+	var matches []*Statement
+	for _, stmt := range d.Statements {
+		if stmt.IsWebIdentityFor(providerArn) {
+			matches = append(matches, stmt)
+		}
+	}
+	return matches
+}
+
+// Modified reports whether any statement was edited since the document was parsed.
+func (d *Document) Modified() bool {
+	for _, stmt := range d.Statements {
+		if stmt.modified {
+			return true
+		}
+	}
+	return false
+}
+
+// Marshal serializes the document. Statements that were not edited are written
+// back byte for byte; an unedited document is returned unchanged.
+func (d *Document) Marshal() ([]byte, error) {
+	if !d.Modified() {
+		return append([]byte(nil), d.raw...), nil
+	}
+
+	var statements json.RawMessage
+	if d.statementIsList {
+		var buf bytes.Buffer
+		buf.WriteByte('[')
+		for i, stmt := range d.Statements {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			raw, err := stmt.marshal()
+			if err != nil {
+				return nil, err
+			}
+			buf.Write(raw)
+		}
+		buf.WriteByte(']')
+		statements = buf.Bytes()
+	} else {
+		raw, err := d.Statements[0].marshal()
+		if err != nil {
+			return nil, err
+		}
+		statements = raw
+	}
+
+	fields := d.fields.clone()
+	fields.set("Statement", statements)
+	return fields.MarshalJSON()
+}
+
+// IsWebIdentityFor reports whether the statement allows the given OIDC provider
+// ARN to call sts:AssumeRoleWithWebIdentity.
+func (s *Statement) IsWebIdentityFor(providerArn string) bool {
+	if s.Effect != "Allow" || !s.Principal.Federated.Contains(providerArn) {
+		return false
+	}
+	for _, action := range s.Action {
+		if actionMatches(action, WebIdentityAction) {
+			return true
+		}
+	}
+	return false
+}
+
+// ConditionValues returns every occurrence of key in the statement's
+// conditions, across all operators. Condition keys are matched case-insensitively.
+func (s *Statement) ConditionValues(key string) []ConditionMatch {
+	var matches []ConditionMatch
+	for _, operator := range sortedKeys(s.Condition) {
+		for _, k := range sortedKeys(s.Condition[operator]) {
+			if strings.EqualFold(k, key) {
+				matches = append(matches, ConditionMatch{
+					Operator: operator,
+					Key:      k,
+					Values:   s.Condition[operator][k],
+				})
+			}
+		}
+	}
+	return matches
+}
+
+// HasConditionValue reports whether any operator compares key against value.
+func (s *Statement) HasConditionValue(key, value string) bool {
+	for _, match := range s.ConditionValues(key) {
+		if match.Values.Contains(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetCondition replaces the values of key under every operator it appears in.
+// When the statement does not reference key yet, it is added under StringEquals.
+func (s *Statement) SetCondition(key string, values []string) {
+	matches := s.ConditionValues(key)
+	if len(matches) == 0 {
+		if s.Condition == nil {
+			s.Condition = Condition{}
+		}
+		if s.Condition[DefaultOperator] == nil {
+			s.Condition[DefaultOperator] = map[string]StringList{}
+		}
+		s.Condition[DefaultOperator][key] = append(StringList(nil), values...)
+		s.modified = true
+		return
+	}
+
+	for _, match := range matches {
+		s.Condition[match.Operator][match.Key] = append(StringList(nil), values...)
+	}
+	s.modified = true
+}
+
+// SetAudience rewrites the aud condition for the provider URL, for example
+// "sts.windows.net/<tenant>/".
+func (s *Statement) SetAudience(providerURL string, audiences []string) {
+	s.SetCondition(providerURL+":aud", audiences)
+}
+
+func (s *Statement) marshal() (json.RawMessage, error) {
+	if !s.modified {
+		return s.raw, nil
+	}
+
+	fields := s.fields.clone()
+	if len(s.Condition) == 0 {
+		fields.remove("Condition")
+	} else {
+		original, _ := s.fields.get("Condition")
+		condition, err := marshalCondition(original, s.Condition)
+		if err != nil {
+			return nil, err
+		}
+		fields.set("Condition", condition)
+	}
+	return fields.MarshalJSON()
+}
+
+// marshalCondition serializes condition, reusing the original bytes of every
+// value that was not changed. Boolean and numeric values such as
+// {"Bool": {"aws:SecureTransport": true}} keep their JSON type, since IAM
+// compares them differently from strings. Operators and keys keep their
+// original order; new ones follow in sorted order.
+func marshalCondition(original json.RawMessage, condition Condition) (json.RawMessage, error) {
+	var operators object
+	if original != nil {
+		// The statement parsed, so the original Condition is a valid object
+		operators, _ = parseObject(original)
+	}
+
+	var out object
+	for _, op := range operators {
+		keys, ok := condition[op.key]
+		if !ok {
+			continue
+		}
+		originalKeys, _ := parseObject(op.value)
+		raw, err := marshalOperator(originalKeys, keys)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, member{key: op.key, value: raw})
+	}
+	for _, operator := range sortedKeys(condition) {
+		if _, ok := operators.get(operator); ok {
+			continue
+		}
+		raw, err := marshalOperator(nil, condition[operator])
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, member{key: operator, value: raw})
+	}
+	return out.MarshalJSON()
+}
+
+// marshalOperator serializes the keys of one condition operator. A changed
+// value is written as a list when the original was a list.
+func marshalOperator(original object, keys map[string]StringList) (json.RawMessage, error) {
+	var out object
+	for _, m := range original {
+		values, ok := keys[m.key]
+		if !ok {
+			continue
+		}
+		var parsed StringList
+		if err := json.Unmarshal(m.value, &parsed); err == nil && slices.Equal(parsed, values) {
+			out = append(out, member{key: m.key, value: m.value})
+			continue
+		}
+
+		var raw []byte
+		var err error
+		if firstByte(m.value) == '[' {
+			raw, err = json.Marshal([]string(values))
+		} else {
+			raw, err = json.Marshal(values)
+		}
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, member{key: m.key, value: raw})
+	}
+	for _, key := range sortedKeys(keys) {
+		if _, ok := original.get(key); ok {
+			continue
+		}
+		raw, err := json.Marshal(keys[key])
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, member{key: key, value: raw})
+	}
+	return out.MarshalJSON()
+}
+
+// actionMatches compares an IAM action pattern, which may contain * and ?
+// wildcards, against an action. Actions are case-insensitive.
+func actionMatches(pattern, action string) bool {
+	return wildcardMatch(strings.ToLower(pattern), strings.ToLower(action))
+}
+
+func wildcardMatch(pattern, value string) bool {
+	p, v := 0, 0
+	star, next := -1, 0
+	for v < len(value) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == value[v]):
+			p++
+			v++
+		case p < len(pattern) && pattern[p] == '*':
+			star, next = p, v
+			p++
+		case star >= 0:
+			p = star + 1
+			next++
+			v = next
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func firstByte(data []byte) byte {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return 0
+	}
+	return trimmed[0]
+}
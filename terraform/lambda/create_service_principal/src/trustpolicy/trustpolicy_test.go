@@ -0,0 +1,336 @@
+package trustpolicy
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"unicode/utf8"
+)
+
+const (
+	testProviderURL = "sts.windows.net/00000000-0000-0000-0000-000000000000/"
+	testProviderArn = "arn:aws:iam::123456789012:oidc-provider/" + testProviderURL
+)
+
+var seedPolicies = []string{
+	`{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"Federated":"` + testProviderArn + `"},"Action":"sts:AssumeRoleWithWebIdentity","Condition":{"StringEquals":{"` + testProviderURL + `:aud":"placeholder"}}}]}`,
+	`{
+  "Version": "2012-10-17",
+  "Statement": [
+    {
+      "Sid": "Ec2",
+      "Effect": "Allow",
+      "Principal": { "Service": [ "ec2.amazonaws.com" ] },
+      "Action": [ "sts:AssumeRole" ]
+    },
+    {
+      "Effect": "Allow",
+      "Principal": { "Federated": [ "` + testProviderArn + `" ] },
+      "Action": [ "sts:AssumeRoleWithWebIdentity", "sts:TagSession" ],
+      "Condition": {
+        "ForAnyValue:StringLike": { "` + testProviderURL + `:aud": [ "placeholder" ] },
+        "Bool": { "aws:SecureTransport": true }
+      }
+    }
+  ]
+}`,
+	`{"Version":"2012-10-17","Statement":{"Effect":"Allow","Principal":"*","Action":"sts:*"}}`,
+	`%7B%22Version%22%3A%222012-10-17%22%2C%22Statement%22%3A%5B%5D%7D`,
+}
+
+func FuzzParse(f *testing.F) {
+	for _, seed := range seedPolicies {
+		f.Add([]byte(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		doc, err := Parse(data)
+		if err != nil {
+			return
+		}
+
+		out, err := doc.Marshal()
+		if err != nil {
+			t.Fatalf("Marshal of an unmodified document failed: %v", err)
+		}
+		if !bytes.Equal(out, doc.raw) {
+			t.Fatalf("unmodified document was not byte-stable:\n%s\n%s", doc.raw, out)
+		}
+	})
+}
+
+func FuzzSetAudience(f *testing.F) {
+	for _, seed := range seedPolicies {
+		f.Add([]byte(seed), "api://11111111-1111-1111-1111-111111111111")
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte, audience string) {
+		if !utf8.ValidString(audience) {
+			return
+		}
+
+		doc, err := Parse(data)
+		if err != nil {
+			return
+		}
+
+		targets := doc.WebIdentityStatements(testProviderArn)
+		for _, stmt := range targets {
+			stmt.SetAudience(testProviderURL, []string{audience})
+		}
+
+		out, err := doc.Marshal()
+		if err != nil {
+			t.Fatalf("Marshal failed: %v", err)
+		}
+		if !json.Valid(out) {
+			t.Fatalf("Marshal produced invalid JSON: %s", out)
+		}
+
+		reparsed, err := Parse(out)
+		if err != nil {
+			t.Fatalf("re-parse failed: %v\n%s", err, out)
+		}
+		if len(reparsed.Statements) != len(doc.Statements) {
+			t.Fatalf("statement count changed from %d to %d", len(doc.Statements), len(reparsed.Statements))
+		}
+
+		for i, stmt := range reparsed.Statements {
+			if !doc.Statements[i].modified {
+				if !bytes.Equal(stmt.raw, doc.Statements[i].raw) {
+					t.Fatalf("statement %d was not byte-stable:\n%s\n%s", i, doc.Statements[i].raw, stmt.raw)
+				}
+				continue
+			}
+			if !stmt.HasConditionValue(testProviderURL+":aud", audience) {
+				t.Fatalf("statement %d lost the new audience: %s", i, stmt.raw)
+			}
+		}
+	})
+}
+
+// webIdentityPolicy returns a compact trust policy with one web identity
+// statement for the test provider and the given Action and Condition JSON
+func webIdentityPolicy(action, condition string) string {
+	return `{"Version":"2012-10-17","Statement":[{"Effect":"Allow","Principal":{"Federated":"` + testProviderArn + `"},"Action":` + action + `,"Condition":` + condition + `}]}`
+}
+
+func TestEditConditions(t *testing.T) {
+	const (
+		aud      = testProviderURL + ":aud"
+		sub      = testProviderURL + ":sub"
+		audience = "api://11111111-1111-1111-1111-111111111111"
+		subject  = "22222222-2222-2222-2222-222222222222"
+	)
+
+	tests := []struct {
+		name      string
+		action    string
+		condition string
+		edit      func(s *Statement)
+		want      string
+	}{
+		{
+			name:      "StringEquals scalar",
+			action:    `"sts:AssumeRoleWithWebIdentity"`,
+			condition: `{"StringEquals":{"` + aud + `":"placeholder"}}`,
+			edit:      func(s *Statement) { s.SetAudience(testProviderURL, []string{audience}) },
+			want:      `{"StringEquals":{"` + aud + `":"` + audience + `"}}`,
+		},
+		{
+			name:      "StringEquals list stays a list",
+			action:    `["sts:AssumeRoleWithWebIdentity"]`,
+			condition: `{"StringEquals":{"` + aud + `":["placeholder"]}}`,
+			edit:      func(s *Statement) { s.SetAudience(testProviderURL, []string{audience}) },
+			want:      `{"StringEquals":{"` + aud + `":["` + audience + `"]}}`,
+		},
+		{
+			name:      "scalar becomes a list for several values",
+			action:    `"sts:AssumeRoleWithWebIdentity"`,
+			condition: `{"StringEquals":{"` + aud + `":"placeholder"}}`,
+			edit:      func(s *Statement) { s.SetAudience(testProviderURL, []string{audience, "other"}) },
+			want:      `{"StringEquals":{"` + aud + `":["` + audience + `","other"]}}`,
+		},
+		{
+			name:      "StringLike keeps its operator",
+			action:    `"sts:AssumeRoleWithWebIdentity"`,
+			condition: `{"StringLike":{"` + aud + `":"place*"}}`,
+			edit:      func(s *Statement) { s.SetAudience(testProviderURL, []string{audience}) },
+			want:      `{"StringLike":{"` + aud + `":"` + audience + `"}}`,
+		},
+		{
+			name:      "ForAnyValue keeps its operator",
+			action:    `"sts:*"`,
+			condition: `{"ForAnyValue:StringEquals":{"` + aud + `":["placeholder","other"]}}`,
+			edit:      func(s *Statement) { s.SetAudience(testProviderURL, []string{audience}) },
+			want:      `{"ForAnyValue:StringEquals":{"` + aud + `":["` + audience + `"]}}`,
+		},
+		{
+			name:      "Bool and number values keep their type",
+			action:    `"sts:AssumeRoleWithWebIdentity"`,
+			condition: `{"StringEquals":{"` + aud + `":"placeholder"},"Bool":{"aws:SecureTransport":true},"NumericLessThan":{"aws:MultiFactorAuthAge":3600}}`,
+			edit:      func(s *Statement) { s.SetAudience(testProviderURL, []string{audience}) },
+			want:      `{"StringEquals":{"` + aud + `":"` + audience + `"},"Bool":{"aws:SecureTransport":true},"NumericLessThan":{"aws:MultiFactorAuthAge":3600}}`,
+		},
+		{
+			name:      "Bool list keeps its type",
+			action:    `"sts:AssumeRoleWithWebIdentity"`,
+			condition: `{"Bool":{"aws:SecureTransport":[true]},"StringEquals":{"` + aud + `":"placeholder"}}`,
+			edit:      func(s *Statement) { s.SetAudience(testProviderURL, []string{audience}) },
+			want:      `{"Bool":{"aws:SecureTransport":[true]},"StringEquals":{"` + aud + `":"` + audience + `"}}`,
+		},
+		{
+			name:      "condition keys match case-insensitively",
+			action:    `"sts:AssumeRoleWithWebIdentity"`,
+			condition: `{"StringEquals":{"` + testProviderURL + `:AUD":"placeholder"}}`,
+			edit:      func(s *Statement) { s.SetAudience(testProviderURL, []string{audience}) },
+			want:      `{"StringEquals":{"` + testProviderURL + `:AUD":"` + audience + `"}}`,
+		},
+		{
+			name:      "new key goes under StringEquals",
+			action:    `"sts:AssumeRoleWithWebIdentity"`,
+			condition: `{"StringLike":{"` + aud + `":"placeholder"}}`,
+			edit:      func(s *Statement) { s.SetCondition(sub, []string{subject}) },
+			want:      `{"StringLike":{"` + aud + `":"placeholder"},"StringEquals":{"` + sub + `":"` + subject + `"}}`,
+		},
+		{
+			name:      "new key follows existing keys",
+			action:    `"sts:AssumeRoleWithWebIdentity"`,
+			condition: `{"StringEquals":{"` + aud + `":"placeholder"}}`,
+			edit:      func(s *Statement) { s.SetCondition(sub, []string{subject}) },
+			want:      `{"StringEquals":{"` + aud + `":"placeholder","` + sub + `":"` + subject + `"}}`,
+		},
+		{
+			name:      "no values",
+			action:    `"sts:AssumeRoleWithWebIdentity"`,
+			condition: `{"StringEquals":{"` + aud + `":"placeholder"}}`,
+			edit:      func(s *Statement) { s.SetCondition(sub, nil) },
+			want:      `{"StringEquals":{"` + aud + `":"placeholder","` + sub + `":[]}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := Parse([]byte(webIdentityPolicy(tt.action, tt.condition)))
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			targets := doc.WebIdentityStatements(testProviderArn)
+			if len(targets) != 1 {
+				t.Fatalf("WebIdentityStatements() found %d statements, want 1", len(targets))
+			}
+			tt.edit(targets[0])
+
+			out, err := doc.Marshal()
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			want := webIdentityPolicy(tt.action, tt.want)
+			if string(out) != want {
+				t.Fatalf("Marshal() =\n%s\nwant\n%s", out, want)
+			}
+		})
+	}
+}
+
+func TestStringListMarshalJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		list StringList
+		want string
+	}{
+		{name: "nil", list: nil, want: `[]`},
+		{name: "empty", list: StringList{}, want: `[]`},
+		{name: "one", list: StringList{"a"}, want: `"a"`},
+		{name: "several", list: StringList{"a", "b"}, want: `["a","b"]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := json.Marshal(tt.list)
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			if string(out) != tt.want {
+				t.Fatalf("Marshal() = %s, want %s", out, tt.want)
+			}
+		})
+	}
+}
+
+func TestConditionValues(t *testing.T) {
+	tests := []struct {
+		name      string
+		condition string
+		value     string
+		want      bool
+	}{
+		{name: "scalar", condition: `{"StringEquals":{"` + testProviderURL + `:aud":"placeholder"}}`, value: "placeholder", want: true},
+		{name: "list", condition: `{"StringEquals":{"` + testProviderURL + `:aud":["other","placeholder"]}}`, value: "placeholder", want: true},
+		{name: "StringLike", condition: `{"StringLike":{"` + testProviderURL + `:aud":"placeholder"}}`, value: "placeholder", want: true},
+		{name: "ForAnyValue", condition: `{"ForAnyValue:StringLike":{"` + testProviderURL + `:aud":["placeholder"]}}`, value: "placeholder", want: true},
+		{name: "other key", condition: `{"StringEquals":{"` + testProviderURL + `:sub":"placeholder"}}`, value: "placeholder", want: false},
+		{name: "other value", condition: `{"StringEquals":{"` + testProviderURL + `:aud":"api://other"}}`, value: "placeholder", want: false},
+		{name: "Bool as text", condition: `{"Bool":{"` + testProviderURL + `:aud":true}}`, value: "true", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := Parse([]byte(webIdentityPolicy(`"sts:AssumeRoleWithWebIdentity"`, tt.condition)))
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if got := doc.Statements[0].HasConditionValue(testProviderURL+":aud", tt.value); got != tt.want {
+				t.Fatalf("HasConditionValue() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebIdentityStatements(t *testing.T) {
+	tests := []struct {
+		name      string
+		statement string
+		want      bool
+	}{
+		{name: "scalar fields", statement: `{"Effect":"Allow","Principal":{"Federated":"` + testProviderArn + `"},"Action":"sts:AssumeRoleWithWebIdentity"}`, want: true},
+		{name: "list fields", statement: `{"Effect":"Allow","Principal":{"Federated":["arn:aws:iam::123456789012:oidc-provider/other","` + testProviderArn + `"]},"Action":["sts:TagSession","sts:AssumeRoleWithWebIdentity"]}`, want: true},
+		{name: "action wildcard", statement: `{"Effect":"Allow","Principal":{"Federated":"` + testProviderArn + `"},"Action":"sts:AssumeRole*"}`, want: true},
+		{name: "action case", statement: `{"Effect":"Allow","Principal":{"Federated":"` + testProviderArn + `"},"Action":"STS:assumerolewithwebidentity"}`, want: true},
+		{name: "deny", statement: `{"Effect":"Deny","Principal":{"Federated":"` + testProviderArn + `"},"Action":"sts:AssumeRoleWithWebIdentity"}`, want: false},
+		{name: "other provider", statement: `{"Effect":"Allow","Principal":{"Federated":"arn:aws:iam::123456789012:oidc-provider/other"},"Action":"sts:AssumeRoleWithWebIdentity"}`, want: false},
+		{name: "other action", statement: `{"Effect":"Allow","Principal":{"Federated":"` + testProviderArn + `"},"Action":"sts:AssumeRole"}`, want: false},
+		{name: "service principal", statement: `{"Effect":"Allow","Principal":{"Service":"ec2.amazonaws.com"},"Action":"sts:AssumeRole"}`, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc, err := Parse([]byte(`{"Version":"2012-10-17","Statement":` + tt.statement + `}`))
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if got := len(doc.WebIdentityStatements(testProviderArn)) == 1; got != tt.want {
+				t.Fatalf("WebIdentityStatements() found = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnrelatedStatementsAreByteStable(t *testing.T) {
+	ec2 := `{ "Effect": "Allow", "Principal": { "Service": "ec2.amazonaws.com" }, "Action": "sts:AssumeRole" }`
+	policy := `{"Version":"2012-10-17","Statement":[` + ec2 + `,{"Effect":"Allow","Principal":{"Federated":"` + testProviderArn + `"},"Action":"sts:AssumeRoleWithWebIdentity","Condition":{"StringEquals":{"` + testProviderURL + `:aud":"placeholder"}}}]}`
+
+	doc, err := Parse([]byte(policy))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	doc.WebIdentityStatements(testProviderArn)[0].SetAudience(testProviderURL, []string{"api://new"})
+
+	out, err := doc.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	if !bytes.Contains(out, []byte(ec2)) {
+		t.Fatalf("unrelated statement changed:\n%s", out)
+	}
+}
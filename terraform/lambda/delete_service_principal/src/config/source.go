@@ -0,0 +1,265 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/appconfigdata"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+// source fetches the raw document
+type source interface {
+	// fetch returns the document, or nil when it is unchanged since the last fetch
+	fetch(ctx context.Context) ([]byte, error)
+	String() string
+}
+
+// newSource returns the source named by CONFIG_SOURCE
+func newSource(awsCfg aws.Config, value string) (source, error) {
+	kind, location, _ := strings.Cut(value, ":")
+	switch kind {
+	case "":
+		return &envSource{}, nil
+	case "file":
+		return fileSource{path: location}, nil
+	case "ssm":
+		return ssmSource{client: ssm.NewFromConfig(awsCfg), name: location}, nil
+	case "appconfig":
+		parts := strings.Split(location, "/")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("CONFIG_SOURCE %q: expected appconfig:<application>/<environment>/<profile>", value)
+		}
+		return &appConfigSource{
+			client:      appconfigdata.NewFromConfig(awsCfg),
+			application: parts[0],
+			environment: parts[1],
+			profile:     parts[2],
+		}, nil
+	default:
+		return nil, fmt.Errorf("CONFIG_SOURCE %q: unsupported source %q, expected file, ssm or appconfig", value, kind)
+	}
+}
+
+type fileSource struct {
+	path string
+}
+
+func (s fileSource) fetch(ctx context.Context) ([]byte, error) {
+	return os.ReadFile(s.path)
+}
+
+func (s fileSource) String() string {
+	return "file:" + s.path
+}
+
+type ssmSource struct {
+	client *ssm.Client
+	name   string
+}
+
+func (s ssmSource) fetch(ctx context.Context) ([]byte, error) {
+	resp, err := s.client.GetParameter(ctx, &ssm.GetParameterInput{
+		Name:           &s.name,
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Parameter == nil || resp.Parameter.Value == nil {
+		return nil, fmt.Errorf("parameter %s has no value", s.name)
+	}
+	return []byte(*resp.Parameter.Value), nil
+}
+
+func (s ssmSource) String() string {
+	return "ssm:" + s.name
+}
+
+// appConfigSource polls an AppConfig configuration session. AppConfig only
+// returns the document when it changed since the previous poll.
+type appConfigSource struct {
+	client      *appconfigdata.Client
+	application string
+	environment string
+	profile     string
+	token       *string
+}
+
+func (s *appConfigSource) fetch(ctx context.Context) ([]byte, error) {
+	if s.token == nil {
+		session, err := s.client.StartConfigurationSession(ctx, &appconfigdata.StartConfigurationSessionInput{
+			ApplicationIdentifier:          &s.application,
+			EnvironmentIdentifier:          &s.environment,
+			ConfigurationProfileIdentifier: &s.profile,
+		})
+		if err != nil {
+			return nil, err
+		}
+		s.token = session.InitialConfigurationToken
+	}
+
+	resp, err := s.client.GetLatestConfiguration(ctx, &appconfigdata.GetLatestConfigurationInput{
+		ConfigurationToken: s.token,
+	})
+	if err != nil {
+		// Tokens expire after 24 hours, so start a new session on the next fetch
+		s.token = nil
+		return nil, err
+	}
+	s.token = resp.NextPollConfigurationToken
+
+	if len(resp.Configuration) == 0 {
+		return nil, nil
+	}
+	return resp.Configuration, nil
+}
+
+func (s *appConfigSource) String() string {
+	return "appconfig:" + s.application + "/" + s.environment + "/" + s.profile
+}
+
+// envSource builds the document from the individual environment variables
+// used before the configuration document existed.
+type envSource struct {
+	fetched bool
+}
+
+func (s *envSource) fetch(ctx context.Context) ([]byte, error) {
+	// The environment cannot change while the function runs
+	if s.fetched {
+		return nil, nil
+	}
+
+	doc := map[string]any{"version": SchemaVersion}
+
+	azure := map[string]any{}
+	setString(azure, "cloud", "AZURE_CLOUD")
+	setString(azure, "tenantId", "TENANT_ID")
+	setString(azure, "clientId", "CLIENT_ID")
+	setString(azure, "clientSecretSsm", "CLIENT_SECRET_SSM")
+	setString(azure, "oidcUrl", "OIDC_URL")
+	doc["azure"] = azure
+
+	if value := os.Getenv("TENANT_ROUTING"); value != "" {
+		if !json.Valid([]byte(value)) {
+			return nil, fmt.Errorf("TENANT_ROUTING is not valid JSON")
+		}
+		doc["tenantRouting"] = json.RawMessage(value)
+	}
+
+	create := map[string]any{}
+	setString(create, "audiencePlaceholder", "AUDIENCE_PLACEHOLDER")
+	if os.Getenv("REQUIRE_FEDERATION") == "true" {
+		create["requireFederation"] = true
+	}
+	setString(create, "crossAccountRoleName", "CROSS_ACCOUNT_ROLE_NAME")
+	if value := os.Getenv("ACCESS_TOKEN_VERSION"); value != "" {
+		version, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("ACCESS_TOKEN_VERSION %q is not a number", value)
+		}
+		create["accessTokenVersion"] = version
+	}
+	if os.Getenv("BIND_SUBJECT") == "true" {
+		create["bindSubject"] = true
+	}
+	var claims []string
+	for _, claim := range strings.Split(os.Getenv("BIND_CLAIMS"), ",") {
+		if claim = strings.TrimSpace(claim); claim != "" {
+			claims = append(claims, claim)
+		}
+	}
+	if len(claims) > 0 {
+		create["bindClaims"] = claims
+	}
+	owners := map[string]any{}
+	if os.Getenv("ASSIGN_OWNERS") == "true" {
+		owners["assign"] = true
+	}
+	setString(owners, "roleTag", "OWNER_ROLE_TAG")
+	setString(owners, "fallbackGroupId", "OWNER_FALLBACK_GROUP_ID")
+	create["owners"] = owners
+
+	access := map[string]any{}
+	if os.Getenv("ASSIGNMENT_REQUIRED") == "true" {
+		access["assignmentRequired"] = true
+	}
+	setString(access, "appRole", "ACCESS_APP_ROLE")
+	setList(access, "principals", "ACCESS_PRINCIPALS")
+	create["access"] = access
+
+	api := map[string]any{}
+	setString(api, "scope", "API_SCOPE")
+	setList(api, "preAuthorizedApps", "PRE_AUTHORIZED_APPS")
+	create["api"] = api
+
+	hardening := map[string]any{}
+	if os.Getenv("HARDEN_APPS") == "true" {
+		hardening["enabled"] = true
+	}
+	setString(hardening, "appManagementPolicyId", "APP_MANAGEMENT_POLICY_ID")
+	create["hardening"] = hardening
+
+	if value := os.Getenv("TOKEN_LIFETIME"); value != "" {
+		if !json.Valid([]byte(value)) {
+			return nil, fmt.Errorf("TOKEN_LIFETIME is not valid JSON")
+		}
+		create["tokenLifetime"] = json.RawMessage(value)
+	}
+
+	securityAttributes := map[string]any{}
+	setString(securityAttributes, "set", "SECURITY_ATTRIBUTE_SET")
+	if value := os.Getenv("SECURITY_ATTRIBUTES"); value != "" {
+		if !json.Valid([]byte(value)) {
+			return nil, fmt.Errorf("SECURITY_ATTRIBUTES is not valid JSON")
+		}
+		securityAttributes["attributes"] = json.RawMessage(value)
+	}
+	create["securityAttributes"] = securityAttributes
+	doc["create"] = create
+
+	graph := map[string]any{}
+	setString(graph, "requestTimeout", "GRAPH_REQUEST_TIMEOUT")
+	doc["graph"] = graph
+
+	doc["dryRun"] = os.Getenv("DRY_RUN") == "true"
+
+	organizations := map[string]any{}
+	if os.Getenv("ORGANIZATIONS_ENRICH") == "true" {
+		organizations["enrich"] = true
+	}
+	setString(organizations, "cacheTtl", "ORGANIZATIONS_CACHE_TTL")
+	doc["organizations"] = organizations
+
+	s.fetched = true
+	return json.Marshal(doc)
+}
+
+func (s *envSource) String() string {
+	return "environment variables"
+}
+
+func setString(section map[string]any, key, env string) {
+	if value := os.Getenv(env); value != "" {
+		section[key] = value
+	}
+}
+
+// setList sets key to the comma separated values of env
+func setList(section map[string]any, key, env string) {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(env), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	if len(values) > 0 {
+		section[key] = values
+	}
+}
@@ -0,0 +1,159 @@
+package graphhelper
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	abstractions "github.com/microsoft/kiota-abstractions-go"
+	"github.com/microsoft/kiota-abstractions-go/serialization"
+	msgraphgocore "github.com/microsoftgraph/msgraph-sdk-go-core"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/microsoftgraph/msgraph-sdk-go/serviceprincipals"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// securityAttributeValueType is the OData type of an attribute set in
+// customSecurityAttributes
+const securityAttributeValueType = "#Microsoft.DirectoryServices.CustomSecurityAttributeValue"
+
+// ServicePrincipal is a service principal found by its security attributes
+type ServicePrincipal struct {
+	ID          string
+	AppID       string
+	DisplayName string
+}
+
+// SetSecurityAttributes sets string custom security attributes of one
+// attribute set on the service principal. Attributes not in values are left
+// unchanged.
+func (g *GraphHelper) SetSecurityAttributes(ctx context.Context, servicePrincipalId string, set string, values map[string]string) (err error) {
+	ctx, end := startSpan(ctx, "SetSecurityAttributes",
+		attribute.String("servicePrincipal.id", servicePrincipalId),
+		attribute.String("attributeSet", set),
+	)
+	defer end(&err)
+
+	requestBody := models.NewServicePrincipal()
+	requestBody.SetCustomSecurityAttributes(securityAttributeValue(set, values))
+
+	_, err = g.appClient.ServicePrincipals().ByServicePrincipalId(servicePrincipalId).
+		Patch(withOperation(ctx, "patchServicePrincipalSecurityAttributes"), requestBody, nil)
+	if err != nil {
+		return fmt.Errorf("failed to set security attributes: %w", graphError(err))
+	}
+	return nil
+}
+
+// SecurityAttributes returns the string custom security attributes of one
+// attribute set on the service principal
+func (g *GraphHelper) SecurityAttributes(ctx context.Context, servicePrincipalId string, set string) (_ map[string]string, err error) {
+	ctx, end := startSpan(ctx, "SecurityAttributes",
+		attribute.String("servicePrincipal.id", servicePrincipalId),
+		attribute.String("attributeSet", set),
+	)
+	defer end(&err)
+
+	configuration := &serviceprincipals.ServicePrincipalItemRequestBuilderGetRequestConfiguration{
+		QueryParameters: &serviceprincipals.ServicePrincipalItemRequestBuilderGetQueryParameters{
+			Select: []string{"id", "customSecurityAttributes"},
+		},
+	}
+	sp, err := g.appClient.ServicePrincipals().ByServicePrincipalId(servicePrincipalId).
+		Get(withOperation(ctx, "getServicePrincipalSecurityAttributes"), configuration)
+	if err != nil {
+		return nil, graphError(err)
+	}
+	return securityAttributeSet(sp.GetCustomSecurityAttributes(), set), nil
+}
+
+// FindServicePrincipalsByAttribute returns the service principals whose
+// string custom security attribute set.name has the given value, following
+// every page of results
+func (g *GraphHelper) FindServicePrincipalsByAttribute(ctx context.Context, set string, name string, value string) (_ []ServicePrincipal, err error) {
+	ctx, end := startSpan(ctx, "FindServicePrincipalsByAttribute",
+		attribute.String("attributeSet", set),
+		attribute.String("attribute.name", name),
+	)
+	defer end(&err)
+
+	// Filtering on custom security attributes is an advanced query
+	headers := abstractions.NewRequestHeaders()
+	headers.Add("ConsistencyLevel", "eventual")
+
+	filter := fmt.Sprintf("customSecurityAttributes/%s/%s eq '%s'", set, name, strings.ReplaceAll(value, "'", "''"))
+	count := true
+	top := int32(999)
+	configuration := &serviceprincipals.ServicePrincipalsRequestBuilderGetRequestConfiguration{
+		Headers: headers,
+		QueryParameters: &serviceprincipals.ServicePrincipalsRequestBuilderGetQueryParameters{
+			Filter: &filter,
+			Count:  &count,
+			Top:    &top,
+			Select: []string{"id", "appId", "displayName"},
+		},
+	}
+	ctx = withOperation(ctx, "listServicePrincipalsByAttribute")
+	resp, err := g.appClient.ServicePrincipals().Get(ctx, configuration)
+	if err != nil {
+		return nil, graphError(err)
+	}
+
+	// Later pages are requested with the same headers
+	pages, err := msgraphgocore.NewPageIterator[models.ServicePrincipalable](resp, g.appClient.GetAdapter(), models.CreateServicePrincipalCollectionResponseFromDiscriminatorValue)
+	if err != nil {
+		return nil, err
+	}
+	pages.SetHeaders(headers)
+
+	var found []ServicePrincipal
+	err = pages.Iterate(ctx, func(sp models.ServicePrincipalable) bool {
+		if id := deref(sp.GetId()); id != "" {
+			found = append(found, ServicePrincipal{ID: id, AppID: deref(sp.GetAppId()), DisplayName: deref(sp.GetDisplayName())})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, graphError(err)
+	}
+	return found, nil
+}
+
+// securityAttributeValue returns customSecurityAttributes holding string
+// attributes in one attribute set
+func securityAttributeValue(set string, values map[string]string) models.CustomSecurityAttributeValueable {
+	properties := map[string]serialization.UntypedNodeable{
+		"@odata.type": serialization.NewUntypedString(securityAttributeValueType),
+	}
+	for name, value := range values {
+		properties[name] = serialization.NewUntypedString(value)
+	}
+	attributes := models.NewCustomSecurityAttributeValue()
+	attributes.SetAdditionalData(map[string]any{set: serialization.NewUntypedObject(properties)})
+	return attributes
+}
+
+// securityAttributeSet returns the string attributes of one attribute set.
+// Graph returns them as additional data of customSecurityAttributes, next to
+// OData annotations such as @odata.type, which are skipped.
+func securityAttributeSet(attributes models.CustomSecurityAttributeValueable, set string) map[string]string {
+	values := map[string]string{}
+	if attributes == nil {
+		return values
+	}
+	properties, _ := attributes.GetAdditionalData()[set].(map[string]any)
+	for name, value := range properties {
+		if strings.Contains(name, "@") {
+			continue
+		}
+		switch v := value.(type) {
+		case *string:
+			if v != nil {
+				values[name] = *v
+			}
+		case string:
+			values[name] = v
+		}
+	}
+	return values
+}
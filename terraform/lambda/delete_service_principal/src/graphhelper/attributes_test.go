@@ -0,0 +1,82 @@
+package graphhelper
+
+import (
+	"maps"
+	"testing"
+
+	jsonserialization "github.com/microsoft/kiota-serialization-json-go"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+)
+
+// parseSecurityAttributes parses customSecurityAttributes the way the Graph
+// client does for a response
+func parseSecurityAttributes(t *testing.T, body string) models.CustomSecurityAttributeValueable {
+	t.Helper()
+	node, err := jsonserialization.NewJsonParseNode([]byte(body))
+	if err != nil {
+		t.Fatalf("NewJsonParseNode() error = %v", err)
+	}
+	value, err := node.GetObjectValue(models.CreateCustomSecurityAttributeValueFromDiscriminatorValue)
+	if err != nil {
+		t.Fatalf("GetObjectValue() error = %v", err)
+	}
+	return value.(models.CustomSecurityAttributeValueable)
+}
+
+func TestSecurityAttributeSet(t *testing.T) {
+	const body = `{
+		"AwsGovernance": {
+			"@odata.type": "#Microsoft.DirectoryServices.CustomSecurityAttributeValue",
+			"Account": "111111111111",
+			"Environment": "prod",
+			"Owners@odata.type": "#Collection(String)",
+			"Owners": ["a", "b"],
+			"Cost": 42
+		},
+		"Other": {
+			"@odata.type": "#Microsoft.DirectoryServices.CustomSecurityAttributeValue",
+			"Account": "222222222222"
+		}
+	}`
+	attributes := parseSecurityAttributes(t, body)
+
+	tests := []struct {
+		name string
+		set  string
+		want map[string]string
+	}{
+		{name: "string attributes of the set", set: "AwsGovernance", want: map[string]string{"Account": "111111111111", "Environment": "prod"}},
+		{name: "other set", set: "Other", want: map[string]string{"Account": "222222222222"}},
+		{name: "missing set", set: "Missing", want: map[string]string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := securityAttributeSet(attributes, tt.set); !maps.Equal(got, tt.want) {
+				t.Fatalf("securityAttributeSet() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if got := securityAttributeSet(nil, "AwsGovernance"); len(got) != 0 {
+		t.Fatalf("securityAttributeSet(nil) = %v, want empty", got)
+	}
+}
+
+func TestSecurityAttributeValueRoundTrip(t *testing.T) {
+	values := map[string]string{"Account": "111111111111", "AccountName": "it's prod"}
+
+	writer := jsonserialization.NewJsonSerializationWriter()
+	if err := writer.WriteObjectValue("", securityAttributeValue("AwsGovernance", values)); err != nil {
+		t.Fatalf("WriteObjectValue() error = %v", err)
+	}
+	body, err := writer.GetSerializedContent()
+	if err != nil {
+		t.Fatalf("GetSerializedContent() error = %v", err)
+	}
+
+	attributes := parseSecurityAttributes(t, string(body))
+	if got := securityAttributeSet(attributes, "AwsGovernance"); !maps.Equal(got, values) {
+		t.Fatalf("round trip = %v from %s, want %v", got, body, values)
+	}
+}
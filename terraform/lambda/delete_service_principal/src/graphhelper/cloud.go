@@ -0,0 +1,106 @@
+package graphhelper
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	azcloud "github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+)
+
+// Cloud is a Microsoft cloud profile: where tokens are requested, which Graph
+// endpoint serves the directory and which hosts issue the access tokens.
+type Cloud struct {
+	Name string
+	// AuthorityHost is the Entra ID login endpoint, e.g. https://login.microsoftonline.com/
+	AuthorityHost string
+	// GraphEndpoint is the Microsoft Graph root, without the API version
+	GraphEndpoint string
+	// IssuerV1Host and IssuerV2Host issue v1 and v2 access tokens respectively
+	IssuerV1Host string
+	IssuerV2Host string
+}
+
+// Supported clouds
+var (
+	AzurePublic = Cloud{
+		Name:          "AzurePublic",
+		AuthorityHost: "https://login.microsoftonline.com/",
+		GraphEndpoint: "https://graph.microsoft.com",
+		IssuerV1Host:  "sts.windows.net",
+		IssuerV2Host:  "login.microsoftonline.com",
+	}
+	AzureUSGovernment = Cloud{
+		Name:          "AzureUSGovernment",
+		AuthorityHost: "https://login.microsoftonline.us/",
+		GraphEndpoint: "https://graph.microsoft.us",
+		IssuerV1Host:  "sts.windows.net",
+		IssuerV2Host:  "login.microsoftonline.us",
+	}
+	AzureUSGovernmentDoD = Cloud{
+		Name:          "AzureUSGovernmentDoD",
+		AuthorityHost: "https://login.microsoftonline.us/",
+		GraphEndpoint: "https://dod-graph.microsoft.us",
+		IssuerV1Host:  "sts.windows.net",
+		IssuerV2Host:  "login.microsoftonline.us",
+	}
+	AzureChina = Cloud{
+		Name:          "AzureChina",
+		AuthorityHost: "https://login.chinacloudapi.cn/",
+		GraphEndpoint: "https://microsoftgraph.chinacloudapi.cn",
+		IssuerV1Host:  "sts.chinacloudapi.cn",
+		IssuerV2Host:  "login.partner.microsoftonline.cn",
+	}
+)
+
+var clouds = []Cloud{AzurePublic, AzureUSGovernment, AzureUSGovernmentDoD, AzureChina}
+
+// CloudByName returns the cloud profile with the given name, matched
+// case-insensitively. An empty name selects AzurePublic.
+func CloudByName(name string) (Cloud, error) {
+	if name == "" {
+		return AzurePublic, nil
+	}
+	for _, cloud := range clouds {
+		if strings.EqualFold(cloud.Name, name) {
+			return cloud, nil
+		}
+	}
+	return Cloud{}, fmt.Errorf("unsupported cloud %q", name)
+}
+
+// Scope is the .default scope for Graph in this cloud
+func (c Cloud) Scope() string {
+	return c.GraphEndpoint + "/.default"
+}
+
+// BaseURL is the Graph v1.0 API root
+func (c Cloud) BaseURL() string {
+	return c.GraphEndpoint + "/v1.0"
+}
+
+// Issuer returns the iss claim of access tokens issued by the tenant
+func (c Cloud) Issuer(tenantId string, tokenVersion int32) string {
+	if tokenVersion == AccessTokenV2 {
+		return fmt.Sprintf("https://%s/%s/v2.0", c.IssuerV2Host, tenantId)
+	}
+	return fmt.Sprintf("https://%s/%s/", c.IssuerV1Host, tenantId)
+}
+
+// OIDCURL returns the issuer in the form IAM uses for the OIDC provider URL and
+// condition keys, i.e. without the scheme.
+func (c Cloud) OIDCURL(tenantId string, tokenVersion int32) string {
+	return strings.TrimPrefix(c.Issuer(tenantId, tokenVersion), "https://")
+}
+
+func (c Cloud) graphHost() string {
+	u, err := url.Parse(c.GraphEndpoint)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+func (c Cloud) azureConfiguration() azcloud.Configuration {
+	return azcloud.Configuration{ActiveDirectoryAuthorityHost: c.AuthorityHost}
+}
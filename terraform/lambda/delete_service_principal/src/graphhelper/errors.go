@@ -0,0 +1,104 @@
+package graphhelper
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	abstractions "github.com/microsoft/kiota-abstractions-go"
+	"github.com/microsoftgraph/msgraph-sdk-go/models/odataerrors"
+)
+
+// GraphError is a failed Graph request. It carries the OData error code and
+// message along with the IDs Microsoft support asks for in a ticket.
+type GraphError struct {
+	StatusCode      int
+	Code            string
+	Message         string
+	RequestID       string
+	ClientRequestID string
+	Date            string
+
+	err error
+}
+
+func (e *GraphError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "graph request failed with status %d", e.StatusCode)
+	if e.Code != "" {
+		fmt.Fprintf(&b, ": %s", e.Code)
+	}
+	if e.Message != "" {
+		fmt.Fprintf(&b, ": %s", e.Message)
+	}
+	fmt.Fprintf(&b, " (request-id: %s, client-request-id: %s", e.RequestID, e.ClientRequestID)
+	if e.Date != "" {
+		fmt.Fprintf(&b, ", date: %s", e.Date)
+	}
+	b.WriteString(")")
+	return b.String()
+}
+
+func (e *GraphError) Unwrap() error {
+	return e.err
+}
+
+// graphError wraps errors returned by the Graph SDK in a GraphError. Errors
+// without a Graph response, such as timeouts, are returned unchanged; the
+// diagnostics middleware has already added the client-request-id to them.
+func graphError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var odataErr *odataerrors.ODataError
+	if errors.As(err, &odataErr) {
+		graphErr := &GraphError{StatusCode: odataErr.ResponseStatusCode, err: err}
+		if mainErr := odataErr.GetErrorEscaped(); mainErr != nil {
+			graphErr.Code = deref(mainErr.GetCode())
+			graphErr.Message = deref(mainErr.GetMessage())
+			if inner := mainErr.GetInnerError(); inner != nil {
+				graphErr.RequestID = deref(inner.GetRequestId())
+				graphErr.ClientRequestID = deref(inner.GetClientRequestId())
+				if date := inner.GetDate(); date != nil {
+					graphErr.Date = date.String()
+				}
+			}
+		}
+		fillFromHeaders(graphErr, odataErr.ResponseHeaders)
+		return graphErr
+	}
+
+	var apiErr *abstractions.ApiError
+	if errors.As(err, &apiErr) {
+		graphErr := &GraphError{StatusCode: apiErr.ResponseStatusCode, Message: apiErr.Message, err: err}
+		fillFromHeaders(graphErr, apiErr.ResponseHeaders)
+		return graphErr
+	}
+
+	return err
+}
+
+// fillFromHeaders prefers the IDs from the response headers, which are present
+// even when the error body is not an OData error.
+func fillFromHeaders(graphErr *GraphError, headers *abstractions.ResponseHeaders) {
+	if headers == nil {
+		return
+	}
+	if values := headers.Get("request-id"); len(values) > 0 {
+		graphErr.RequestID = values[0]
+	}
+	if values := headers.Get("client-request-id"); len(values) > 0 {
+		graphErr.ClientRequestID = values[0]
+	}
+	if values := headers.Get("date"); len(values) > 0 && graphErr.Date == "" {
+		graphErr.Date = values[0]
+	}
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -2,8 +2,12 @@ package graphhelper
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
 	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	abstractions "github.com/microsoft/kiota-abstractions-go"
@@ -12,22 +16,74 @@ import (
 	"github.com/microsoftgraph/msgraph-sdk-go/applications"
 	"github.com/microsoftgraph/msgraph-sdk-go/models"
 	"github.com/microsoftgraph/msgraph-sdk-go/serviceprincipals"
-	"github.com/microsoftgraph/msgraph-sdk-go/users"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+// Access token versions an app can request through api.requestedAccessTokenVersion
+const (
+	AccessTokenV1 int32 = 1
+	AccessTokenV2 int32 = 2
+)
+
+// ErrNotFound is returned when a lookup matches no directory object
+var ErrNotFound = errors.New("not found")
+
+// RequestObserver receives the outcome of every Graph request, including each
+// retry. statusCode is 0 when no response was received.
+type RequestObserver interface {
+	ObserveGraphRequest(operation string, statusCode int, duration time.Duration, retry bool)
+}
+
 type GraphHelper struct {
 	clientSecretCredential *azidentity.ClientSecretCredential
 	appClient              *msgraphsdk.GraphServiceClient
+	logger                 *slog.Logger
+	observer               RequestObserver
+	requestTimeout         time.Duration
+	cloud                  Cloud
 }
 
-func NewGraphHelper() *GraphHelper {
-	g := &GraphHelper{}
+// NewGraphHelper returns a GraphHelper that logs through logger, which should
+// carry the caller's correlation attributes.
+func NewGraphHelper(logger *slog.Logger) *GraphHelper {
+	g := &GraphHelper{logger: logger, requestTimeout: DefaultRequestTimeout, cloud: AzurePublic}
 	return g
 }
 
+// SetLogger replaces the logger, so a GraphHelper reused across invocations
+// logs with the current invocation's correlation attributes.
+func (g *GraphHelper) SetLogger(logger *slog.Logger) {
+	g.logger = logger
+}
+
+// SetCloud selects the cloud whose authority and Graph endpoint are used. It
+// must be called before InitializeGraphForAppAuth.
+func (g *GraphHelper) SetCloud(cloud Cloud) {
+	g.cloud = cloud
+}
+
+// Cloud returns the cloud the GraphHelper talks to
+func (g *GraphHelper) Cloud() Cloud {
+	return g.cloud
+}
+
+// SetRequestTimeout bounds each attempt of a Graph request. Zero disables the
+// timeout. It must be called before InitializeGraphForAppAuth.
+func (g *GraphHelper) SetRequestTimeout(timeout time.Duration) {
+	g.requestTimeout = timeout
+}
+
+// SetRequestObserver reports every Graph request to observer, for example to
+// record metrics.
+func (g *GraphHelper) SetRequestObserver(observer RequestObserver) {
+	g.observer = observer
+}
+
 func (g *GraphHelper) InitializeGraphForAppAuth(clientId string, tenantId string, clientSecret string) error {
 
-	credential, err := azidentity.NewClientSecretCredential(tenantId, clientId, clientSecret, nil)
+	credential, err := azidentity.NewClientSecretCredential(tenantId, clientId, clientSecret, &azidentity.ClientSecretCredentialOptions{
+		ClientOptions: azcore.ClientOptions{Cloud: g.cloud.azureConfiguration()},
+	})
 	if err != nil {
 		return err
 	}
@@ -35,18 +91,20 @@ func (g *GraphHelper) InitializeGraphForAppAuth(clientId string, tenantId string
 	g.clientSecretCredential = credential
 
 	// Create an auth provider using the credential
-	authProvider, err := auth.NewAzureIdentityAuthenticationProviderWithScopes(g.clientSecretCredential, []string{
-		"https://graph.microsoft.com/.default",
-	})
+	authProvider, err := auth.NewAzureIdentityAuthenticationProviderWithScopesAndValidHosts(tracedCredential{credential: g.clientSecretCredential},
+		[]string{g.cloud.Scope()},
+		[]string{g.cloud.graphHost()},
+	)
 	if err != nil {
 		return err
 	}
 
-	// Create a request adapter using the auth provider
-	adapter, err := msgraphsdk.NewGraphRequestAdapter(authProvider)
+	// Create a request adapter using the auth provider and our middleware chain
+	adapter, err := msgraphsdk.NewGraphRequestAdapterWithParseNodeFactoryAndSerializationWriterFactoryAndHttpClient(authProvider, nil, nil, g.newHTTPClient())
 	if err != nil {
 		return err
 	}
+	adapter.SetBaseUrl(g.cloud.BaseURL())
 
 	// Create a Graph client using request adapter
 	client := msgraphsdk.NewGraphServiceClient(adapter)
@@ -55,10 +113,10 @@ func (g *GraphHelper) InitializeGraphForAppAuth(clientId string, tenantId string
 	return nil
 }
 
-func (g *GraphHelper) GetAppToken() (*string, error) {
-	token, err := g.clientSecretCredential.GetToken(context.Background(), policy.TokenRequestOptions{
+func (g *GraphHelper) GetAppToken(ctx context.Context) (*string, error) {
+	token, err := g.clientSecretCredential.GetToken(ctx, policy.TokenRequestOptions{
 		Scopes: []string{
-			"https://graph.microsoft.com/.default",
+			g.cloud.Scope(),
 		},
 	})
 	if err != nil {
@@ -68,25 +126,7 @@ func (g *GraphHelper) GetAppToken() (*string, error) {
 	return &token.Token, nil
 }
 
-func (g *GraphHelper) GetUsers() (models.UserCollectionResponseable, error) {
-	var topValue int32 = 25
-	query := users.UsersRequestBuilderGetQueryParameters{
-		// Only request specific properties
-		Select: []string{"displayName", "id", "mail"},
-		// Get at most 25 results
-		Top: &topValue,
-		// Sort by display name
-		Orderby: []string{"displayName"},
-	}
-
-	return g.appClient.Users().
-		Get(context.Background(),
-			&users.UsersRequestBuilderGetRequestConfiguration{
-				QueryParameters: &query,
-			})
-}
-
-func (g *GraphHelper) ListApps() (models.ApplicationCollectionResponseable, error) {
+func (g *GraphHelper) ListApps(ctx context.Context) (models.ApplicationCollectionResponseable, error) {
 	var topValue int32 = 25
 	query := applications.ApplicationsRequestBuilderGetQueryParameters{
 		// Only request specific properties
@@ -97,42 +137,78 @@ func (g *GraphHelper) ListApps() (models.ApplicationCollectionResponseable, erro
 		//Orderby: []string{"displayName"},
 	}
 
-	return g.appClient.Applications().
-		Get(context.Background(),
+	apps, err := g.appClient.Applications().
+		Get(withOperation(ctx, "listApplications"),
 			&applications.ApplicationsRequestBuilderGetRequestConfiguration{
 				QueryParameters: &query,
 			})
+	return apps, graphError(err)
+}
+
+// AppSpec describes an app registration to create
+type AppSpec struct {
+	Name         string
+	TokenVersion int32
+	// Notes and Tags are left unset when empty
+	Notes string
+	Tags  []string
+	// API is exposed when the identifier URI is set
+	API ExposedAPI
+	// Hardening is applied when the app is created and when it is repaired
+	Hardening Hardening
 }
 
-func (g *GraphHelper) CreateApp(name string) (models.Applicationable, error) {
+func (g *GraphHelper) CreateApp(ctx context.Context, spec AppSpec) (_ models.Applicationable, err error) {
+	ctx, end := startSpan(ctx, "CreateApp", attribute.String("app.name", spec.Name))
+	defer end(&err)
+
 	requestBody := models.NewApplication()
-	requestBody.SetDisplayName(&name)
+	requestBody.SetDisplayName(&spec.Name)
+	if spec.Notes != "" {
+		requestBody.SetNotes(&spec.Notes)
+	}
+	if len(spec.Tags) > 0 {
+		requestBody.SetTags(spec.Tags)
+	}
+
+	api := models.NewApiApplication()
+	api.SetRequestedAccessTokenVersion(&spec.TokenVersion)
+	requestBody.SetApi(api)
+	if spec.Hardening.Enabled {
+		harden(requestBody)
+	}
 
 	applications, err := g.appClient.Applications().
-		Post(context.Background(), requestBody, nil)
+		Post(withOperation(ctx, "createApplication"), requestBody, nil)
 	if err != nil {
-		return nil, err
+		return nil, graphError(err)
 	}
 	return applications, nil
 }
 
 // CreateServicePrincipal creates a service principal for the given app ID
-func (g *GraphHelper) CreateServicePrincipal(appId string) (models.ServicePrincipalable, error) {
+func (g *GraphHelper) CreateServicePrincipal(ctx context.Context, appId string) (_ models.ServicePrincipalable, err error) {
+	ctx, end := startSpan(ctx, "CreateServicePrincipal", attribute.String("app.id", appId))
+	defer end(&err)
+
 	requestBody := models.NewServicePrincipal()
 	requestBody.SetAppId(&appId)
 
 	servicePrincipal, err := g.appClient.ServicePrincipals().
-		Post(context.Background(), requestBody, nil)
+		Post(withOperation(ctx, "createServicePrincipal"), requestBody, nil)
 	if err != nil {
-		return nil, err
+		return nil, graphError(err)
 	}
 	return servicePrincipal, nil
 }
 
 // CreateAppWithServicePrincipal creates both an app registration and its service principal
-func (g *GraphHelper) CreateAppWithServicePrincipal(name string) (appId string, servicePrincipalId string, err error) {
+func (g *GraphHelper) CreateAppWithServicePrincipal(ctx context.Context, spec AppSpec) (appId string, servicePrincipalId string, err error) {
+	ctx, end := startSpan(ctx, "CreateAppWithServicePrincipal", attribute.String("app.name", spec.Name))
+	defer end(&err)
+
 	// First, create the application registration
-	app, err := g.CreateApp(name)
+	app, err := g.CreateApp(ctx, spec)
 	if err != nil {
 		return "", "", fmt.Errorf("failed to create app: %w", err)
 	}
@@ -144,7 +220,7 @@ func (g *GraphHelper) CreateAppWithServicePrincipal(name string) (appId string,
 	appId = *appIdPtr
 
 	// Then, create the service principal for this app
-	sp, err := g.CreateServicePrincipal(appId)
+	sp, err := g.CreateServicePrincipal(ctx, appId)
 	if err != nil {
 		return appId, "", fmt.Errorf("failed to create service principal for app %s: %w", appId, err)
 	}
@@ -159,21 +235,25 @@ func (g *GraphHelper) CreateAppWithServicePrincipal(name string) (appId string,
 }
 
 // SetApplicationIdUri sets the Application ID URI (identifier URI) for an app registration
-// This is used to "Expose an API" in the Azure Portal
-func (g *GraphHelper) SetApplicationIdUri(appId string, applicationIdUri string) error {
+// This is used to "Expose an API" in the Azure Portal. The api's scope is defined
+// and its client apps pre-authorized in the same step.
+func (g *GraphHelper) SetApplicationIdUri(ctx context.Context, appId string, applicationIdUri string, api ExposedAPI) (err error) {
+	ctx, end := startSpan(ctx, "SetApplicationIdUri", attribute.String("app.id", appId))
+	defer end(&err)
+
 	// Get the application's object ID first
 	filter := fmt.Sprintf("appId eq '%s'", appId)
 	requestParameters := &applications.ApplicationsRequestBuilderGetQueryParameters{
 		Filter: &filter,
-		Select: []string{"id", "appId", "identifierUris"},
+		Select: []string{"id", "appId", "identifierUris", "api"},
 	}
 	configuration := &applications.ApplicationsRequestBuilderGetRequestConfiguration{
 		QueryParameters: requestParameters,
 	}
 
-	appsResponse, err := g.appClient.Applications().Get(context.Background(), configuration)
+	appsResponse, err := g.appClient.Applications().Get(withOperation(ctx, "getApplicationByAppId"), configuration)
 	if err != nil {
-		return fmt.Errorf("failed to get application: %w", err)
+		return fmt.Errorf("failed to get application: %w", graphError(err))
 	}
 
 	apps := appsResponse.GetValue()
@@ -195,25 +275,64 @@ func (g *GraphHelper) SetApplicationIdUri(appId string, applicationIdUri string)
 	identifierUris := []string{applicationIdUri}
 	requestBody.SetIdentifierUris(identifierUris)
 
-	_, err = g.appClient.Applications().ByApplicationId(*objectId).Patch(context.Background(), requestBody, nil)
+	_, err = g.appClient.Applications().ByApplicationId(*objectId).Patch(withOperation(ctx, "patchIdentifierUris"), requestBody, nil)
+	if err != nil {
+		return fmt.Errorf("failed to update application ID URI: %w", graphError(err))
+	}
+
+	existing := apps[0].GetApi()
+	if existing == nil {
+		existing = models.NewApiApplication()
+	}
+	return g.exposeAPI(ctx, *objectId, existing, api)
+}
+
+// SetApplicationDescription sets the description of the app registration with
+// the given object ID. An empty description clears it.
+func (g *GraphHelper) SetApplicationDescription(ctx context.Context, objectId string, description string) (err error) {
+	ctx, end := startSpan(ctx, "SetApplicationDescription", attribute.String("app.objectId", objectId))
+	defer end(&err)
+
+	requestBody := models.NewApplication()
+	requestBody.SetDescription(&description)
+
+	_, err = g.appClient.Applications().ByApplicationId(objectId).Patch(withOperation(ctx, "patchApplication"), requestBody, nil)
 	if err != nil {
-		return fmt.Errorf("failed to update application ID URI: %w", err)
+		return fmt.Errorf("failed to update application description: %w", graphError(err))
 	}
+	return nil
+}
+
+// SetAppRoleAssignmentRequired sets whether users and apps need an app role
+// assignment before Entra ID issues them tokens for the service principal
+func (g *GraphHelper) SetAppRoleAssignmentRequired(ctx context.Context, servicePrincipalId string, required bool) (err error) {
+	ctx, end := startSpan(ctx, "SetAppRoleAssignmentRequired", attribute.String("servicePrincipal.id", servicePrincipalId))
+	defer end(&err)
+
+	requestBody := models.NewServicePrincipal()
+	requestBody.SetAppRoleAssignmentRequired(&required)
 
+	_, err = g.appClient.ServicePrincipals().ByServicePrincipalId(servicePrincipalId).Patch(withOperation(ctx, "patchServicePrincipal"), requestBody, nil)
+	if err != nil {
+		return fmt.Errorf("failed to update service principal: %w", graphError(err))
+	}
 	return nil
 }
 
 // SetApplicationIdUriByName sets the Application ID URI for an app registration by name
-func (g *GraphHelper) SetApplicationIdUriByName(name string, applicationIdUri string) error {
-	appId, err := g.GetApp(name)
+func (g *GraphHelper) SetApplicationIdUriByName(ctx context.Context, name string, applicationIdUri string, api ExposedAPI) error {
+	appId, err := g.GetApp(ctx, name)
 	if err != nil {
 		return fmt.Errorf("failed to get app: %w", err)
 	}
 
-	return g.SetApplicationIdUri(appId, applicationIdUri)
+	return g.SetApplicationIdUri(ctx, appId, applicationIdUri, api)
 }
 
-func (g *GraphHelper) DeleteApp(name string) error {
+func (g *GraphHelper) DeleteApp(ctx context.Context, name string) (err error) {
+	ctx, end := startSpan(ctx, "DeleteApp", attribute.String("app.name", name))
+	defer end(&err)
+
 	headers := abstractions.NewRequestHeaders()
 	headers.Add("ConsistencyLevel", "eventual")
 
@@ -234,9 +353,9 @@ func (g *GraphHelper) DeleteApp(name string) error {
 	}
 
 	// To initialize your graphClient, see https://learn.microsoft.com/en-us/graph/sdks/create-client?from=snippets&tabs=go
-	appsResponse, err := g.appClient.Applications().Get(context.Background(), configuration)
+	appsResponse, err := g.appClient.Applications().Get(withOperation(ctx, "searchApplications"), configuration)
 	if err != nil {
-		return err
+		return graphError(err)
 	}
 
 	apps := appsResponse.GetValue()
@@ -253,15 +372,18 @@ func (g *GraphHelper) DeleteApp(name string) error {
 		return fmt.Errorf("appId is nil")
 	}
 
-	err = g.appClient.ApplicationsWithAppId(appId).Delete(context.Background(), nil)
+	err = g.appClient.ApplicationsWithAppId(appId).Delete(withOperation(ctx, "deleteApplication"), nil)
 	if err != nil {
-		return err
+		return graphError(err)
 	}
 	return nil
 }
 
 // GetServicePrincipalByAppId retrieves a service principal by app ID
-func (g *GraphHelper) GetServicePrincipalByAppId(appId string) (models.ServicePrincipalable, error) {
+func (g *GraphHelper) GetServicePrincipalByAppId(ctx context.Context, appId string) (_ models.ServicePrincipalable, err error) {
+	ctx, end := startSpan(ctx, "GetServicePrincipalByAppId", attribute.String("app.id", appId))
+	defer end(&err)
+
 	filter := fmt.Sprintf("appId eq '%s'", appId)
 	requestParameters := &serviceprincipals.ServicePrincipalsRequestBuilderGetQueryParameters{
 		Filter: &filter,
@@ -271,14 +393,14 @@ func (g *GraphHelper) GetServicePrincipalByAppId(appId string) (models.ServicePr
 		QueryParameters: requestParameters,
 	}
 
-	spResponse, err := g.appClient.ServicePrincipals().Get(context.Background(), configuration)
+	spResponse, err := g.appClient.ServicePrincipals().Get(withOperation(ctx, "getServicePrincipal"), configuration)
 	if err != nil {
-		return nil, err
+		return nil, graphError(err)
 	}
 
 	sps := spResponse.GetValue()
 	if len(sps) == 0 {
-		return nil, fmt.Errorf("no service principal found for app ID %s", appId)
+		return nil, fmt.Errorf("%w: no service principal found for app ID %s", ErrNotFound, appId)
 	}
 
 	if len(sps) > 1 {
@@ -289,8 +411,11 @@ func (g *GraphHelper) GetServicePrincipalByAppId(appId string) (models.ServicePr
 }
 
 // DeleteServicePrincipalByAppId deletes a service principal by app ID
-func (g *GraphHelper) DeleteServicePrincipalByAppId(appId string) error {
-	sp, err := g.GetServicePrincipalByAppId(appId)
+func (g *GraphHelper) DeleteServicePrincipalByAppId(ctx context.Context, appId string) (err error) {
+	ctx, end := startSpan(ctx, "DeleteServicePrincipalByAppId", attribute.String("app.id", appId))
+	defer end(&err)
+
+	sp, err := g.GetServicePrincipalByAppId(ctx, appId)
 	if err != nil {
 		return err
 	}
@@ -300,31 +425,34 @@ func (g *GraphHelper) DeleteServicePrincipalByAppId(appId string) error {
 		return fmt.Errorf("service principal ID is nil")
 	}
 
-	err = g.appClient.ServicePrincipals().ByServicePrincipalId(*spId).Delete(context.Background(), nil)
+	err = g.appClient.ServicePrincipals().ByServicePrincipalId(*spId).Delete(withOperation(ctx, "deleteServicePrincipal"), nil)
 	if err != nil {
-		return err
+		return graphError(err)
 	}
 
 	return nil
 }
 
 // DeleteAppWithServicePrincipal deletes both the service principal and app registration
-func (g *GraphHelper) DeleteAppWithServicePrincipal(name string) (string, error) {
+func (g *GraphHelper) DeleteAppWithServicePrincipal(ctx context.Context, name string) (_ string, err error) {
+	ctx, end := startSpan(ctx, "DeleteAppWithServicePrincipal", attribute.String("app.name", name))
+	defer end(&err)
+
 	// First, get the app to find its appId
-	appId, err := g.GetApp(name)
+	appId, err := g.GetApp(ctx, name)
 	if err != nil {
 		return "", fmt.Errorf("failed to get app: %w", err)
 	}
 
 	// Delete the service principal first (if it exists)
-	err = g.DeleteServicePrincipalByAppId(appId)
+	err = g.DeleteServicePrincipalByAppId(ctx, appId)
 	if err != nil {
 		// Log but don't fail if service principal deletion fails
-		fmt.Printf("Warning: failed to delete service principal for app %s: %v\n", appId, err)
+		g.logger.Warn("Failed to delete service principal", "appId", appId, "error", err)
 	}
 
 	// Then delete the app registration
-	err = g.DeleteApp(name)
+	err = g.DeleteApp(ctx, name)
 	if err != nil {
 		return appId, fmt.Errorf("failed to delete app: %w", err)
 	}
@@ -332,7 +460,10 @@ func (g *GraphHelper) DeleteAppWithServicePrincipal(name string) (string, error)
 	return appId, nil
 }
 
-func (g *GraphHelper) CheckAppExists(name string) (bool, error) {
+func (g *GraphHelper) CheckAppExists(ctx context.Context, name string) (_ bool, err error) {
+	ctx, end := startSpan(ctx, "CheckAppExists", attribute.String("app.name", name))
+	defer end(&err)
+
 	headers := abstractions.NewRequestHeaders()
 	headers.Add("ConsistencyLevel", "eventual")
 
@@ -353,9 +484,9 @@ func (g *GraphHelper) CheckAppExists(name string) (bool, error) {
 	}
 
 	// To initialize your graphClient, see https://learn.microsoft.com/en-us/graph/sdks/create-client?from=snippets&tabs=go
-	appsResponse, err := g.appClient.Applications().Get(context.Background(), configuration)
+	appsResponse, err := g.appClient.Applications().Get(withOperation(ctx, "searchApplications"), configuration)
 	if err != nil {
-		return false, err
+		return false, graphError(err)
 	}
 
 	apps := appsResponse.GetValue()
@@ -376,7 +507,26 @@ func (g *GraphHelper) CheckAppExists(name string) (bool, error) {
 	return true, nil
 }
 
-func (g *GraphHelper) GetApp(name string) (string, error) {
+// GetApp returns the appId of the app registration with the given name
+func (g *GraphHelper) GetApp(ctx context.Context, name string) (string, error) {
+	app, err := g.GetApplication(ctx, name)
+	if err != nil {
+		return "", err
+	}
+
+	appId := app.GetAppId()
+	if appId == nil {
+		return "", fmt.Errorf("appId is nil")
+	}
+
+	return *appId, nil
+}
+
+// GetApplication returns the app registration with the given name
+func (g *GraphHelper) GetApplication(ctx context.Context, name string) (_ models.Applicationable, err error) {
+	ctx, end := startSpan(ctx, "GetApplication", attribute.String("app.name", name))
+	defer end(&err)
+
 	headers := abstractions.NewRequestHeaders()
 	headers.Add("ConsistencyLevel", "eventual")
 
@@ -389,7 +539,7 @@ func (g *GraphHelper) GetApp(name string) (string, error) {
 		Search:  &requestSearch,
 		Count:   &requestCount,
 		Orderby: []string{"displayName"},
-		Select:  []string{"appId", "identifierUris", "displayName"},
+		Select:  append([]string{"id", "appId", "identifierUris", "displayName", "api"}, hardeningSelect...),
 	}
 	configuration := &applications.ApplicationsRequestBuilderGetRequestConfiguration{
 		Headers:         headers,
@@ -397,24 +547,39 @@ func (g *GraphHelper) GetApp(name string) (string, error) {
 	}
 
 	// To initialize your graphClient, see https://learn.microsoft.com/en-us/graph/sdks/create-client?from=snippets&tabs=go
-	appsResponse, err := g.appClient.Applications().Get(context.Background(), configuration)
+	appsResponse, err := g.appClient.Applications().Get(withOperation(ctx, "searchApplications"), configuration)
 	if err != nil {
-		return "", err
+		return nil, graphError(err)
 	}
 
 	apps := appsResponse.GetValue()
 	if len(apps) > 1 {
-		return "", fmt.Errorf("multiple apps found with name %s", name)
+		return nil, fmt.Errorf("multiple apps found with name %s", name)
 	}
 
 	if len(apps) == 0 {
-		return "", fmt.Errorf("no apps found with name %s", name)
+		return nil, fmt.Errorf("%w: no apps found with name %s", ErrNotFound, name)
 	}
 
-	appId := apps[0].GetAppId()
-	if appId == nil {
-		return "", fmt.Errorf("appId is nil")
+	return apps[0], nil
+}
+
+// TokenVersion returns the access token version an app requests. Apps that never
+// set api.requestedAccessTokenVersion get v1 tokens.
+func TokenVersion(app models.Applicationable) int32 {
+	if api := app.GetApi(); api != nil {
+		if version := api.GetRequestedAccessTokenVersion(); version != nil && *version == AccessTokenV2 {
+			return AccessTokenV2
+		}
 	}
+	return AccessTokenV1
+}
 
-	return *appId, nil
+// Audience returns the aud claim of access tokens issued for the app. v1 tokens
+// carry the identifier URI, v2 tokens carry the bare appId.
+func Audience(appId string, identifierUri string, tokenVersion int32) string {
+	if tokenVersion == AccessTokenV2 {
+		return appId
+	}
+	return identifierUri
 }
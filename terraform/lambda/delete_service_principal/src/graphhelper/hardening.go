@@ -0,0 +1,156 @@
+package graphhelper
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/microsoftgraph/msgraph-sdk-go/applications"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// signInAudienceMyOrg limits sign-in to accounts of the app's own tenant
+const signInAudienceMyOrg = "AzureADMyOrg"
+
+// hardeningSelect are the app properties HardeningDrift reads
+var hardeningSelect = []string{"servicePrincipalLockConfiguration", "signInAudience", "web", "spa", "publicClient", "isFallbackPublicClient"}
+
+// Hardening is the security profile of an audience-only app
+type Hardening struct {
+	// Enabled locks the service principal's properties, limits sign-in to the
+	// tenant, clears redirect URIs and the implicit grant, and makes the app
+	// a confidential client
+	Enabled bool
+	// AppManagementPolicyID is assigned to the app when set, to forbid adding
+	// password and key credentials
+	AppManagementPolicyID string
+}
+
+// harden sets the hardening profile's properties on an app create or update
+// request
+func harden(app models.Applicationable) {
+	enabled := true
+	lock := models.NewServicePrincipalLockConfiguration()
+	lock.SetIsEnabled(&enabled)
+	lock.SetAllProperties(&enabled)
+	app.SetServicePrincipalLockConfiguration(lock)
+
+	audience := signInAudienceMyOrg
+	app.SetSignInAudience(&audience)
+
+	disabled := false
+	implicitGrant := models.NewImplicitGrantSettings()
+	implicitGrant.SetEnableAccessTokenIssuance(&disabled)
+	implicitGrant.SetEnableIdTokenIssuance(&disabled)
+	web := models.NewWebApplication()
+	web.SetRedirectUris([]string{})
+	web.SetImplicitGrantSettings(implicitGrant)
+	app.SetWeb(web)
+
+	spa := models.NewSpaApplication()
+	spa.SetRedirectUris([]string{})
+	app.SetSpa(spa)
+	publicClient := models.NewPublicClientApplication()
+	publicClient.SetRedirectUris([]string{})
+	app.SetPublicClient(publicClient)
+
+	app.SetIsFallbackPublicClient(&disabled)
+}
+
+// HardeningDrift returns the properties of the app that differ from the
+// hardening profile. The app must have been read with GetApplication.
+func HardeningDrift(app models.Applicationable) []string {
+	var drift []string
+
+	lock := app.GetServicePrincipalLockConfiguration()
+	if lock == nil || !isTrue(lock.GetIsEnabled()) || !isTrue(lock.GetAllProperties()) {
+		drift = append(drift, "servicePrincipalLockConfiguration")
+	}
+	if deref(app.GetSignInAudience()) != signInAudienceMyOrg {
+		drift = append(drift, "signInAudience")
+	}
+	if web := app.GetWeb(); web != nil {
+		if len(web.GetRedirectUris()) > 0 {
+			drift = append(drift, "web.redirectUris")
+		}
+		if grant := web.GetImplicitGrantSettings(); grant != nil &&
+			(isTrue(grant.GetEnableAccessTokenIssuance()) || isTrue(grant.GetEnableIdTokenIssuance())) {
+			drift = append(drift, "web.implicitGrantSettings")
+		}
+	}
+	if spa := app.GetSpa(); spa != nil && len(spa.GetRedirectUris()) > 0 {
+		drift = append(drift, "spa.redirectUris")
+	}
+	if publicClient := app.GetPublicClient(); publicClient != nil && len(publicClient.GetRedirectUris()) > 0 {
+		drift = append(drift, "publicClient.redirectUris")
+	}
+	if isTrue(app.GetIsFallbackPublicClient()) {
+		drift = append(drift, "isFallbackPublicClient")
+	}
+	return drift
+}
+
+// HardenApplication applies the hardening profile to the app registration
+// with the given object ID
+func (g *GraphHelper) HardenApplication(ctx context.Context, objectId string) (err error) {
+	ctx, end := startSpan(ctx, "HardenApplication", attribute.String("app.objectId", objectId))
+	defer end(&err)
+
+	requestBody := models.NewApplication()
+	harden(requestBody)
+
+	_, err = g.appClient.Applications().ByApplicationId(objectId).Patch(withOperation(ctx, "patchApplication"), requestBody, nil)
+	if err != nil {
+		return fmt.Errorf("failed to harden application: %w", graphError(err))
+	}
+	return nil
+}
+
+// AppManagementPolicyIDs returns the IDs of the app management policies
+// assigned to the app registration with the given object ID
+func (g *GraphHelper) AppManagementPolicyIDs(ctx context.Context, objectId string) (_ []string, err error) {
+	ctx, end := startSpan(ctx, "AppManagementPolicyIDs", attribute.String("app.objectId", objectId))
+	defer end(&err)
+
+	configuration := &applications.ItemAppManagementPoliciesRequestBuilderGetRequestConfiguration{
+		QueryParameters: &applications.ItemAppManagementPoliciesRequestBuilderGetQueryParameters{
+			Select: []string{"id"},
+		},
+	}
+	resp, err := g.appClient.Applications().ByApplicationId(objectId).AppManagementPolicies().
+		Get(withOperation(ctx, "listAppManagementPolicies"), configuration)
+	if err != nil {
+		return nil, graphError(err)
+	}
+
+	var ids []string
+	for _, policy := range resp.GetValue() {
+		if id := deref(policy.GetId()); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// AssignAppManagementPolicy assigns the app management policy to the app
+// registration with the given object ID. A policy that is already assigned is
+// not an error.
+func (g *GraphHelper) AssignAppManagementPolicy(ctx context.Context, objectId string, policyId string) (err error) {
+	ctx, end := startSpan(ctx, "AssignAppManagementPolicy",
+		attribute.String("app.objectId", objectId),
+		attribute.String("policy.id", policyId),
+	)
+	defer end(&err)
+
+	ref := models.NewReferenceCreate()
+	odataId := g.cloud.BaseURL() + "/policies/appManagementPolicies/" + policyId
+	ref.SetOdataId(&odataId)
+
+	err = g.appClient.Applications().ByApplicationId(objectId).AppManagementPolicies().Ref().
+		Post(withOperation(ctx, "assignAppManagementPolicy"), ref, nil)
+	return ignoreExistingRef(graphError(err))
+}
+
+func isTrue(b *bool) bool {
+	return b != nil && *b
+}
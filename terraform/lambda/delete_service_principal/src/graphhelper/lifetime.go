@@ -0,0 +1,204 @@
+package graphhelper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/microsoftgraph/msgraph-sdk-go/policies"
+	"github.com/microsoftgraph/msgraph-sdk-go/serviceprincipals"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// tokenLifetimePolicyPrefix names the token lifetime policies managed by the
+// automation. Policies without it are never changed or unassigned.
+const tokenLifetimePolicyPrefix = "aws-token-lifetime-"
+
+// Policy is a directory policy assigned to a service principal
+type Policy struct {
+	ID          string
+	DisplayName string
+	// Definition is only read by FindTokenLifetimePolicy
+	Definition string
+}
+
+// Managed reports whether the policy is a token lifetime policy managed by
+// the automation
+func (p Policy) Managed() bool {
+	return strings.HasPrefix(p.DisplayName, tokenLifetimePolicyPrefix)
+}
+
+// TokenLifetimePolicy returns the display name and definition of the managed
+// token lifetime policy with the given name and access token lifetime
+func TokenLifetimePolicy(name string, lifetime time.Duration) (displayName string, definition string) {
+	var policy struct {
+		TokenLifetimePolicy struct {
+			Version             int    `json:"Version"`
+			AccessTokenLifetime string `json:"AccessTokenLifetime"`
+		} `json:"TokenLifetimePolicy"`
+	}
+	policy.TokenLifetimePolicy.Version = 1
+	policy.TokenLifetimePolicy.AccessTokenLifetime = formatTimeSpan(lifetime)
+
+	// Only strings are encoded, so Marshal can't fail
+	raw, _ := json.Marshal(policy)
+	return tokenLifetimePolicyPrefix + name, string(raw)
+}
+
+// formatTimeSpan formats d as the hh:mm:ss time span policy definitions use
+func formatTimeSpan(d time.Duration) string {
+	d = d.Round(time.Second)
+	return fmt.Sprintf("%02d:%02d:%02d", int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60)
+}
+
+// FindTokenLifetimePolicy returns the token lifetime policy with the given
+// display name
+func (g *GraphHelper) FindTokenLifetimePolicy(ctx context.Context, displayName string) (_ Policy, err error) {
+	ctx, end := startSpan(ctx, "FindTokenLifetimePolicy", attribute.String("policy.name", displayName))
+	defer end(&err)
+
+	configuration := &policies.TokenLifetimePoliciesRequestBuilderGetRequestConfiguration{
+		QueryParameters: &policies.TokenLifetimePoliciesRequestBuilderGetQueryParameters{
+			Select: []string{"id", "displayName", "definition"},
+		},
+	}
+	resp, err := g.appClient.Policies().TokenLifetimePolicies().Get(withOperation(ctx, "listTokenLifetimePolicies"), configuration)
+	if err != nil {
+		return Policy{}, graphError(err)
+	}
+	for _, policy := range resp.GetValue() {
+		if deref(policy.GetDisplayName()) != displayName || policy.GetId() == nil {
+			continue
+		}
+		return Policy{
+			ID:          *policy.GetId(),
+			DisplayName: displayName,
+			Definition:  strings.Join(policy.GetDefinition(), ""),
+		}, nil
+	}
+	return Policy{}, fmt.Errorf("%w: no token lifetime policy %s", ErrNotFound, displayName)
+}
+
+// CreateTokenLifetimePolicy creates a token lifetime policy and returns its ID
+func (g *GraphHelper) CreateTokenLifetimePolicy(ctx context.Context, displayName string, definition string) (_ string, err error) {
+	ctx, end := startSpan(ctx, "CreateTokenLifetimePolicy", attribute.String("policy.name", displayName))
+	defer end(&err)
+
+	requestBody := models.NewTokenLifetimePolicy()
+	requestBody.SetDisplayName(&displayName)
+	requestBody.SetDefinition([]string{definition})
+
+	policy, err := g.appClient.Policies().TokenLifetimePolicies().Post(withOperation(ctx, "createTokenLifetimePolicy"), requestBody, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create token lifetime policy %s: %w", displayName, graphError(err))
+	}
+	return deref(policy.GetId()), nil
+}
+
+// UpdateTokenLifetimePolicy replaces the definition of a token lifetime policy
+func (g *GraphHelper) UpdateTokenLifetimePolicy(ctx context.Context, policyId string, definition string) (err error) {
+	ctx, end := startSpan(ctx, "UpdateTokenLifetimePolicy", attribute.String("policy.id", policyId))
+	defer end(&err)
+
+	requestBody := models.NewTokenLifetimePolicy()
+	requestBody.SetDefinition([]string{definition})
+
+	_, err = g.appClient.Policies().TokenLifetimePolicies().ByTokenLifetimePolicyId(policyId).
+		Patch(withOperation(ctx, "patchTokenLifetimePolicy"), requestBody, nil)
+	if err != nil {
+		return fmt.Errorf("failed to update token lifetime policy %s: %w", policyId, graphError(err))
+	}
+	return nil
+}
+
+// DeleteTokenLifetimePolicy deletes a token lifetime policy
+func (g *GraphHelper) DeleteTokenLifetimePolicy(ctx context.Context, policyId string) (err error) {
+	ctx, end := startSpan(ctx, "DeleteTokenLifetimePolicy", attribute.String("policy.id", policyId))
+	defer end(&err)
+
+	err = g.appClient.Policies().TokenLifetimePolicies().ByTokenLifetimePolicyId(policyId).
+		Delete(withOperation(ctx, "deleteTokenLifetimePolicy"), nil)
+	return graphError(err)
+}
+
+// TokenLifetimePolicyInUse reports whether the token lifetime policy is still
+// assigned to an application or service principal
+func (g *GraphHelper) TokenLifetimePolicyInUse(ctx context.Context, policyId string) (_ bool, err error) {
+	ctx, end := startSpan(ctx, "TokenLifetimePolicyInUse", attribute.String("policy.id", policyId))
+	defer end(&err)
+
+	top := int32(1)
+	configuration := &policies.TokenLifetimePoliciesItemAppliesToRequestBuilderGetRequestConfiguration{
+		QueryParameters: &policies.TokenLifetimePoliciesItemAppliesToRequestBuilderGetQueryParameters{
+			Select: []string{"id"},
+			Top:    &top,
+		},
+	}
+	resp, err := g.appClient.Policies().TokenLifetimePolicies().ByTokenLifetimePolicyId(policyId).AppliesTo().
+		Get(withOperation(ctx, "listTokenLifetimePolicyAppliesTo"), configuration)
+	if err != nil {
+		return false, graphError(err)
+	}
+	return len(resp.GetValue()) > 0, nil
+}
+
+// TokenLifetimePolicies returns the token lifetime policies assigned to the
+// service principal
+func (g *GraphHelper) TokenLifetimePolicies(ctx context.Context, servicePrincipalId string) (_ []Policy, err error) {
+	ctx, end := startSpan(ctx, "TokenLifetimePolicies", attribute.String("servicePrincipal.id", servicePrincipalId))
+	defer end(&err)
+
+	configuration := &serviceprincipals.ItemTokenLifetimePoliciesRequestBuilderGetRequestConfiguration{
+		QueryParameters: &serviceprincipals.ItemTokenLifetimePoliciesRequestBuilderGetQueryParameters{
+			Select: []string{"id", "displayName"},
+		},
+	}
+	resp, err := g.appClient.ServicePrincipals().ByServicePrincipalId(servicePrincipalId).TokenLifetimePolicies().
+		Get(withOperation(ctx, "listServicePrincipalTokenLifetimePolicies"), configuration)
+	if err != nil {
+		return nil, graphError(err)
+	}
+
+	var assigned []Policy
+	for _, policy := range resp.GetValue() {
+		if id := deref(policy.GetId()); id != "" {
+			assigned = append(assigned, Policy{ID: id, DisplayName: deref(policy.GetDisplayName())})
+		}
+	}
+	return assigned, nil
+}
+
+// AssignTokenLifetimePolicy assigns the token lifetime policy to the service
+// principal. A policy that is already assigned is not an error.
+func (g *GraphHelper) AssignTokenLifetimePolicy(ctx context.Context, servicePrincipalId string, policyId string) (err error) {
+	ctx, end := startSpan(ctx, "AssignTokenLifetimePolicy",
+		attribute.String("servicePrincipal.id", servicePrincipalId),
+		attribute.String("policy.id", policyId),
+	)
+	defer end(&err)
+
+	ref := models.NewReferenceCreate()
+	odataId := g.cloud.BaseURL() + "/policies/tokenLifetimePolicies/" + policyId
+	ref.SetOdataId(&odataId)
+
+	err = g.appClient.ServicePrincipals().ByServicePrincipalId(servicePrincipalId).TokenLifetimePolicies().Ref().
+		Post(withOperation(ctx, "assignTokenLifetimePolicy"), ref, nil)
+	return ignoreExistingRef(graphError(err))
+}
+
+// UnassignTokenLifetimePolicy removes the token lifetime policy from the
+// service principal
+func (g *GraphHelper) UnassignTokenLifetimePolicy(ctx context.Context, servicePrincipalId string, policyId string) (err error) {
+	ctx, end := startSpan(ctx, "UnassignTokenLifetimePolicy",
+		attribute.String("servicePrincipal.id", servicePrincipalId),
+		attribute.String("policy.id", policyId),
+	)
+	defer end(&err)
+
+	err = g.appClient.ServicePrincipals().ByServicePrincipalId(servicePrincipalId).TokenLifetimePolicies().
+		ByTokenLifetimePolicyId(policyId).Ref().Delete(withOperation(ctx, "unassignTokenLifetimePolicy"), nil)
+	return graphError(err)
+}
@@ -0,0 +1,95 @@
+package graphhelper
+
+import (
+	"encoding/json"
+	nethttp "net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/delete_service_principal/src/logging"
+)
+
+func TestRedactHeaders(t *testing.T) {
+	headers := nethttp.Header{}
+	headers.Set("Authorization", "Bearer eyJ0")
+	headers.Set("Cookie", "session=1")
+	headers.Set("Client-Request-Id", "abc")
+	headers.Add("Accept", "application/json")
+	headers.Add("Accept", "text/plain")
+
+	want := map[string]string{
+		"Authorization":     logging.Redacted,
+		"Cookie":            logging.Redacted,
+		"Client-Request-Id": "abc",
+		"Accept":            "application/json, text/plain",
+	}
+	if got := redactHeaders(headers); !reflect.DeepEqual(got, want) {
+		t.Fatalf("redactHeaders() = %v, want %v", got, want)
+	}
+}
+
+func TestRedactBody(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        any
+	}{
+		{
+			name:        "nested secrets",
+			contentType: "application/json",
+			body: `{
+				"displayName": "app",
+				"passwordCredentials": [{"secretText": "s3cr3t"}],
+				"api": {"requestedAccessTokenVersion": 2},
+				"value": [{"id": "1", "accessToken": "eyJ0"}]
+			}`,
+			want: map[string]any{
+				"displayName":         "app",
+				"passwordCredentials": logging.Redacted,
+				"api":                 map[string]any{"requestedAccessTokenVersion": float64(2)},
+				"value":               []any{map[string]any{"id": "1", "accessToken": logging.Redacted}},
+			},
+		},
+		{
+			name:        "secret inside a credential",
+			contentType: "application/json; charset=utf-8",
+			body:        `{"credential": {"secretText": "s3cr3t"}, "tokenVersion": 1}`,
+			want:        map[string]any{"credential": logging.Redacted, "tokenVersion": float64(1)},
+		},
+		{
+			name:        "empty",
+			contentType: "application/json",
+			want:        nil,
+		},
+		{
+			name:        "not JSON",
+			contentType: "text/plain",
+			body:        "clientSecret=s3cr3t",
+			want:        "<19 bytes of text/plain>",
+		},
+		{
+			name:        "invalid JSON",
+			contentType: "application/json",
+			body:        `{"clientSecret": `,
+			want:        "<17 bytes of invalid JSON>",
+		},
+		{
+			name:        "too large",
+			contentType: "application/json",
+			body:        `"` + strings.Repeat("a", maxLoggedBody) + `"`,
+			want:        "<8194 bytes of JSON, over the 8192 byte logging limit>",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := redactBody(tt.contentType, []byte(tt.body))
+			if !reflect.DeepEqual(got, tt.want) {
+				gotJSON, _ := json.Marshal(got)
+				t.Fatalf("redactBody() = %s, want %v", gotJSON, tt.want)
+			}
+		})
+	}
+}
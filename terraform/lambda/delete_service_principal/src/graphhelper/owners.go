@@ -0,0 +1,142 @@
+package graphhelper
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/microsoftgraph/msgraph-sdk-go/groups"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"github.com/microsoftgraph/msgraph-sdk-go/users"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// maxGroupOwners caps how many members of a group are read, well under the
+// limit on owners of an application
+const maxGroupOwners int32 = 20
+
+// User is a directory user that can be made an owner
+type User struct {
+	ID                string
+	UserPrincipalName string
+	Mail              string
+}
+
+// FindUser returns the enabled user whose UPN or mail is login
+func (g *GraphHelper) FindUser(ctx context.Context, login string) (_ User, err error) {
+	ctx, end := startSpan(ctx, "FindUser")
+	defer end(&err)
+
+	value := odataString(login)
+	filter := fmt.Sprintf("userPrincipalName eq %s or mail eq %s", value, value)
+	requestParameters := &users.UsersRequestBuilderGetQueryParameters{
+		Filter: &filter,
+		Select: []string{"id", "userPrincipalName", "mail", "accountEnabled"},
+	}
+	configuration := &users.UsersRequestBuilderGetRequestConfiguration{
+		QueryParameters: requestParameters,
+	}
+
+	resp, err := g.appClient.Users().Get(withOperation(ctx, "findUser"), configuration)
+	if err != nil {
+		return User{}, graphError(err)
+	}
+
+	var found []User
+	for _, user := range resp.GetValue() {
+		if enabled := user.GetAccountEnabled(); enabled != nil && !*enabled {
+			continue
+		}
+		found = append(found, userFrom(user))
+	}
+	if len(found) == 0 {
+		return User{}, fmt.Errorf("%w: no enabled user with UPN or mail %s", ErrNotFound, login)
+	}
+	if len(found) > 1 {
+		return User{}, fmt.Errorf("multiple users found with UPN or mail %s", login)
+	}
+	return found[0], nil
+}
+
+// GroupUsers returns the users that are direct members of the group, at most
+// maxGroupOwners of them. Applications can't be owned by groups, so a group's
+// members are made owners instead.
+func (g *GraphHelper) GroupUsers(ctx context.Context, groupId string) (_ []User, err error) {
+	ctx, end := startSpan(ctx, "GroupUsers", attribute.String("group.id", groupId))
+	defer end(&err)
+
+	top := maxGroupOwners
+	requestParameters := &groups.ItemMembersGraphUserRequestBuilderGetQueryParameters{
+		Select: []string{"id", "userPrincipalName", "mail", "accountEnabled"},
+		Top:    &top,
+	}
+	configuration := &groups.ItemMembersGraphUserRequestBuilderGetRequestConfiguration{
+		QueryParameters: requestParameters,
+	}
+
+	resp, err := g.appClient.Groups().ByGroupId(groupId).Members().GraphUser().Get(withOperation(ctx, "listGroupUsers"), configuration)
+	if err != nil {
+		return nil, graphError(err)
+	}
+
+	var found []User
+	for _, user := range resp.GetValue() {
+		if enabled := user.GetAccountEnabled(); enabled != nil && !*enabled {
+			continue
+		}
+		found = append(found, userFrom(user))
+	}
+	return found, nil
+}
+
+// AddApplicationOwner makes the user an owner of the app registration with the
+// given object ID. A user that already owns it is not an error.
+func (g *GraphHelper) AddApplicationOwner(ctx context.Context, objectId string, userId string) (err error) {
+	ctx, end := startSpan(ctx, "AddApplicationOwner", attribute.String("app.objectId", objectId))
+	defer end(&err)
+
+	err = g.appClient.Applications().ByApplicationId(objectId).Owners().Ref().
+		Post(withOperation(ctx, "addApplicationOwner"), g.directoryObjectRef(userId), nil)
+	return ignoreExistingRef(graphError(err))
+}
+
+// AddServicePrincipalOwner makes the user an owner of the service principal.
+// A user that already owns it is not an error.
+func (g *GraphHelper) AddServicePrincipalOwner(ctx context.Context, servicePrincipalId string, userId string) (err error) {
+	ctx, end := startSpan(ctx, "AddServicePrincipalOwner", attribute.String("servicePrincipal.id", servicePrincipalId))
+	defer end(&err)
+
+	err = g.appClient.ServicePrincipals().ByServicePrincipalId(servicePrincipalId).Owners().Ref().
+		Post(withOperation(ctx, "addServicePrincipalOwner"), g.directoryObjectRef(userId), nil)
+	return ignoreExistingRef(graphError(err))
+}
+
+// directoryObjectRef references a directory object in the helper's cloud
+func (g *GraphHelper) directoryObjectRef(id string) models.ReferenceCreateable {
+	ref := models.NewReferenceCreate()
+	odataId := g.cloud.BaseURL() + "/directoryObjects/" + id
+	ref.SetOdataId(&odataId)
+	return ref
+}
+
+// ignoreExistingRef drops the error Graph returns when adding a reference
+// that is already there
+func ignoreExistingRef(err error) error {
+	if isGraphError(err, 400, "object references already exist") {
+		return nil
+	}
+	return err
+}
+
+// odataString quotes s as an OData string literal
+func odataString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func userFrom(user models.Userable) User {
+	return User{
+		ID:                deref(user.GetId()),
+		UserPrincipalName: deref(user.GetUserPrincipalName()),
+		Mail:              deref(user.GetMail()),
+	}
+}
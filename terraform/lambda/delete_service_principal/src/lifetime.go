@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/delete_service_principal/src/graphhelper"
+)
+
+// releaseTokenLifetimePolicies unassigns the managed token lifetime policies
+// from the app's service principal and deletes the ones no longer assigned
+// anywhere, so deleted apps leave no unused policies behind. Policies not
+// managed by the automation are left alone. Failures are logged rather than
+// failing the delete, since the app goes either way. In a dry run the
+// unassignments are returned as the plan.
+func releaseTokenLifetimePolicies(ctx context.Context, logger *slog.Logger, graphHelper *graphhelper.GraphHelper, appID string, dryRun bool) []plannedOperation {
+	sp, err := graphHelper.GetServicePrincipalByAppId(ctx, appID)
+	if errors.Is(err, graphhelper.ErrNotFound) || (err == nil && sp.GetId() == nil) {
+		return nil
+	}
+	if err != nil {
+		logger.Warn("Failed to get service principal to release token lifetime policies", "error", err)
+		return nil
+	}
+	servicePrincipalID := *sp.GetId()
+
+	assigned, err := graphHelper.TokenLifetimePolicies(ctx, servicePrincipalID)
+	if err != nil {
+		logger.Warn("Failed to read token lifetime policies", "error", err)
+		return nil
+	}
+
+	var plan []plannedOperation
+	for _, policy := range assigned {
+		if !policy.Managed() {
+			continue
+		}
+		if dryRun {
+			plan = append(plan, plannedOperation{Operation: opUnassignTokenLifetimePolicy, Name: policy.DisplayName, ID: policy.ID})
+			continue
+		}
+
+		logger := logger.With("policyName", policy.DisplayName, "policyId", policy.ID)
+		if err := graphHelper.UnassignTokenLifetimePolicy(ctx, servicePrincipalID, policy.ID); err != nil {
+			logger.Warn("Failed to unassign token lifetime policy", "error", err)
+			continue
+		}
+		logger.Info("Unassigned token lifetime policy")
+
+		inUse, err := graphHelper.TokenLifetimePolicyInUse(ctx, policy.ID)
+		if err != nil {
+			logger.Warn("Failed to check token lifetime policy assignments", "error", err)
+			continue
+		}
+		if inUse {
+			continue
+		}
+		if err := graphHelper.DeleteTokenLifetimePolicy(ctx, policy.ID); err != nil {
+			logger.Warn("Failed to delete unused token lifetime policy", "error", err)
+			continue
+		}
+		logger.Info("Deleted unused token lifetime policy")
+	}
+	return plan
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/delete_service_principal/src/config"
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/delete_service_principal/src/graphhelper"
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/delete_service_principal/src/metrics"
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/delete_service_principal/src/tenant"
+
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+)
+
+// findApplication returns the app and the tenant holding it. The deleted role's
+// tags are gone, so every tenant it could have been routed to is searched,
+// starting with the one its account or OU routes to.
+func findApplication(ctx context.Context, logger *slog.Logger, recorder *metrics.Recorder, doc *config.Document, info *accountInfo, account, appName string) (tenant.Profile, *graphhelper.GraphHelper, models.Applicationable, error) {
+	ouPath, err := ouPathIfNeeded(ctx, doc, info, account, tenants.NeedsOUPath())
+	if err != nil {
+		return tenant.Profile{}, nil, nil, err
+	}
+
+	for _, profile := range tenants.Candidates(account, ouPath) {
+		cloud, err := tenantCloud(doc, profile)
+		if err != nil {
+			return tenant.Profile{}, nil, nil, err
+		}
+		graphHelper, err := graphHelperFor(ctx, logger.With("tenant", profile.Name), recorder, doc, profile, cloud)
+		if err != nil {
+			return tenant.Profile{}, nil, nil, err
+		}
+
+		app, err := graphHelper.GetApplication(ctx, appName)
+		if errors.Is(err, graphhelper.ErrNotFound) {
+			logger.Debug("App not found in tenant", "tenant", profile.Name)
+			continue
+		}
+		if err != nil {
+			return tenant.Profile{}, nil, nil, err
+		}
+		return profile, graphHelper, app, nil
+	}
+	return tenant.Profile{}, nil, nil, fmt.Errorf("%w: no tenant has app %s", graphhelper.ErrNotFound, appName)
+}
@@ -4,112 +4,302 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"log"
+	"fmt"
+	"log/slog"
 	"os"
+	"time"
 
 	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/delete_service_principal/src/graphhelper"
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/delete_service_principal/src/logging"
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/delete_service_principal/src/metrics"
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/delete_service_principal/src/partition"
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/delete_service_principal/src/tracing"
 
 	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/microsoftgraph/msgraph-sdk-go/models"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/aws/aws-sdk-go-v2/otelaws"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
 // Response structure
 type Response struct {
-	StatusCode int    `json:"statusCode"`
-	AppID      string `json:"appId,omitempty"`
+	StatusCode  int                `json:"statusCode"`
+	Status      string             `json:"status,omitempty"`
+	Reason      string             `json:"reason,omitempty"`
+	AppID       string             `json:"appId,omitempty"`
+	Audience    string             `json:"audience,omitempty"`
+	Tenant      string             `json:"tenant,omitempty"`
+	AccountName string             `json:"accountName,omitempty"`
+	TenantID    string             `json:"tenantId,omitempty"`
+	OIDCURL     string             `json:"oidcUrl,omitempty"`
+	DryRun      bool               `json:"dryRun,omitempty"`
+	Plan        []plannedOperation `json:"plan,omitempty"`
+}
+
+// Graph writes reported in a dry run plan
+const (
+	opDeleteServicePrincipal      = "deleteServicePrincipal"
+	opDeleteApplication           = "deleteApplication"
+	opUnassignTokenLifetimePolicy = "unassignTokenLifetimePolicy"
+)
+
+// plannedOperation is a Graph write that a dry run stopped short of
+type plannedOperation struct {
+	Operation string `json:"operation"`
+	Name      string `json:"name,omitempty"`
+	ID        string `json:"id,omitempty"`
 }
 
 type eventStruct struct {
-	Account   string `json:"account"`
-	EventName string `json:"eventName"`
-	RoleName  string `json:"roleName"`
+	Account      string `json:"account"`
+	EventName    string `json:"eventName"`
+	RoleName     string `json:"roleName"`
+	DryRun       bool   `json:"dryRun,omitempty"`
+	EventID      string `json:"eventID,omitempty"`
+	Region       string `json:"region,omitempty"`
+	ExecutionArn string `json:"executionArn,omitempty"`
 }
 
 var (
-	ssmClient *ssm.Client
+	awsCfg         aws.Config
+	ssmClient      *ssm.Client
+	baseLogger     *slog.Logger
+	tracerProvider *sdktrace.TracerProvider
 )
 
 func init() {
+	baseLogger = logging.New()
+
 	// Initialize the S3 client outside of the handler, during the init phase
 	cfg, err := config.LoadDefaultConfig(context.TODO())
 	if err != nil {
-		log.Fatalf("unable to load SDK config, %v", err)
+		baseLogger.Error("unable to load SDK config", "error", err)
+		os.Exit(1)
+	}
+
+	tracerProvider, err = tracing.Init(context.TODO(), "delete-service-principal")
+	if err != nil {
+		baseLogger.Error("unable to set up tracing", "error", err)
+		os.Exit(1)
+	}
+	if tracerProvider != nil {
+		// Spans for SSM and Organizations calls
+		otelaws.AppendMiddlewares(&cfg.APIOptions)
+	}
+
+	err = initSettings(context.TODO(), baseLogger, cfg)
+	if err != nil {
+		baseLogger.Error("unable to load configuration", "error", err)
+		os.Exit(1)
 	}
 
+	awsCfg = cfg
 	ssmClient = ssm.NewFromConfig(cfg)
 }
 
 func handleRequest(ctx context.Context, event json.RawMessage) (Response, error) {
 
-	clientID := os.Getenv("CLIENT_ID")
-	tenantID := os.Getenv("TENANT_ID")
-	paramName := os.Getenv("CLIENT_SECRET_SSM")
+	recorder := metrics.New("DeleteServicePrincipal")
+	defer flushMetrics(ctx, recorder)
 
-	clientSecret, err := getSSMParamValue(ctx, paramName)
+	var evt eventStruct
+	err := json.Unmarshal(event, &evt)
 	if err != nil {
-		log.Println("Error getting SSM parameter:", err)
+		logging.ForInvocation(ctx, baseLogger).Error("Error unmarshalling event", "error", err)
+		return Response{StatusCode: 400}, err
+	}
+
+	// The app name carries the role's partition, taken from the event's region
+	// and falling back to this function's region
+	awsPartition := partition.Resolve("", evt.Region, os.Getenv("AWS_REGION"))
+
+	logger := logging.ForInvocation(ctx, baseLogger,
+		slog.String("executionArn", evt.ExecutionArn),
+		slog.String("eventId", evt.EventID),
+		slog.String("partition", awsPartition),
+		slog.String("account", evt.Account),
+		slog.String("role", evt.RoleName),
+	)
+	recorder.SetDimension("Account", evt.Account)
+
+	doc, err := loadSettings(ctx, logger)
+	if err != nil {
+		logger.Error("Error loading configuration", "error", err)
 		return Response{StatusCode: 500}, err
 	}
 
-	var evt eventStruct
-	err = json.Unmarshal(event, &evt)
+	// Events from accounts that are not active members of the organization
+	// are skipped without deleting anything, as the create step skips them
+	account, err := enrichAccount(ctx, doc, evt.Account)
+	if errors.Is(err, errAccountRejected) {
+		logger.Warn("Rejecting event from account", "error", err)
+		recorder.Count(metrics.EventsRejected)
+		return Response{
+			StatusCode: 200,
+			Status:     "skipped",
+			Reason:     err.Error(),
+		}, nil
+	}
 	if err != nil {
-		log.Println("Error unmarshalling event:", err)
-		return Response{StatusCode: 400}, err
+		logger.Error("Error describing account", "error", err)
+		return Response{StatusCode: 500}, err
+	}
+	if account != nil {
+		logger = logger.With("accountName", account.Name)
 	}
 
-	graphHelper := graphhelper.NewGraphHelper()
+	fields := nameFields(awsPartition, evt.Account, evt.RoleName, account)
+	appName := doc.Naming.FormatAppName(fields)
 
-	err = initializeGraph(graphHelper, clientID, tenantID, clientSecret)
+	// The audience to remove from the OIDC provider depends on the app's token version
+	profile, graphHelper, app, err := findApplication(ctx, logger, recorder, doc, account, evt.Account, appName)
+	if errors.Is(err, graphhelper.ErrNotFound) {
+		// Most deleted roles never had an app, since the create step skips
+		// roles that don't federate with the OIDC provider
+		logger.Info("Skipping role without an app", "appName", appName)
+		recorder.Count(metrics.EventsSkipped)
+		return Response{
+			StatusCode:  200,
+			Status:      "skipped",
+			Reason:      "no tenant has an app for the role",
+			AccountName: fields.AccountName,
+		}, nil
+	}
 	if err != nil {
-		log.Println("Error initializing graph:", err)
+		logger.Error("Error getting app", "error", err)
 		return Response{StatusCode: 500}, err
 	}
+	logger = logger.With("tenant", profile.Name)
+	recorder.SetDimension("Tenant", profile.Name)
 
-	appName := "aws-" + evt.Account + "-" + evt.RoleName
+	tokenVersion := graphhelper.TokenVersion(app)
+	oidcURL := tenantOIDCURL(profile, graphHelper.Cloud(), tokenVersion)
+	if app.GetAppId() != nil {
+		logger = logger.With("appId", *app.GetAppId())
+	}
+
+	// In a dry run every lookup still happens, but Graph writes are only planned
+	if evt.DryRun || doc.DryRun {
+		plan, appID, err := planDelete(ctx, logger, graphHelper, appName, app)
+		if err != nil {
+			logger.Error("Error planning delete", "error", err)
+			return Response{StatusCode: 500}, err
+		}
+		plan = append(releaseTokenLifetimePolicies(ctx, logger, graphHelper, appID, true), plan...)
+
+		logger.Info("Dry run planned Graph operations", "appName", appName, "operations", len(plan))
+		return Response{
+			StatusCode:  200,
+			Status:      "planned",
+			AppID:       appID,
+			Audience:    graphhelper.Audience(appID, doc.Naming.FormatIdentifierURI(appID), tokenVersion),
+			Tenant:      profile.Name,
+			AccountName: fields.AccountName,
+			TenantID:    profile.TenantID,
+			OIDCURL:     oidcURL,
+			DryRun:      true,
+			Plan:        plan,
+		}, nil
+	}
+
+	if app.GetAppId() != nil {
+		releaseTokenLifetimePolicies(ctx, logger, graphHelper, *app.GetAppId(), false)
+	}
 
 	// Delete both the service principal and app registration
-	appID, err := graphHelper.DeleteAppWithServicePrincipal(appName)
+	appID, err := graphHelper.DeleteAppWithServicePrincipal(ctx, appName)
 	if err != nil {
-		log.Println("Error deleting app with service principal:", err)
+		logger.Error("Error deleting app with service principal", "error", err)
 		return Response{StatusCode: 500}, err
 	}
 
-	log.Printf("Deleted app and service principal for app ID: %s", appID)
+	logger.Info("Deleted app and service principal")
+	recorder.Count(metrics.AppsDeleted)
 
 	return Response{
-		StatusCode: 200,
-		AppID:      appID,
+		StatusCode:  200,
+		Status:      "success",
+		AppID:       appID,
+		Audience:    graphhelper.Audience(appID, doc.Naming.FormatIdentifierURI(appID), tokenVersion),
+		Tenant:      profile.Name,
+		AccountName: fields.AccountName,
+		TenantID:    profile.TenantID,
+		OIDCURL:     oidcURL,
 	}, nil
 }
 
-func initializeGraph(graphHelper *graphhelper.GraphHelper, clientID, tenantID, clientSecret string) error {
+// flushMetrics writes the invocation's metrics. A failure only loses metrics, so
+// it is logged rather than failing the invocation.
+func flushMetrics(ctx context.Context, recorder *metrics.Recorder) {
+	if err := recorder.Flush(); err != nil {
+		logging.ForInvocation(ctx, baseLogger).Warn("Error writing metrics", "error", err)
+	}
+}
+
+// planDelete lists the Graph deletes DeleteAppWithServicePrincipal would perform
+func planDelete(ctx context.Context, logger *slog.Logger, graphHelper *graphhelper.GraphHelper, appName string, app models.Applicationable) ([]plannedOperation, string, error) {
+	appID := ""
+	if app.GetAppId() != nil {
+		appID = *app.GetAppId()
+	}
+	if appID == "" {
+		return nil, "", errors.New("appId is nil")
+	}
+
+	var plan []plannedOperation
+
+	sp, err := graphHelper.GetServicePrincipalByAppId(ctx, appID)
+	switch {
+	case errors.Is(err, graphhelper.ErrNotFound):
+		logger.Info("No service principal to delete", "appId", appID)
+	case err != nil:
+		return nil, appID, err
+	case sp.GetId() != nil:
+		plan = append(plan, plannedOperation{Operation: opDeleteServicePrincipal, Name: appName, ID: *sp.GetId()})
+	}
+
+	plan = append(plan, plannedOperation{Operation: opDeleteApplication, Name: appName, ID: appID})
+	return plan, appID, nil
+}
+
+func initializeGraph(logger *slog.Logger, graphHelper *graphhelper.GraphHelper, clientID, tenantID, clientSecret, requestTimeout string) error {
+	// requestTimeout bounds each Graph request attempt, e.g. "10s"
+	if requestTimeout != "" {
+		timeout, err := time.ParseDuration(requestTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid graph.requestTimeout %q: %w", requestTimeout, err)
+		}
+		graphHelper.SetRequestTimeout(timeout)
+	}
+
 	err := graphHelper.InitializeGraphForAppAuth(clientID, tenantID, clientSecret)
 	if err != nil {
-		log.Println("Error initializing Graph for app auth: ", err)
+		logger.Error("Error initializing Graph for app auth", "error", err)
 		return err
 	}
 	return nil
 }
 
-func getSSMParamValue(ctx context.Context, name string) (string, error) {
+func getSSMParamValue(ctx context.Context, logger *slog.Logger, name string) (string, error) {
 	withDecryption := true
 	resp, err := ssmClient.GetParameter(ctx, &ssm.GetParameterInput{
 		Name:           &name,
 		WithDecryption: &withDecryption,
 	})
 	if err != nil {
-		log.Println("Error getting parameter:", err)
+		logger.Error("Error getting parameter", "error", err)
 		return "", err
 	}
 	if resp == nil || resp.Parameter == nil {
-		log.Println("Parameter not found")
+		logger.Error("Parameter not found", "parameter", name)
 		return "", errors.New("parameter not found")
 	}
 	return *resp.Parameter.Value, nil
 }
 
 func main() {
-	lambda.Start(handleRequest)
+	lambda.Start(tracing.Wrap("DeleteServicePrincipal", tracerProvider, handleRequest))
 }
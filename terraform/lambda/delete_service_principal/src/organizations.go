@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/delete_service_principal/src/config"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/organizations"
+	"github.com/aws/aws-sdk-go-v2/service/organizations/types"
+)
+
+// errAccountRejected marks events from accounts that are not active members of
+// the organization
+var errAccountRejected = errors.New("account rejected")
+
+// accountInfo is what Organizations knows about an account
+type accountInfo struct {
+	ID     string
+	Name   string
+	Email  string
+	Status string
+	// OUPath is in the form of the aws:PrincipalOrgPaths condition key
+	OUPath string
+	Tags   map[string]string
+}
+
+// cached is a lookup kept across warm invocations until it expires
+type cached[T any] struct {
+	value   T
+	expires time.Time
+}
+
+var (
+	accountCache = map[string]cached[*accountInfo]{}
+	ouPaths      = map[string]cached[string]{}
+)
+
+// enrichAccount returns the account's details when the document turns on
+// enrichment, and nil otherwise. Accounts that are not in the organization or
+// not ACTIVE are rejected with an error wrapping errAccountRejected.
+func enrichAccount(ctx context.Context, doc *config.Document, account string) (*accountInfo, error) {
+	if !doc.Organizations.Enrich {
+		return nil, nil
+	}
+
+	info, err := describeAccount(ctx, account, doc.Organizations.CacheDuration())
+	if err != nil {
+		return nil, err
+	}
+	if info.Status != string(types.AccountStatusActive) {
+		return info, fmt.Errorf("%w: account %s is %s", errAccountRejected, account, info.Status)
+	}
+	return info, nil
+}
+
+// describeAccount looks up the account's name, email, status, tags and OU path
+func describeAccount(ctx context.Context, account string, ttl time.Duration) (*accountInfo, error) {
+	if entry, ok := accountCache[account]; ok && time.Now().Before(entry.expires) {
+		return entry.value, nil
+	}
+
+	client := organizations.NewFromConfig(awsCfg)
+
+	resp, err := client.DescribeAccount(ctx, &organizations.DescribeAccountInput{AccountId: &account})
+	var notFound *types.AccountNotFoundException
+	if errors.As(err, &notFound) {
+		return nil, fmt.Errorf("%w: account %s is not in the organization", errAccountRejected, account)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe account %s: %w", account, err)
+	}
+
+	info := &accountInfo{
+		ID:     account,
+		Name:   aws.ToString(resp.Account.Name),
+		Email:  aws.ToString(resp.Account.Email),
+		Status: string(resp.Account.Status),
+		Tags:   map[string]string{},
+	}
+
+	paginator := organizations.NewListTagsForResourcePaginator(client, &organizations.ListTagsForResourceInput{ResourceId: &account})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tags of account %s: %w", account, err)
+		}
+		for _, tag := range page.Tags {
+			info.Tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+		}
+	}
+
+	info.OUPath, err = accountOUPath(ctx, account, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	accountCache[account] = cached[*accountInfo]{value: info, expires: time.Now().Add(ttl)}
+	return info, nil
+}
+
+// ouPathIfNeeded returns the account's OU path when needed, and "" otherwise,
+// so Organizations is only called when a route or rule matches on OUs. An
+// enriched account already carries its OU path.
+func ouPathIfNeeded(ctx context.Context, doc *config.Document, info *accountInfo, account string, needed bool) (string, error) {
+	if info != nil {
+		return info.OUPath, nil
+	}
+	if !needed {
+		return "", nil
+	}
+	return accountOUPath(ctx, account, doc.Organizations.CacheDuration())
+}
+
+// accountOUPath returns the account's path in the organization in the form of
+// the aws:PrincipalOrgPaths condition key, e.g. o-a1b2c3d4e5/r-ab12/ou-ab12-11111111/.
+// The function's account must be the management account or a delegated
+// administrator for Organizations.
+func accountOUPath(ctx context.Context, account string, ttl time.Duration) (string, error) {
+	if entry, ok := ouPaths[account]; ok && time.Now().Before(entry.expires) {
+		return entry.value, nil
+	}
+
+	client := organizations.NewFromConfig(awsCfg)
+
+	// Walk up from the account to the root
+	var ids []string
+	child := account
+	for {
+		resp, err := client.ListParents(ctx, &organizations.ListParentsInput{ChildId: &child})
+		if err != nil {
+			return "", fmt.Errorf("failed to list parents of %s: %w", child, err)
+		}
+		if len(resp.Parents) == 0 {
+			return "", fmt.Errorf("%s has no parent in the organization", child)
+		}
+		parent := resp.Parents[0]
+		ids = append(ids, aws.ToString(parent.Id))
+		if parent.Type == types.ParentTypeRoot {
+			break
+		}
+		child = aws.ToString(parent.Id)
+	}
+
+	org, err := client.DescribeOrganization(ctx, &organizations.DescribeOrganizationInput{})
+	if err != nil {
+		return "", fmt.Errorf("failed to describe organization: %w", err)
+	}
+
+	var b strings.Builder
+	b.WriteString(aws.ToString(org.Organization.Id) + "/")
+	for i := len(ids) - 1; i >= 0; i-- {
+		b.WriteString(ids[i] + "/")
+	}
+
+	ouPaths[account] = cached[string]{value: b.String(), expires: time.Now().Add(ttl)}
+	return b.String(), nil
+}
+
+// nameFields returns the values of the naming template placeholders for a role.
+// The account fields stay empty unless the account was enriched.
+func nameFields(awsPartition, account, role string, info *accountInfo) config.Fields {
+	fields := config.Fields{Partition: awsPartition, Account: account, Role: role}
+	if info != nil {
+		fields.AccountName = info.Name
+		fields.AccountEmail = info.Email
+		fields.OUPath = info.OUPath
+		fields.AccountTags = info.Tags
+	}
+	return fields
+}
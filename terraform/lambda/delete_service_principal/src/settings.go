@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/delete_service_principal/src/config"
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/delete_service_principal/src/tenant"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+)
+
+var (
+	configLoader *config.Loader
+	// settings is the current configuration document and tenants the routing
+	// table built from it
+	settings *config.Document
+	tenants  *tenant.Table
+)
+
+// initSettings loads the configuration document during the init phase, so an
+// invalid document fails the cold start with the validation errors.
+func initSettings(ctx context.Context, logger *slog.Logger, cfg aws.Config) error {
+	loader, err := config.NewLoader(cfg, func(doc *config.Document) error {
+		_, err := tenantTable(doc)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	configLoader = loader
+
+	_, err = loadSettings(ctx, logger)
+	return err
+}
+
+// loadSettings returns the current configuration document, reloading it once
+// its TTL has passed.
+func loadSettings(ctx context.Context, logger *slog.Logger) (*config.Document, error) {
+	doc, err := configLoader.Get(ctx, logger)
+	if err != nil {
+		return nil, err
+	}
+	if doc != settings {
+		table, err := tenantTable(doc)
+		if err != nil {
+			return nil, err
+		}
+		settings, tenants = doc, table
+	}
+	return doc, nil
+}
+
+// tenantTable returns the document's tenant routing table, or a table with the
+// azure section as the only tenant.
+func tenantTable(doc *config.Document) (*tenant.Table, error) {
+	if len(doc.TenantRouting) > 0 {
+		return tenant.Parse(doc.TenantRouting)
+	}
+	return tenant.Single(tenant.Profile{
+		TenantID:        doc.Azure.TenantID,
+		ClientID:        doc.Azure.ClientID,
+		ClientSecretSSM: doc.Azure.ClientSecretSSM,
+		OIDCURL:         doc.Azure.OIDCURL,
+	}), nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/delete_service_principal/src/config"
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/delete_service_principal/src/graphhelper"
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/delete_service_principal/src/metrics"
+	"github.com/borkod/poc-aws-azure-oidc/tf-infra/lambda/delete_service_principal/src/tenant"
+)
+
+// graphHelperKey is everything a GraphHelper is initialized with
+type graphHelperKey struct {
+	profile        tenant.Profile
+	cloud          graphhelper.Cloud
+	requestTimeout string
+	clientSecret   string
+}
+
+// cachedGraphHelper is a GraphHelper kept across warm invocations, reused only
+// while its key is unchanged
+type cachedGraphHelper struct {
+	key         graphHelperKey
+	graphHelper *graphhelper.GraphHelper
+}
+
+// graphHelpers caches one GraphHelper per tenant, so warm invocations reuse the
+// credential's token and the Graph connections
+var graphHelpers = map[string]*cachedGraphHelper{}
+
+// tenantCloud returns the Microsoft cloud of the tenant, the document's
+// azure.cloud unless the profile names one
+func tenantCloud(doc *config.Document, profile tenant.Profile) (graphhelper.Cloud, error) {
+	if profile.Cloud != "" {
+		return graphhelper.CloudByName(profile.Cloud)
+	}
+	return graphhelper.CloudByName(doc.Azure.Cloud)
+}
+
+// tenantOIDCURL returns the IAM OIDC provider URL for the tenant: the profile's
+// URL, or else the issuer of tokens of the given version
+func tenantOIDCURL(profile tenant.Profile, cloud graphhelper.Cloud, tokenVersion int32) string {
+	if profile.OIDCURL != "" {
+		return profile.OIDCURL
+	}
+	return cloud.OIDCURL(profile.TenantID, tokenVersion)
+}
+
+// graphHelperFor returns a GraphHelper for the tenant. The client secret is read
+// on every invocation, and a cached GraphHelper is only reused while the secret
+// and settings are unchanged, so a rotated secret or reloaded configuration
+// takes effect immediately.
+func graphHelperFor(ctx context.Context, logger *slog.Logger, recorder *metrics.Recorder, doc *config.Document, profile tenant.Profile, cloud graphhelper.Cloud) (*graphhelper.GraphHelper, error) {
+	ssmStart := time.Now()
+	clientSecret, err := getSSMParamValue(ctx, logger, profile.ClientSecretSSM)
+	recorder.Duration(metrics.SSMLatency, time.Since(ssmStart))
+	if err != nil {
+		return nil, err
+	}
+
+	key := graphHelperKey{profile: profile, cloud: cloud, requestTimeout: doc.Graph.RequestTimeout, clientSecret: clientSecret}
+	if cached, ok := graphHelpers[profile.Name]; ok && cached.key == key {
+		cached.graphHelper.SetLogger(logger)
+		cached.graphHelper.SetRequestObserver(recorder)
+		return cached.graphHelper, nil
+	}
+
+	graphHelper := graphhelper.NewGraphHelper(logger)
+	graphHelper.SetCloud(cloud)
+	graphHelper.SetRequestObserver(recorder)
+
+	err = initializeGraph(logger, graphHelper, profile.ClientID, profile.TenantID, clientSecret, doc.Graph.RequestTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	graphHelpers[profile.Name] = &cachedGraphHelper{key: key, graphHelper: graphHelper}
+	return graphHelper, nil
+}
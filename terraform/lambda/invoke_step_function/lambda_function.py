@@ -10,10 +10,14 @@ logger.setLevel(logging.INFO)
 
 # Step Functions client
 sfn_client = boto3.client('stepfunctions')
+# Lambda client, for tag changes applied directly by the create function
+lambda_client = boto3.client('lambda')
 
 # Environment variables (Step Function ARNs)
 CREATE_ROLE_SFN_ARN = os.getenv("CREATE_ROLE_SFN_ARN")
 DELETE_ROLE_SFN_ARN = os.getenv("DELETE_ROLE_SFN_ARN")
+# Create Service Principal function, which applies entra: tag changes to existing apps
+UPDATE_ROLE_FUNCTION_ARN = os.getenv("UPDATE_ROLE_FUNCTION_ARN")
 
 def lambda_handler(event, context):
     """
@@ -23,15 +27,46 @@ def lambda_handler(event, context):
         # Extract fields safely
         account_number = event.get('account')
         event_name = event.get('detail', {}).get('eventName')
-        role_name = event.get('detail', {}).get('requestParameters', {}).get('roleName')
+        request_parameters = event.get('detail', {}).get('requestParameters', {})
+        response_elements = event.get('detail', {}).get('responseElements') or {}
+        role_name = request_parameters.get('roleName')
+        # CloudTrail event ID, logged by the Go handlers to correlate a run with its trigger
+        event_id = event.get('detail', {}).get('eventID')
+        # Region of the event, from which the Go handlers derive the partition of the ARNs they build
+        region = event.get('region')
+        # Principal that made the call, shown to approvers of sensitive accounts
+        user_identity = event.get('detail', {}).get('userIdentity') or {}
+        creator = user_identity.get('arn')
 
         logger.info(f"Received event for account: {account_number}, event: {event_name}, role: {role_name}")
 
         if event_name == "CreateRole":
-            return start_step_function(CREATE_ROLE_SFN_ARN, account_number, event_name, role_name)
+            # Forward the trust policy so the create workflow can skip roles that don't use our OIDC provider
+            extra = {
+                "assumeRolePolicyDocument": request_parameters.get('assumeRolePolicyDocument'),
+                "tags": request_parameters.get('tags'),
+                "roleArn": response_elements.get('role', {}).get('arn'),
+                "eventID": event_id,
+                "region": region,
+                "creator": creator,
+                # Caller identity, mapped to the Entra user that owns the new app
+                "userIdentity": user_identity or None
+            }
+            return start_step_function(CREATE_ROLE_SFN_ARN, account_number, event_name, role_name, extra)
 
         elif event_name == "DeleteRole":
-            return start_step_function(DELETE_ROLE_SFN_ARN, account_number, event_name, role_name)
+            return start_step_function(DELETE_ROLE_SFN_ARN, account_number, event_name, role_name, {"eventID": event_id, "region": region})
+
+        elif event_name in ("TagRole", "UntagRole"):
+            # Tag changes update the existing app without running the create workflow
+            extra = {
+                "tags": request_parameters.get('tags'),
+                "tagKeys": request_parameters.get('tagKeys'),
+                "eventID": event_id,
+                "region": region,
+                "creator": creator
+            }
+            return invoke_function(UPDATE_ROLE_FUNCTION_ARN, account_number, event_name, role_name, extra)
 
         else:
             logger.info(f"Ignoring unsupported eventName: {event_name}")
@@ -41,7 +76,7 @@ def lambda_handler(event, context):
         logger.error(f"Unhandled exception: {e}", exc_info=True)
         raise
 
-def start_step_function(state_machine_arn, account_number, event_name, role_name):
+def start_step_function(state_machine_arn, account_number, event_name, role_name, extra=None):
     """
     Starts an AWS Step Function execution.
     """
@@ -54,6 +89,8 @@ def start_step_function(state_machine_arn, account_number, event_name, role_name
         "eventName": event_name,
         "roleName": role_name
     }
+    if extra:
+        input_payload.update({k: v for k, v in extra.items() if v is not None})
 
     try:
         response = sfn_client.start_execution(
@@ -65,4 +102,33 @@ def start_step_function(state_machine_arn, account_number, event_name, role_name
 
     except ClientError as e:
         logger.error(f"Failed to start Step Function for event {event_name}: {e}")
-        raise
\ No newline at end of file
+        raise
+
+def invoke_function(function_arn, account_number, event_name, role_name, extra=None):
+    """
+    Invokes a Lambda function asynchronously, so Lambda retries failed invocations.
+    """
+    if not function_arn:
+        logger.error(f"Missing function ARN for event: {event_name}")
+        raise ValueError(f"Missing function ARN for event: {event_name}")
+
+    payload = {
+        "account": account_number,
+        "eventName": event_name,
+        "roleName": role_name
+    }
+    if extra:
+        payload.update({k: v for k, v in extra.items() if v is not None})
+
+    try:
+        lambda_client.invoke(
+            FunctionName=function_arn,
+            InvocationType="Event",
+            Payload=json.dumps(payload)
+        )
+        logger.info(f"Function invoked for '{event_name}': {function_arn}")
+        return {"status": "invoked", "functionArn": function_arn}
+
+    except ClientError as e:
+        logger.error(f"Failed to invoke function for event {event_name}: {e}")
+        raise
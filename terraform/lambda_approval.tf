@@ -0,0 +1,156 @@
+resource "aws_iam_role" "approval" {
+  name               = "${var.lambda_approval_name}-execution-role"
+  assume_role_policy = file("${path.module}/policy/lambda_trust_policy.json")
+}
+
+resource "aws_iam_policy" "approval_policy" {
+  name = "${var.lambda_approval_name}-policy"
+  description = "Grant permissions for lambda function ${var.lambda_approval_name}"
+  policy = templatefile("${path.module}/policy/lambda_approval_execution_role_policy.tpl", {
+    lambda_function_name = var.lambda_approval_name,
+    aws_region = var.aws_region,
+    aws_partition = data.aws_partition.current.partition,
+    aws_account = var.aws_account,
+    approval_table_arn = aws_dynamodb_table.approvals.arn,
+    approval_topic_arn = aws_sns_topic.approvals.arn
+  })
+}
+
+resource "aws_iam_role_policy_attachment" "approval_policy_attach" {
+  role       = "${aws_iam_role.approval.name}"
+  policy_arn = "${aws_iam_policy.approval_policy.arn}"
+}
+
+resource "aws_iam_role_policy_attachment" "approval_SSM_policy_attach" {
+  role       = "${aws_iam_role.approval.name}"
+  policy_arn = "${data.aws_iam_policy.AmazonSSMReadOnlyAccess.arn}"
+}
+
+resource "aws_iam_role_policy_attachment" "approval_KMS_policy_attach" {
+  role       = "${aws_iam_role.approval.name}"
+  policy_arn = "${data.aws_iam_policy.KMSReadOnlyAccess.arn}"
+}
+
+# Pending approvals and their task tokens, plus recent decisions for audit
+resource "aws_dynamodb_table" "approvals" {
+  name         = "${var.lambda_approval_name}-requests"
+  billing_mode = "PAY_PER_REQUEST"
+  hash_key     = "approvalId"
+
+  attribute {
+    name = "approvalId"
+    type = "S"
+  }
+
+  ttl {
+    attribute_name = "ttl"
+    enabled        = true
+  }
+
+  server_side_encryption {
+    enabled = true
+  }
+}
+
+resource "aws_sns_topic" "approvals" {
+  name = "${var.lambda_approval_name}-requests"
+}
+
+resource "aws_sns_topic_subscription" "approvals_email" {
+  for_each  = toset(var.approval_emails)
+  topic_arn = aws_sns_topic.approvals.arn
+  protocol  = "email"
+  endpoint  = each.value
+}
+
+data "archive_file" "approval_zip" {
+  type        = "zip"
+  source_file = "${path.module}/lambda/approval/bin/bootstrap"
+  output_path = "${path.module}/lambda/approval/zip/lambda.zip"
+}
+
+resource "aws_lambda_function" "approval" {
+  filename         = data.archive_file.approval_zip.output_path
+  function_name    = var.lambda_approval_name
+  role             = aws_iam_role.approval.arn
+  handler          = "bootstrap"
+  source_code_hash = data.archive_file.approval_zip.output_base64sha256
+
+  runtime       = "provided.al2023"
+  architectures = ["arm64"]
+  timeout       = 30
+  layers        = var.adot_layer_arn == "" ? [] : [var.adot_layer_arn]
+
+  # Active tracing continues the Step Functions trace into the function
+  tracing_config {
+    mode = var.traces_exporter == "none" ? "PassThrough" : "Active"
+  }
+
+  environment {
+    variables = merge({
+      APPROVAL_TABLE = aws_dynamodb_table.approvals.name
+      APPROVAL_TOPIC_ARN = aws_sns_topic.approvals.arn
+      APPROVAL_TIMEOUT = var.approval_timeout
+      APPROVAL_ACCOUNTS = join(",", var.approval_accounts)
+      APPROVAL_URL = var.approval_url
+      APPROVAL_URL_SSM = "${var.lambda_approval_name}-url"
+      APPROVAL_SIGNING_KEY_SSM = aws_ssm_parameter.approval_signing_key.name
+      LOG_LEVEL = var.log_level
+      METRICS_NAMESPACE = var.metrics_namespace
+      METRICS_DIMENSIONS = join(",", var.metrics_dimensions)
+      TRACES_EXPORTER = var.traces_exporter
+    }, var.otel_exporter_otlp_endpoint == "" ? {} : {
+      OTEL_EXPORTER_OTLP_ENDPOINT = var.otel_exporter_otlp_endpoint
+    })
+  }
+}
+
+# Signed approval links are served by the function itself; the signature, not
+# IAM, authorizes them
+resource "aws_lambda_function_url" "approval" {
+  function_name      = aws_lambda_function.approval.function_name
+  authorization_type = "NONE"
+}
+
+resource "aws_lambda_permission" "approval_url" {
+  statement_id           = "AllowPublicFunctionUrl"
+  action                 = "lambda:InvokeFunctionUrl"
+  function_name          = aws_lambda_function.approval.function_name
+  principal              = "*"
+  function_url_auth_type = "NONE"
+}
+
+# The function reads its own URL from here, as it can't reference it directly
+resource "aws_ssm_parameter" "approval_url" {
+  name        = "${var.lambda_approval_name}-url"
+  description = "Function URL of the approval Lambda"
+  type        = "String"
+  value       = aws_lambda_function_url.approval.function_url
+}
+
+resource "aws_ssm_parameter" "approval_signing_key" {
+  name        = "${var.lambda_approval_name}-signing-key"
+  description = "Key signing approval links"
+  type        = "SecureString"
+  value       = var.approval_signing_key
+}
+
+# Fails the tasks of approvals nobody decided in time
+resource "aws_cloudwatch_event_rule" "approval_expiry" {
+  name                = "${var.lambda_approval_name}-expiry"
+  description         = "Expire approvals past their deadline"
+  schedule_expression = var.approval_expiry_schedule
+}
+
+resource "aws_cloudwatch_event_target" "approval_expiry" {
+  rule = aws_cloudwatch_event_rule.approval_expiry.name
+  arn  = aws_lambda_function.approval.arn
+}
+
+resource "aws_lambda_permission" "approval_expiry" {
+  statement_id  = "AllowExpirySchedule"
+  action        = "lambda:InvokeFunction"
+  function_name = aws_lambda_function.approval.function_name
+  principal     = "events.amazonaws.com"
+  source_arn    = aws_cloudwatch_event_rule.approval_expiry.arn
+}
@@ -9,6 +9,7 @@ resource "aws_iam_policy" "assign_role_to_audience_policy" {
   policy = templatefile("${path.module}/policy/lambda_assign_role_to_audience_execution_role_policy.tpl", {
     lambda_function_name = var.lambda_assign_role_to_audience_name,
     aws_region = var.aws_region,
+    aws_partition = data.aws_partition.current.partition,
     aws_account = var.aws_account,
     aws_oidc_account = var.aws_oidc_account,
     aws_oidc_account_lambda_role = var.aws_oidc_account_lambda_role
@@ -38,7 +39,6 @@ resource "aws_lambda_function" "assign_role_to_audience" {
   environment {
     variables = {
       CROSS_ACCOUNT_ROLE_NAME = var.aws_oidc_account_lambda_role
-      OIDC_URL = var.oidc_url
     }
   }
 }
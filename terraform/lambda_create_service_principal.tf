@@ -9,7 +9,10 @@ resource "aws_iam_policy" "create_service_principal_policy" {
   policy = templatefile("${path.module}/policy/lambda_create_service_principal_execution_role_policy.tpl", {
     lambda_function_name = var.lambda_create_service_principal_name,
     aws_region = var.aws_region,
-    aws_account = var.aws_account
+    aws_partition = data.aws_partition.current.partition,
+    aws_account = var.aws_account,
+    aws_oidc_account = var.aws_oidc_account,
+    aws_oidc_account_lambda_role = var.aws_oidc_account_lambda_role
   })
 }
 
@@ -44,13 +47,52 @@ resource "aws_lambda_function" "create_service_principal" {
   runtime       = "provided.al2023"
   architectures = ["arm64"]
   timeout       = 30
+  layers        = var.adot_layer_arn == "" ? [] : [var.adot_layer_arn]
+
+  # Active tracing continues the Step Functions trace into the function
+  tracing_config {
+    mode = var.traces_exporter == "none" ? "PassThrough" : "Active"
+  }
 
   environment {
-    variables = {
+    variables = merge({
       CLIENT_ID = var.client_id
       OIDC_URL = var.oidc_url
       TENANT_ID = var.tenant_id
+      AZURE_CLOUD = var.azure_cloud
       CLIENT_SECRET_SSM = aws_ssm_parameter.secret.name
-    }
+      CROSS_ACCOUNT_ROLE_NAME = var.aws_oidc_account_lambda_role
+      AUDIENCE_PLACEHOLDER = var.audience_placeholder
+      REQUIRE_FEDERATION = var.require_federation
+      BIND_SUBJECT = var.bind_subject
+      BIND_CLAIMS = join(",", var.bind_claims)
+      ACCESS_TOKEN_VERSION = var.access_token_version
+      DRY_RUN = var.dry_run
+      LOG_LEVEL = var.log_level
+      GRAPH_REQUEST_TIMEOUT = var.graph_request_timeout
+      METRICS_NAMESPACE = var.metrics_namespace
+      METRICS_DIMENSIONS = join(",", var.metrics_dimensions)
+      TRACES_EXPORTER = var.traces_exporter
+      TENANT_ROUTING = var.tenant_routing == null ? "" : jsonencode(var.tenant_routing)
+      CONFIG_SOURCE = local.config_source
+      CONFIG_TTL = var.config_ttl
+      ORGANIZATIONS_ENRICH = var.organizations_enrich
+      ORGANIZATIONS_CACHE_TTL = var.organizations_cache_ttl
+      ASSIGN_OWNERS = var.assign_owners
+      OWNER_ROLE_TAG = var.owner_role_tag
+      OWNER_FALLBACK_GROUP_ID = var.owner_fallback_group_id
+      ASSIGNMENT_REQUIRED = var.assignment_required
+      ACCESS_APP_ROLE = var.access_app_role
+      ACCESS_PRINCIPALS = join(",", var.access_principals)
+      API_SCOPE = var.api_scope
+      PRE_AUTHORIZED_APPS = join(",", var.pre_authorized_apps)
+      HARDEN_APPS = var.harden_apps
+      APP_MANAGEMENT_POLICY_ID = var.app_management_policy_id
+      TOKEN_LIFETIME = var.token_lifetime == null ? "" : jsonencode(var.token_lifetime)
+      SECURITY_ATTRIBUTE_SET = var.security_attribute_set
+      SECURITY_ATTRIBUTES = length(var.security_attributes) == 0 ? "" : jsonencode(var.security_attributes)
+    }, var.otel_exporter_otlp_endpoint == "" ? {} : {
+      OTEL_EXPORTER_OTLP_ENDPOINT = var.otel_exporter_otlp_endpoint
+    })
   }
 }
@@ -9,6 +9,7 @@ resource "aws_iam_policy" "delete_service_principal_policy" {
   policy = templatefile("${path.module}/policy/lambda_delete_service_principal_execution_role_policy.tpl", {
     lambda_function_name = var.lambda_delete_service_principal_name,
     aws_region = var.aws_region,
+    aws_partition = data.aws_partition.current.partition,
     aws_account = var.aws_account
   })
 }
@@ -44,13 +45,33 @@ resource "aws_lambda_function" "delete_service_principal" {
   runtime       = "provided.al2023"
   architectures = ["arm64"]
   timeout       = 30
+  layers        = var.adot_layer_arn == "" ? [] : [var.adot_layer_arn]
+
+  # Active tracing continues the Step Functions trace into the function
+  tracing_config {
+    mode = var.traces_exporter == "none" ? "PassThrough" : "Active"
+  }
 
   environment {
-    variables = {
+    variables = merge({
       CLIENT_ID = var.client_id
       OIDC_URL = var.oidc_url
       TENANT_ID = var.tenant_id
+      AZURE_CLOUD = var.azure_cloud
       CLIENT_SECRET_SSM = aws_ssm_parameter.secret.name
-    }
+      DRY_RUN = var.dry_run
+      LOG_LEVEL = var.log_level
+      GRAPH_REQUEST_TIMEOUT = var.graph_request_timeout
+      METRICS_NAMESPACE = var.metrics_namespace
+      METRICS_DIMENSIONS = join(",", var.metrics_dimensions)
+      TRACES_EXPORTER = var.traces_exporter
+      TENANT_ROUTING = var.tenant_routing == null ? "" : jsonencode(var.tenant_routing)
+      CONFIG_SOURCE = local.config_source
+      CONFIG_TTL = var.config_ttl
+      ORGANIZATIONS_ENRICH = var.organizations_enrich
+      ORGANIZATIONS_CACHE_TTL = var.organizations_cache_ttl
+    }, var.otel_exporter_otlp_endpoint == "" ? {} : {
+      OTEL_EXPORTER_OTLP_ENDPOINT = var.otel_exporter_otlp_endpoint
+    })
   }
 }
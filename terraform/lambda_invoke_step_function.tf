@@ -9,8 +9,10 @@ resource "aws_iam_policy" "invoke_step_function_policy" {
   policy = templatefile("${path.module}/policy/lambda_invoke_step_function_execution_role_policy.tpl", {
     lambda_function_name = var.lambda_invoke_step_function_name,
     aws_region = var.aws_region,
+    aws_partition = data.aws_partition.current.partition,
     aws_account = var.aws_account,
-    aws_org_id = var.aws_org_id
+    aws_org_id = var.aws_org_id,
+    create_service_principal_name = var.lambda_create_service_principal_name
   })
 }
 
@@ -38,6 +40,7 @@ resource "aws_lambda_function" "invoke_step_function" {
     variables = {
       CREATE_ROLE_SFN_ARN = aws_sfn_state_machine.sfn_state_machine_create.arn
       DELETE_ROLE_SFN_ARN = aws_sfn_state_machine.sfn_state_machine_delete.arn
+      UPDATE_ROLE_FUNCTION_ARN = aws_lambda_function.create_service_principal.arn
     }
   }
 }
@@ -7,7 +7,7 @@
                 "lambda:InvokeFunction"
             ],
             "Resource": [
-                "arn:aws:lambda:${aws_region}:${aws_account}:function:${lambda_function_name}"
+                "arn:${aws_partition}:lambda:${aws_region}:${aws_account}:function:${lambda_function_name}"
             ]
         }
     ]
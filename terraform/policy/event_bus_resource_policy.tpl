@@ -7,7 +7,7 @@
       "Effect": "Allow",
       "Principal": "*",
       "Action": "events:PutEvents",
-      "Resource": "arn:aws:events:${aws_region}:${aws_account}:event-bus/${event_bus_name}",
+      "Resource": "arn:${aws_partition}:events:${aws_region}:${aws_account}:event-bus/${event_bus_name}",
       "Condition": {
         "ForAllValues:StringEquals": {
           "events:source": "aws.iam"
@@ -10,7 +10,7 @@
             "Action": "sts:AssumeRole",
             "Condition": {
                 "StringEquals": {
-                    "aws:SourceArn": "arn:aws:events:${aws_region}:${aws_account}:rule/${event_bus_name}/${rule_name}",
+                    "aws:SourceArn": "arn:${aws_partition}:events:${aws_region}:${aws_account}:rule/${event_bus_name}/${rule_name}",
                     "aws:SourceAccount": "${aws_account}"
                 }
             }
@@ -4,7 +4,7 @@
         {
             "Effect": "Allow",
             "Action": "logs:CreateLogGroup",
-            "Resource": "arn:aws:logs:${aws_region}:${aws_account}:*"
+            "Resource": "arn:${aws_partition}:logs:${aws_region}:${aws_account}:*"
         },
         {
             "Effect": "Allow",
@@ -13,7 +13,7 @@
                 "logs:PutLogEvents"
             ],
             "Resource": [
-                "arn:aws:logs:${aws_region}:${aws_account}:log-group:/aws/lambda/${lambda_function_name}:*"
+                "arn:${aws_partition}:logs:${aws_region}:${aws_account}:log-group:/aws/lambda/${lambda_function_name}:*"
             ]
         },
         {
@@ -23,7 +23,7 @@
                 "sts:AssumeRole"
             ],
             "Resource": [
-                "arn:aws:iam::${aws_oidc_account}:role/${aws_oidc_account_lambda_role}"
+                "arn:${aws_partition}:iam::${aws_oidc_account}:role/${aws_oidc_account_lambda_role}"
             ]
         }
     ]
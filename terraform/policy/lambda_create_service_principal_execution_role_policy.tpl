@@ -4,7 +4,7 @@
         {
             "Effect": "Allow",
             "Action": "logs:CreateLogGroup",
-            "Resource": "arn:aws:logs:${aws_region}:${aws_account}:*"
+            "Resource": "arn:${aws_partition}:logs:${aws_region}:${aws_account}:*"
         },
         {
             "Effect": "Allow",
@@ -13,7 +13,45 @@
                 "logs:PutLogEvents"
             ],
             "Resource": [
-                "arn:aws:logs:${aws_region}:${aws_account}:log-group:/aws/lambda/${lambda_function_name}:*"
+                "arn:${aws_partition}:logs:${aws_region}:${aws_account}:log-group:/aws/lambda/${lambda_function_name}:*"
+            ]
+        },
+        {
+            "Effect": "Allow",
+            "Action": [
+                "xray:PutTraceSegments",
+                "xray:PutTelemetryRecords",
+                "xray:GetSamplingRules",
+                "xray:GetSamplingTargets"
+            ],
+            "Resource": "*"
+        },
+        {
+            "Effect": "Allow",
+            "Action": [
+                "organizations:ListParents",
+                "organizations:DescribeOrganization",
+                "organizations:DescribeAccount",
+                "organizations:ListTagsForResource"
+            ],
+            "Resource": "*"
+        },
+        {
+            "Effect": "Allow",
+            "Action": [
+                "appconfig:StartConfigurationSession",
+                "appconfig:GetLatestConfiguration"
+            ],
+            "Resource": "*"
+        },
+        {
+            "Sid": "Statement1",
+            "Effect": "Allow",
+            "Action": [
+                "sts:AssumeRole"
+            ],
+            "Resource": [
+                "arn:${aws_partition}:iam::${aws_oidc_account}:role/${aws_oidc_account_lambda_role}"
             ]
         }
     ]
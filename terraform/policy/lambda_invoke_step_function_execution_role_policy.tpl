@@ -4,7 +4,7 @@
         {
             "Effect": "Allow",
             "Action": "logs:CreateLogGroup",
-            "Resource": "arn:aws:logs:${aws_region}:${aws_account}:*"
+            "Resource": "arn:${aws_partition}:logs:${aws_region}:${aws_account}:*"
         },
         {
             "Effect": "Allow",
@@ -13,7 +13,7 @@
                 "logs:PutLogEvents"
             ],
             "Resource": [
-                "arn:aws:logs:${aws_region}:${aws_account}:log-group:/aws/lambda/${lambda_function_name}:*"
+                "arn:${aws_partition}:logs:${aws_region}:${aws_account}:log-group:/aws/lambda/${lambda_function_name}:*"
             ]
         },
         {
@@ -25,6 +25,15 @@
             "Resource": [
                 "*"
             ]
+        },
+        {
+            "Effect": "Allow",
+            "Action": [
+                "lambda:InvokeFunction"
+            ],
+            "Resource": [
+                "arn:${aws_partition}:lambda:${aws_region}:${aws_account}:function:${create_service_principal_name}"
+            ]
         }
     ]
 }
\ No newline at end of file
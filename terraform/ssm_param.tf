@@ -3,4 +3,17 @@ resource "aws_ssm_parameter" "secret" {
   description = "Entra ID Client Secret"
   type        = "SecureString"
   value       = var.client_secret
-}
\ No newline at end of file
+}
+
+# Configuration document of the Go Lambdas, when managed by Terraform
+resource "aws_ssm_parameter" "config" {
+  count       = var.config_document == "" ? 0 : 1
+  name        = "oidc_automation_config"
+  description = "OIDC automation configuration document"
+  type        = "String"
+  value       = var.config_document
+}
+
+locals {
+  config_source = var.config_document == "" ? var.config_source : "ssm:${aws_ssm_parameter.config[0].name}"
+}
@@ -0,0 +1,131 @@
+{
+  "Comment": "Creates the Entra ID app of a web identity role and trusts its audience in the member account",
+  "StartAt": "Prepare",
+  "States": {
+    "Prepare": {
+      "Type": "Pass",
+      "Comment": "Keeps the workflow input and execution for the later steps, which read the input as sfnParam",
+      "Parameters": {
+        "sfnParam.$": "$$.Execution.Input",
+        "executionArn.$": "$$.Execution.Id"
+      },
+      "ResultPath": "$.execution",
+      "Next": "Approval"
+    },
+    "Approval": {
+      "Type": "Task",
+      "Comment": "Waits for a human decision on roles in sensitive accounts; other roles pass straight through",
+      "Resource": "arn:${partition}:states:::lambda:invoke.waitForTaskToken",
+      "Parameters": {
+        "FunctionName": "${approval_arn}",
+        "Payload": {
+          "taskToken.$": "$$.Task.Token",
+          "executionArn.$": "$$.Execution.Id",
+          "input.$": "$$.Execution.Input"
+        }
+      },
+      "ResultPath": "$.approval",
+      "TimeoutSeconds": ${approval_timeout_seconds},
+      "Catch": [
+        {
+          "ErrorEquals": ["ApprovalRejected", "ApprovalExpired", "States.Timeout"],
+          "ResultPath": "$.approvalError",
+          "Next": "Not Approved"
+        }
+      ],
+      "Next": "Create Service Principal"
+    },
+    "Not Approved": {
+      "Type": "Succeed",
+      "Comment": "The role was rejected or nobody decided in time; no app is created"
+    },
+    "Create Service Principal": {
+      "Type": "Task",
+      "Resource": "arn:${partition}:states:::lambda:invoke",
+      "Parameters": {
+        "FunctionName": "${create_service_principal_arn}",
+        "Payload.$": "States.JsonMerge($, $.execution, false)"
+      },
+      "ResultSelector": {
+        "result.$": "$.Payload"
+      },
+      "ResultPath": "$.create",
+      "Retry": [
+        {
+          "ErrorEquals": ["Lambda.ServiceException", "Lambda.AWSLambdaException", "Lambda.SdkClientException", "Lambda.TooManyRequestsException"],
+          "IntervalSeconds": 2,
+          "MaxAttempts": 3,
+          "BackoffRate": 2
+        }
+      ],
+      "Next": "Provisioned?"
+    },
+    "Provisioned?": {
+      "Type": "Choice",
+      "Comment": "Only an app the create step provisioned, outside a dry run, has an audience to trust",
+      "Choices": [
+        {
+          "And": [
+            { "Variable": "$.create.result.dryRun", "IsPresent": true },
+            { "Variable": "$.create.result.dryRun", "BooleanEquals": true }
+          ],
+          "Next": "Planned"
+        },
+        {
+          "And": [
+            { "Variable": "$.create.result.status", "IsPresent": true },
+            { "Variable": "$.create.result.status", "StringEquals": "success" }
+          ],
+          "Next": "Add Audience"
+        }
+      ],
+      "Default": "Skipped"
+    },
+    "Planned": {
+      "Type": "Succeed",
+      "Comment": "A dry run only plans Graph writes, so the member account is left alone too",
+      "OutputPath": "$.create.result"
+    },
+    "Skipped": {
+      "Type": "Succeed",
+      "Comment": "The create step skipped the role, for example because it doesn't federate with the OIDC provider",
+      "OutputPath": "$.create.result"
+    },
+    "Add Audience": {
+      "Type": "Task",
+      "Resource": "arn:${partition}:states:::lambda:invoke",
+      "Parameters": {
+        "FunctionName": "${add_audience_arn}",
+        "Payload.$": "States.JsonMerge($.create.result, $.execution, false)"
+      },
+      "ResultPath": null,
+      "Retry": [
+        {
+          "ErrorEquals": ["Lambda.ServiceException", "Lambda.AWSLambdaException", "Lambda.SdkClientException", "Lambda.TooManyRequestsException"],
+          "IntervalSeconds": 2,
+          "MaxAttempts": 3,
+          "BackoffRate": 2
+        }
+      ],
+      "Next": "Assign Role to Audience"
+    },
+    "Assign Role to Audience": {
+      "Type": "Task",
+      "Resource": "arn:${partition}:states:::lambda:invoke",
+      "Parameters": {
+        "FunctionName": "${assign_role_to_audience_arn}",
+        "Payload.$": "States.JsonMerge($.create.result, $.execution, false)"
+      },
+      "OutputPath": "$.Payload",
+      "Retry": [
+        {
+          "ErrorEquals": ["Lambda.ServiceException", "Lambda.AWSLambdaException", "Lambda.SdkClientException", "Lambda.TooManyRequestsException"],
+          "IntervalSeconds": 2,
+          "MaxAttempts": 3,
+          "BackoffRate": 2
+        }
+      ],
+      "End": true
+    }
+  }
+}
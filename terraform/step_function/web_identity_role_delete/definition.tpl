@@ -0,0 +1,86 @@
+{
+  "Comment": "Deletes the Entra ID app of a web identity role and removes its audience from the member account",
+  "StartAt": "Prepare",
+  "States": {
+    "Prepare": {
+      "Type": "Pass",
+      "Comment": "Keeps the workflow input and execution for the later steps, which read the input as sfnParam",
+      "Parameters": {
+        "sfnParam.$": "$$.Execution.Input",
+        "executionArn.$": "$$.Execution.Id"
+      },
+      "ResultPath": "$.execution",
+      "Next": "Delete Service Principal"
+    },
+    "Delete Service Principal": {
+      "Type": "Task",
+      "Resource": "arn:${partition}:states:::lambda:invoke",
+      "Parameters": {
+        "FunctionName": "${delete_service_principal_arn}",
+        "Payload.$": "States.JsonMerge($, $.execution, false)"
+      },
+      "ResultSelector": {
+        "result.$": "$.Payload"
+      },
+      "ResultPath": "$.delete",
+      "Retry": [
+        {
+          "ErrorEquals": ["Lambda.ServiceException", "Lambda.AWSLambdaException", "Lambda.SdkClientException", "Lambda.TooManyRequestsException"],
+          "IntervalSeconds": 2,
+          "MaxAttempts": 3,
+          "BackoffRate": 2
+        }
+      ],
+      "Next": "Deleted?"
+    },
+    "Deleted?": {
+      "Type": "Choice",
+      "Comment": "Only an app deleted outside a dry run has an audience to remove",
+      "Choices": [
+        {
+          "And": [
+            { "Variable": "$.delete.result.dryRun", "IsPresent": true },
+            { "Variable": "$.delete.result.dryRun", "BooleanEquals": true }
+          ],
+          "Next": "Planned"
+        },
+        {
+          "And": [
+            { "Variable": "$.delete.result.status", "IsPresent": true },
+            { "Variable": "$.delete.result.status", "StringEquals": "success" }
+          ],
+          "Next": "Remove Audience"
+        }
+      ],
+      "Default": "Skipped"
+    },
+    "Planned": {
+      "Type": "Succeed",
+      "Comment": "A dry run only plans Graph writes, so the member account is left alone too",
+      "OutputPath": "$.delete.result"
+    },
+    "Skipped": {
+      "Type": "Succeed",
+      "Comment": "No tenant had an app for the role",
+      "OutputPath": "$.delete.result"
+    },
+    "Remove Audience": {
+      "Type": "Task",
+      "Resource": "arn:${partition}:states:::lambda:invoke",
+      "Parameters": {
+        "FunctionName": "${remove_audience_arn}",
+        "Payload.$": "States.JsonMerge($.delete.result, $.execution, false)"
+      },
+      "OutputPath": "$.Payload",
+      "Retry": [
+        {
+          "ErrorEquals": ["Lambda.ServiceException", "Lambda.AWSLambdaException", "Lambda.SdkClientException", "Lambda.TooManyRequestsException"],
+          "IntervalSeconds": 2,
+          "MaxAttempts": 3,
+          "BackoffRate": 2
+        }
+      ],
+      "End": true
+    }
+  }
+}
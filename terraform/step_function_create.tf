@@ -14,6 +14,18 @@ resource "aws_iam_role_policy_attachment" "step_function_web_identity_create_pol
   policy_arn = "${aws_iam_policy.step_function_web_identity_create_policy.arn}"
 }
 
+locals {
+  # approval_timeout as seconds. The expiry sweep fails approvals nobody decided,
+  # so the task timeout only backs it up an hour later.
+  approval_timeout_parts   = regex("^(?:([0-9]+)h)?(?:([0-9]+)m)?(?:([0-9]+)s)?$", var.approval_timeout)
+  approval_timeout_seconds = (
+    tonumber(coalesce(local.approval_timeout_parts[0], "0")) * 3600 +
+    tonumber(coalesce(local.approval_timeout_parts[1], "0")) * 60 +
+    tonumber(coalesce(local.approval_timeout_parts[2], "0")) +
+    3600
+  )
+}
+
 resource "aws_sfn_state_machine" "sfn_state_machine_create" {
   name     = var.step_function_create_name
   role_arn = aws_iam_role.step_function_web_identity_create.arn
@@ -21,6 +33,13 @@ resource "aws_sfn_state_machine" "sfn_state_machine_create" {
   definition = templatefile("${path.module}/step_function/web_identity_role_create/definition.tpl", {
     create_service_principal_arn = aws_lambda_function.create_service_principal.arn,
     add_audience_arn = aws_lambda_function.add_audience.arn,
-    assign_role_to_audience_arn = aws_lambda_function.assign_role_to_audience.arn
+    assign_role_to_audience_arn = aws_lambda_function.assign_role_to_audience.arn,
+    approval_arn = aws_lambda_function.approval.arn,
+    approval_timeout_seconds = local.approval_timeout_seconds,
+    partition = data.aws_partition.current.partition
   })
+
+  tracing_configuration {
+    enabled = var.traces_exporter != "none"
+  }
 }
\ No newline at end of file
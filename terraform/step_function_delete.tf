@@ -21,5 +21,10 @@ resource "aws_sfn_state_machine" "sfn_state_machine_delete" {
   definition = templatefile("${path.module}/step_function/web_identity_role_delete/definition.tpl", {
     delete_service_principal_arn = aws_lambda_function.delete_service_principal.arn,
     remove_audience_arn          = aws_lambda_function.remove_audience.arn,
+    partition                    = data.aws_partition.current.partition,
   })
+
+  tracing_configuration {
+    enabled = var.traces_exporter != "none"
+  }
 }
\ No newline at end of file
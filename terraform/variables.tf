@@ -87,6 +87,278 @@ variable "oidc_url" {
   description = "Entra ID OIDC URL"
 }
 
+variable "audience_placeholder" {
+  type = string
+  default = "placeholder"
+  description = "Audience value users put in a new web identity role's trust policy to opt in to the automation"
+}
+
+variable "require_federation" {
+  type = bool
+  default = false
+  description = "Only create apps for roles whose trust policy federates with the OIDC provider and carries the placeholder audience"
+}
+
+variable "bind_subject" {
+  type = bool
+  default = false
+  description = "Restrict web identity roles to specific Entra principals through token claim conditions in the trust policy"
+}
+
+variable "bind_claims" {
+  type = list(string)
+  default = ["sub"]
+  description = "Token claims (sub, oid, appid) written into the trust policy when bind_subject is enabled"
+}
+
+variable "access_token_version" {
+  type = number
+  default = 1
+  description = "Access token version (1 or 2) requested by created apps. Version 2 requires oidc_url to be login.microsoftonline.com/<tenant_id>/v2.0"
+
+  validation {
+    condition     = contains([1, 2], var.access_token_version)
+    error_message = "access_token_version must be 1 or 2."
+  }
+}
+
+variable "dry_run" {
+  type = bool
+  default = false
+  description = "Plan the Entra ID changes without making them. The Go Lambdas return the Graph operations they would perform"
+}
+
+variable "log_level" {
+  type = string
+  default = "info"
+  description = "Log level for the Go Lambdas: debug, info, warn or error"
+
+  validation {
+    condition     = contains(["debug", "info", "warn", "error"], var.log_level)
+    error_message = "log_level must be one of debug, info, warn or error."
+  }
+}
+
+variable "graph_request_timeout" {
+  type = string
+  default = "10s"
+  description = "Timeout for each Microsoft Graph request attempt made by the Go Lambdas, as a Go duration"
+}
+
+variable "metrics_namespace" {
+  type = string
+  default = "OIDCAutomation"
+  description = "CloudWatch namespace for the metrics emitted by the Go Lambdas"
+}
+
+variable "metrics_dimensions" {
+  type = list(string)
+  default = []
+  description = "Extra dimensions for the Go Lambda metrics. Use Name=value for static dimensions (e.g. Environment=prod) or Account for the target account"
+}
+
+variable "traces_exporter" {
+  type = string
+  default = "none"
+  description = "Where the Go Lambdas send OpenTelemetry traces: none, otlp (to otel_exporter_otlp_endpoint) or xray (through the ADOT Lambda layer)"
+
+  validation {
+    condition     = contains(["none", "otlp", "xray"], var.traces_exporter)
+    error_message = "traces_exporter must be one of none, otlp or xray."
+  }
+}
+
+variable "otel_exporter_otlp_endpoint" {
+  type = string
+  default = ""
+  description = "OTLP/HTTP endpoint for traces when traces_exporter is otlp, e.g. https://otel-collector.example.com:4318"
+}
+
+variable "adot_layer_arn" {
+  type = string
+  default = ""
+  description = "ARN of the AWS Distro for OpenTelemetry collector Lambda layer, required when traces_exporter is xray"
+}
+
+variable "azure_cloud" {
+  type = string
+  default = "AzurePublic"
+  description = "Microsoft cloud hosting the Entra ID tenant. Selects the authority, Graph endpoint and token issuer used by the Go Lambdas"
+
+  validation {
+    condition     = contains(["AzurePublic", "AzureUSGovernment", "AzureUSGovernmentDoD", "AzureChina"], var.azure_cloud)
+    error_message = "azure_cloud must be one of AzurePublic, AzureUSGovernment, AzureUSGovernmentDoD or AzureChina."
+  }
+}
+
+variable "config_document" {
+  type = string
+  description = "JSON or YAML configuration document for the Go Lambdas, stored in SSM and used instead of the individual settings"
+  default = ""
+}
+
+variable "config_source" {
+  type = string
+  description = "Where the Go Lambdas read their configuration document: file:<path>, ssm:<name> or appconfig:<application>/<environment>/<profile>. Ignored when config_document is set"
+  default = ""
+
+  validation {
+    condition     = var.config_source == "" || can(regex("^(file|ssm|appconfig):.+", var.config_source))
+    error_message = "config_source must be empty or start with file:, ssm: or appconfig:."
+  }
+}
+
+variable "config_ttl" {
+  type = string
+  description = "How often the Go Lambdas reload their configuration document, as a Go duration; 0 loads it once per cold start"
+  default = "5m"
+}
+
+variable "organizations_enrich" {
+  type = bool
+  description = "Resolve account details through Organizations and reject events from accounts that are not active members"
+  default = false
+}
+variable "organizations_cache_ttl" {
+  type = string
+  description = "How long the Go Lambdas reuse account details from Organizations, as a Go duration"
+  default = "15m"
+}
+variable "assign_owners" {
+  type = bool
+  description = "Make the Entra user who created a role, or the one named by its owner tag, an owner of the role's app and service principal"
+  default = false
+}
+variable "owner_role_tag" {
+  type = string
+  description = "Role tag holding the space separated UPNs or emails of the app owners"
+  default = "entra:owners"
+}
+variable "owner_fallback_group_id" {
+  type = string
+  description = "Object ID of the Entra group whose members own apps whose creator can't be mapped to a user"
+  default = ""
+}
+variable "assignment_required" {
+  type = bool
+  description = "Require principals to be assigned the access app role before they can get tokens for an app"
+  default = false
+}
+variable "access_app_role" {
+  type = string
+  description = "Value of the app role that principals are assigned to get tokens for an app"
+  default = "Access"
+}
+variable "access_principals" {
+  type = list(string)
+  description = "Object IDs of the users, groups and service principals assigned the access app role of every app"
+  default = []
+}
+variable "api_scope" {
+  type = string
+  description = "Value of the delegated permission scope each app exposes with its identifier URI"
+  default = "user_impersonation"
+}
+variable "pre_authorized_apps" {
+  type = list(string)
+  description = "App IDs of the client apps, such as CI/CD platforms, pre-authorized for the scope of every app"
+  default = []
+}
+variable "harden_apps" {
+  type = bool
+  description = "Lock the service principal, limit sign-in to the tenant and clear redirect URIs of the apps, repairing apps that drift"
+  default = true
+}
+variable "app_management_policy_id" {
+  type = string
+  description = "Object ID of the Entra app management policy, such as one forbidding password and key credentials, assigned to every app"
+  default = ""
+}
+variable "token_lifetime" {
+  type = object({
+    policies = map(string)
+    default  = optional(string)
+    routes = optional(list(object({
+      policy   = string
+      accounts = optional(list(string))
+      ouPaths  = optional(list(string))
+      tags     = optional(map(string))
+    })), [])
+  })
+  description = "Token lifetime policies, as access token lifetimes such as 1h, and the routes that select one per account, OU path or role tags. When null, apps keep the tenant's default lifetime"
+  default = null
+}
+variable "security_attribute_set" {
+  type = string
+  description = "Attribute set holding the custom security attributes set on new service principals"
+  default = ""
+}
+variable "security_attributes" {
+  type = map(string)
+  description = "Custom security attribute names mapped to the template of each value, using the naming placeholders and {roleTag:<key>}"
+  default = {}
+}
+variable "tenant_routing" {
+  type = object({
+    default = string
+    tenants = map(object({
+      tenantId        = string
+      clientId        = string
+      clientSecretSsm = string
+      oidcUrl         = optional(string)
+      cloud           = optional(string)
+    }))
+    routes = optional(list(object({
+      tenant   = string
+      accounts = optional(list(string))
+      ouPaths  = optional(list(string))
+      tags     = optional(map(string))
+    })), [])
+  })
+  description = "Entra tenants and the routes that select one per account, OU path or role tags. When null, client_id, tenant_id and oidc_url make up the only tenant"
+  default = null
+}
+
+variable "lambda_approval_name" {
+  type = string
+  default = "approval"
+  description = "Name for Lambda function handling approvals of sensitive accounts"
+}
+variable "approval_accounts" {
+  type = list(string)
+  description = "Accounts whose roles wait for approval before an Entra app is created; empty requires approval for every role"
+  default = []
+}
+variable "approval_timeout" {
+  type = string
+  description = "How long an approval stays open before the workflow fails, as a Go duration"
+  default = "24h"
+
+  validation {
+    condition     = can(regex("^([0-9]+h)?([0-9]+m)?([0-9]+s)?$", var.approval_timeout)) && var.approval_timeout != ""
+    error_message = "approval_timeout must be a duration in hours, minutes and seconds, e.g. 24h or 1h30m."
+  }
+}
+variable "approval_expiry_schedule" {
+  type = string
+  description = "Schedule of the sweep that expires approvals nobody decided"
+  default = "rate(15 minutes)"
+}
+variable "approval_emails" {
+  type = list(string)
+  description = "Email addresses subscribed to approval requests"
+  default = []
+}
+variable "approval_url" {
+  type = string
+  description = "Base URL of approval links, when a custom domain fronts the approval Function URL"
+  default = ""
+}
+variable "approval_signing_key" {
+  type = string
+  description = "Key signing approval links, at least 32 characters"
+  sensitive = true
+}
 variable "tenant_id" {
   type = string
   description = "Entra ID Tenant ID"